@@ -1,49 +1,62 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"os"
 	"os/signal"
 	"runtime"
-	"strings"
-	"sync"
 	"syscall"
 	"text/tabwriter"
 	"time"
 
+	"github.com/ChaosHour/go-dump/go/dump"
 	"github.com/ChaosHour/go-dump/go/utils"
 
+	"github.com/ChaosHour/go-dump/go/log"
 	_ "github.com/go-sql-driver/mysql"
-	"github.com/outbrain/golib/log"
 )
 
-// normalizeTableName converts a table name like "schema.table" to "`schema`.`table`"
-func normalizeTableName(tableName string) string {
-	if strings.Contains(tableName, ".") {
-		parts := strings.Split(tableName, ".")
-		if len(parts) == 2 {
-			return fmt.Sprintf("`%s`.`%s`", parts[0], parts[1])
-		}
+// Exit codes for the kinds of errors of a dump, a restore or a verification.
+// The invalid options and any other error exit with 1.
+const (
+	exitConnectionError = 2
+	exitSchemaError     = 3
+	exitDataError       = 4
+	exitIOError         = 5
+	exitInterrupted     = 130
+)
+
+// exitCode return the exit code for the kind of the error.
+func exitCode(err error) int {
+	switch utils.ErrorKind(err) {
+	case utils.ErrConnection:
+		return exitConnectionError
+	case utils.ErrSchema:
+		return exitSchemaError
+	case utils.ErrData:
+		return exitDataError
+	case utils.ErrIO:
+		return exitIOError
+	case utils.ErrInterrupted:
+		return exitInterrupted
 	}
-	return tableName
+	return 1
 }
 
-// WaitGroup for the creation of the chunks
-var wgCreateChunks sync.WaitGroup
-
-// WaitGroup to process the chunks
-var wgProcessChunks sync.WaitGroup
-
-const AppVersion string = "0.01"
+// fatalError logs the message with the error and exits with the code of the
+// kind of the error.
+func fatalError(err error, message string) {
+	log.With("error", err, "exit_code", exitCode(err)).Errorf("%s: %s", message, err.Error())
+	os.Exit(exitCode(err))
+}
 
-func GetDumpOptions() *utils.DumpOptions {
-	return &utils.DumpOptions{
-		MySQLHost:        new(utils.MySQLHost),
-		MySQLCredentials: new(utils.MySQLCredentials),
-	}
+// isTerminal return true if the file is a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
 }
 
 func printOption(w io.Writer, f *flag.Flag) {
@@ -59,16 +72,19 @@ func printOption(w io.Writer, f *flag.Flag) {
 func PrintUsage(flags map[string]*flag.Flag) {
 
 	w := tabwriter.NewWriter(os.Stdout, 30, 0, 1, ' ', tabwriter.TabIndent)
-	fmt.Fprintln(w, "Usage: go-dump  --destination path [--databases str] [--tables str] [--all-databases] [--dry-run | --execute ] [--help] [--debug] [--quiet] [--version] [--lock-tables] [--consistent] [--isolation-level str] [--channel-buffer-size num] [--chunk-size num] [--tables-without-uniquekey str] [--threads num] [--mysql-user str] [--mysql-password str] [--mysql-host str] [--mysql-port num] [--mysql-socket path] [--add-drop-table] [--get-master-status] [--get-slave-status] [--output-chunk-size num] [--skip-use-database] [--compress] [--compress-level] [--where str] [--ini-files str]")
+	fmt.Fprintln(w, "Usage: go-dump  --destination path [--databases str] [--tables str] [--all-databases] [--include str] [--exclude str] [--exclude-databases str] [--dry-run | --execute ] [--help] [--debug] [--quiet] [--version] [--progress-interval duration] [--progress-bar] [--metrics-listen str] [--log-format str] [--log-file path] [--lock-tables] [--consistent] [--isolation-level str] [--channel-buffer-size num] [--chunk-size num] [--tables-without-uniquekey str] [--threads num] [--mysql-user str] [--mysql-password str] [--mysql-host str] [--mysql-port num] [--mysql-socket path] [--add-drop-table] [--get-master-status] [--get-slave-status] [--output-chunk-size num] [--max-statement-bytes num] [--output-format str] [--csv-delimiter str] [--csv-quote str] [--csv-null str] [--skip-use-database] [--row-checksum] [--views] [--triggers] [--routines] [--events] [--no-data] [--no-create-info] [--dump-grants] [--grants-users str] [--chunks-per-file num] [--resume] [--s3-endpoint str] [--s3-region str] [--s3-access-key str] [--s3-secret-key str] [--s3-path-style] [--s3-part-size num] [--s3-max-retries num] [--compress] [--compress-algorithm str] [--compress-level] [--encrypt] [--encrypt-key-file path] [--encrypt-passphrase str] [--where str] [--ini-files str]")
 
 	fmt.Fprintln(w, "go-dump dumps a database or a table from a MySQL server and creates the SQL statements to recreate a table. This tool create one file per table per thread in the destination directory")
+	fmt.Fprintln(w, "Use \"go-dump restore --help\" to see how to load a dump.")
+	fmt.Fprintln(w, "Use \"go-dump verify --help\" to see how to compare a dump with a server.")
+	fmt.Fprintln(w, "The destination can also be an S3 compatible storage, like s3://bucket/prefix.")
 	fmt.Fprint(w, "Example: go-dump --destination /tmp/dbdump --databases mydb --mysql-user myuser --mysql-password password\n\n")
 	fmt.Fprint(w, "Options description\n\n")
 
 	fmt.Fprintln(w, "# General:")
 	for _, opt := range []string{"help", "dry-run", "execute", "debug", "quiet", "version",
 		"lock-tables", "channel-buffer-size", "chunk-size", "tables-without-uniquekey",
-		"threads", "compress", "compress-level", "consistent", "isolation-level", "where", "ini-file"} {
+		"threads", "resume", "progress-interval", "progress-bar", "metrics-listen", "log-format", "log-file", "compress", "compress-algorithm", "compress-level", "encrypt", "encrypt-key-file", "encrypt-passphrase", "consistent", "isolation-level", "where", "ini-file"} {
 		printOption(w, flags[opt])
 	}
 
@@ -78,97 +94,82 @@ func PrintUsage(flags map[string]*flag.Flag) {
 	}
 
 	fmt.Fprintln(w, "\n# Databases or tables to dump:")
-	for _, opt := range []string{"all-databases", "databases", "tables"} {
+	for _, opt := range []string{"all-databases", "databases", "tables", "include", "exclude", "exclude-databases"} {
 		printOption(w, flags[opt])
 	}
 	fmt.Fprintln(w, "\n# Output options:")
-	for _, opt := range []string{"destination", "add-drop-table", "get-master-status", "get-slave-status", "output-chunk-size", "skip-use-database"} {
+	for _, opt := range []string{"destination", "add-drop-table", "get-master-status", "get-slave-status", "output-chunk-size", "max-statement-bytes", "output-format", "csv-delimiter", "csv-quote", "csv-null", "skip-use-database", "row-checksum", "views", "triggers", "routines", "events", "no-data", "no-create-info", "dump-grants", "grants-users", "chunks-per-file"} {
+		printOption(w, flags[opt])
+	}
+
+	fmt.Fprintln(w, "\n# S3 options:")
+	for _, opt := range []string{"s3-endpoint", "s3-region", "s3-access-key", "s3-secret-key", "s3-path-style", "s3-part-size", "s3-max-retries"} {
 		printOption(w, flags[opt])
 	}
 	w.Flush()
 }
 
-var dumpOptions = GetDumpOptions()
-var flagSet = make(map[string]bool)
-
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		restoreMain(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		verifyMain(os.Args[2:])
+		return
+	}
+
 	startExecution := time.Now()
 
 	var (
-		flagHelp, flagVersion bool
-		flagIniFile           string
+		flagHelp, flagVersion, flagDebug, flagQuiet, flagDryRun, flagExecute, flagProgressBar bool
+		flagIniFile, flagMetricsListen, flagLogFile, flagLogFormat                            string
 	)
 
-	var consitent = true
-	flag.StringVar(&dumpOptions.TemporalOptions.Tables, "tables", "", "List of comma separated tables to dump. Each table should have the database name included, for example \"mydb.mytable,mydb2.mytable2\".")
-	flag.StringVar(&dumpOptions.TemporalOptions.Databases, "databases", "", "List of comma separated databases to dump.")
-	flag.BoolVar(&dumpOptions.TemporalOptions.AllDatabases, "all-databases", false, "Dump all the databases.")
-	flag.StringVar(&dumpOptions.MySQLHost.HostName, "mysql-host", "localhost", "MySQL hostname.")
-	flag.StringVar(&dumpOptions.MySQLHost.SocketFile, "mysql-socket", "", "MySQL socket file.")
-	flag.IntVar(&dumpOptions.MySQLHost.Port, "mysql-port", 3306, "MySQL port number")
-	flag.StringVar(&dumpOptions.MySQLCredentials.User, "mysql-user", "root", "MySQL user name.")
-	flag.StringVar(&dumpOptions.MySQLCredentials.Password, "mysql-password", "", "MySQL password.")
-	flag.IntVar(&dumpOptions.Threads, "threads", 1, "Number of threads to use.")
-	flag.Uint64Var(&dumpOptions.ChunkSize, "chunk-size", 1000, "Chunk size to get the rows.")
-	flag.Uint64Var(&dumpOptions.OutputChunkSize, "output-chunk-size", 0, "Chunk size to output the rows.")
-	flag.IntVar(&dumpOptions.ChannelBufferSize, "channel-buffer-size", 1000, "Task channel buffer size.")
-	flag.BoolVar(&dumpOptions.LockTables, "lock-tables", true, "Lock tables to get consistent backup.")
-	flag.StringVar(&dumpOptions.TablesWithoutUKOption, "tables-without-uniquekey", "error", "Action to have with tables without any primary or unique key. Valid actions are: 'error', 'single-chunk'.")
-	flag.BoolVar(&dumpOptions.TemporalOptions.Debug, "debug", false, "Display debug information.")
-	flag.StringVar(&dumpOptions.DestinationDir, "destination", "", "Directory to store the dumps.")
+	options := dump.DefaultOptions()
+	options.AddFlags(flag.CommandLine)
+	flag.BoolVar(&flagDebug, "debug", false, "Display debug information.")
 	flag.BoolVar(&flagHelp, "help", false, "Display this message.")
 	flag.BoolVar(&flagVersion, "version", false, "Display version and exit.")
-	flag.BoolVar(&dumpOptions.TemporalOptions.DryRun, "dry-run", false, "Just calculate the number of chaunks per table and display it.")
-	flag.BoolVar(&dumpOptions.TemporalOptions.Execute, "execute", false, "Execute the dump.")
-	flag.BoolVar(&dumpOptions.SkipUseDatabase, "skip-use-database", false, "Skip USE \"database\" in the dump.")
-	flag.BoolVar(&dumpOptions.GetMasterStatus, "get-master-status", false, "Get the master data.")
-	flag.BoolVar(&dumpOptions.GetSlaveStatus, "get-slave-status", false, "Get the slave data.")
-	flag.BoolVar(&dumpOptions.AddDropTable, "add-drop-table", false, "Add drop table before create table.")
-	flag.BoolVar(&dumpOptions.Compress, "compress", false, "Enable compression to the output files.")
-	flag.IntVar(&dumpOptions.CompressLevel, "compress-level", 1, "Compression level from 1 (best speed) to 9 (best compression).")
-	flag.BoolVar(&dumpOptions.TemporalOptions.Quiet, "quiet", false, "Do not display INFO messages during the process.")
-	flag.StringVar(&dumpOptions.TemporalOptions.IsolationLevel, "isolation-level", "REPEATABLE READ", "Isolation level to use. If you need a consitent backup, leave the default 'REPEATABLE READ', other options READ COMMITTED, READ UNCOMMITTED and SERIALIZABLE.")
-	flag.BoolVar(&dumpOptions.Consistent, "consistent", true, "Get a consistent backup.")
-	var dummyWhere string
-	flag.StringVar(&dummyWhere, "where", "", "Custom WHERE condition for selective dumping (e.g., \"status = 'active'\" or \"table:condition,table2:condition2\").")
+	flag.BoolVar(&flagDryRun, "dry-run", false, "Just calculate the number of chaunks per table and display it.")
+	flag.BoolVar(&flagExecute, "execute", false, "Execute the dump.")
+	flag.BoolVar(&flagQuiet, "quiet", false, "Do not display INFO messages during the process.")
+	flag.BoolVar(&flagProgressBar, "progress-bar", false, "Display the progress in a single line instead of the log lines, when the output is a terminal.")
+	flag.StringVar(&flagMetricsListen, "metrics-listen", "", "Address like \":9104\" to serve the Prometheus metrics of the dump in /metrics while it runs.")
+	flag.StringVar(&flagLogFormat, "log-format", log.FormatText, "Format of the log: 'text', or 'json' for a JSON object per line with the fields of the events, like the table, the chunk, the worker, the duration or the error.")
+	flag.StringVar(&flagLogFile, "log-file", "", "File where the log is appended instead of the standard error.")
 	flag.StringVar(&flagIniFile, "ini-file", "", "INI file to read the configuration options.")
 
 	flag.Parse()
 
-	// Parse WHERE conditions from command line
-	if dummyWhere != "" {
-		whereValue := dummyWhere
-		if strings.Contains(whereValue, ":") {
-			// Table-specific: "table:condition,table2:condition2"
-			parts := strings.Split(whereValue, ",")
-			if dumpOptions.WhereConditions == nil {
-				dumpOptions.WhereConditions = make(map[string]string)
-			}
-			for _, part := range parts {
-				if tableCond := strings.SplitN(strings.TrimSpace(part), ":", 2); len(tableCond) == 2 {
-					normalizedTableName := normalizeTableName(tableCond[0])
-					dumpOptions.WhereConditions[normalizedTableName] = tableCond[1]
-				}
-			}
-		} else {
-			// Global WHERE condition
-			dumpOptions.GlobalWhereCondition = whereValue
+	// Parse the ini file.
+	if flagIniFile != "" {
+		if err := dump.ParseIniFile(flagIniFile, flag.CommandLine); err != nil {
+			fatalError(err, "Error reading the options")
 		}
 	}
 
-	// Collect the flags that were assigned from the command line.
-	flag.Visit(func(f *flag.Flag) { flagSet[f.Name] = true })
-
-	// Parse the ini file.
-	if flagIniFile != "" {
-		utils.ParseIniFile(flagIniFile, dumpOptions, flagSet)
+	if err := log.SetFormat(flagLogFormat); err != nil {
+		log.Fatalf("%s. Use --help for more information.", err.Error())
+	}
+	if flagLogFile != "" {
+		logFile, err := os.OpenFile(flagLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			fatalError(&utils.DumpError{Kind: utils.ErrIO, Err: err}, "Error opening the log file")
+		}
+		defer logFile.Close()
+		log.SetOutput(logFile)
 	}
 
 	flags := make(map[string]*flag.Flag)
+	flagsSet := make(map[string]bool)
 
 	flag.CommandLine.VisitAll(func(f *flag.Flag) {
 		flags[f.Name] = f
 	})
+	flag.CommandLine.Visit(func(f *flag.Flag) {
+		flagsSet[f.Name] = true
+	})
 
 	// Print the help message and exit.
 	if flagHelp {
@@ -178,198 +179,107 @@ func main() {
 
 	// Print the version and exit.
 	if flagVersion {
-		fmt.Println("go-dump version:", AppVersion)
+		fmt.Println("go-dump version:", dump.Version)
 		return
 	}
 
 	//Setting debug level
-	if dumpOptions.TemporalOptions.Debug {
+	if flagDebug {
 		log.SetLevel(log.DEBUG)
-	} else if dumpOptions.TemporalOptions.Quiet {
+	} else if flagQuiet {
 		log.SetLevel(log.WARNING)
 	} else {
 		log.SetLevel(log.INFO)
 	}
 
-	// Added the signal for the `Ctl c` command.
-	c := make(chan os.Signal, 2)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-c
-		log.Fatalf("Killing the dumper.")
-	}()
-
-	// Parsed TablesWithoutUKOption options
-	switch dumpOptions.TablesWithoutUKOption {
-	case "error", "single-chunk":
-		log.Debugf("The method to use with the tables without primary or unique key is \"%s\".",
-			dumpOptions.TablesWithoutUKOption)
-	case "skip":
-		log.Fatalf("The option for --tables-without-pk \"skip\" is not implemented yet.")
-	default:
-		log.Fatalf("Error: \"%s\" is not a valid option for --tables-without-pk.",
-			dumpOptions.TablesWithoutUKOption)
-		PrintUsage(flags)
+	if flagDryRun && flagExecute {
+		log.Fatalf("Flags --dry-run and --execute are mutually exclusive")
 	}
-
-	// Making sure that if LockTables is false, consistent must be false as well.
-	if !dumpOptions.LockTables && dumpOptions.Consistent {
-		log.Fatalf("Lock tables is required to get a consitent backup. Use --help for more information.")
+	if options.Resume && !flagExecute {
+		log.Fatal("The option --resume needs --execute")
 	}
-
-	if dumpOptions.DestinationDir == "" {
-		log.Fatal("--destination dir is required, use --help for more information.")
+	if options.NoData && (options.GetMasterStatus || options.GetSlaveStatus) {
+		log.Warning("The master and slave status are not collected with --no-data")
 	}
 
-	// Parsed isolation level.
-	switch strings.ToUpper(dumpOptions.TemporalOptions.IsolationLevel) {
-	case "SERIALIZABLE":
-		dumpOptions.IsolationLevel = sql.LevelSerializable
-	case "REPEATABLE READ":
-		dumpOptions.IsolationLevel = sql.LevelRepeatableRead
-	case "READ COMMITTED":
-		dumpOptions.IsolationLevel = sql.LevelReadCommitted
-		consitent = false
-	case "READ UNCOMMITTED":
-		dumpOptions.IsolationLevel = sql.LevelReadUncommitted
-		consitent = false
-	default:
-		log.Fatalf("Unknown issolation level %s. Use --help for more information.", dumpOptions.TemporalOptions.IsolationLevel)
+	if flagMetricsListen != "" && flagExecute {
+		metrics, err := serveMetrics(flagMetricsListen)
+		if err != nil {
+			fatalError(err, "Error serving the metrics")
+		}
+		options.Metrics = metrics
 	}
 
-	// Parsed consistent and making sure that the isolation level is correct.
-	if !consitent && dumpOptions.Consistent {
-		log.Fatalf("Isolation level \"%s\" is not compatible with the --consitent option. Use --help for more information.",
-			dumpOptions.TemporalOptions.IsolationLevel)
-	}
-	// Setting OutputChunkSize to the same value as ChunkSize
-	// if the OutputChunkSize is 0
-	if dumpOptions.OutputChunkSize == 0 {
-		dumpOptions.OutputChunkSize = dumpOptions.ChunkSize
+	// The bar is redrawn every second unless the interval is set.
+	if flagProgressBar && isTerminal(os.Stderr) && options.ProgressInterval > 0 {
+		if !flagsSet["progress-interval"] {
+			options.ProgressInterval = time.Second
+		}
+		options.OnProgress = utils.NewProgressBar(os.Stderr).Update
 	}
 
-	if dumpOptions.CompressLevel < 1 || dumpOptions.CompressLevel > 9 {
-		log.Fatal("The option --compress-level must be a number between 1 and 9")
+	dumper, err := dump.New(options)
+	if err != nil {
+		log.Fatalf("%s. Use --help for more information.", err.Error())
 	}
 
-	// Creating the buffer for the channel
-	cDataChunk := make(chan utils.DataChunk, dumpOptions.ChannelBufferSize)
-
 	// Checking the number of cores and comparing with the threads option.
 	cores := runtime.NumCPU()
-	if dumpOptions.Threads > cores {
+	if options.Threads > cores {
 		log.Warningf("The number of cores available is %d and the number of threads requested were %d.",
-			cores, dumpOptions.Threads)
+			cores, options.Threads)
 	}
 
 	// Setting up the concurrency to use.
-	runtime.GOMAXPROCS(dumpOptions.Threads)
-
-	tmdb, err := utils.GetMySQLConnection(dumpOptions.MySQLHost, dumpOptions.MySQLCredentials)
-	if err != nil {
-		log.Critical("Error whith the database connection. %s", err.Error())
-	}
-	// Creating the Task Manager.
-	taskManager := utils.NewTaskManager(
-		&wgCreateChunks,
-		&wgProcessChunks,
-		cDataChunk,
-		tmdb,
-		dumpOptions)
-
-	// Making the lists of tables. Either from a database or the tables paramenter.
-	var tablesFromDatabases, tablesFromString, tablesToParse map[string]bool
-
-	dbchunks, err := utils.GetMySQLConnection(dumpOptions.MySQLHost, dumpOptions.MySQLCredentials)
-
-	if err != nil {
-		log.Critical("Error with the database connection: %s", err.Error())
-	}
-	log.Debug("Error TablesFromDatabase: ", err)
-
-	if dumpOptions.TemporalOptions.AllDatabases {
-		tablesToParse = utils.TablesFromAllDatabases(dbchunks)
-	} else {
-		if len(dumpOptions.TemporalOptions.Databases) > 0 {
-			tablesFromDatabases = utils.TablesFromDatabase(dumpOptions.TemporalOptions.Databases, dbchunks)
-			log.Debugf("tablesFromDatabases: %v ", tablesFromDatabases)
+	runtime.GOMAXPROCS(options.Threads)
+
+	// The first SIGINT or SIGTERM stops the dump: no more chunks are dumped,
+	// the files are closed and the checkpoint is kept. The second one kills
+	// the dumper.
+	ctx, cancel := interruptContext("dump")
+	defer cancel(nil)
+
+	if flagDryRun {
+		plan, err := dumper.Plan(ctx)
+		if err != nil {
+			fatalError(err, "Error creating the chunks")
 		}
-
-		if len(dumpOptions.TemporalOptions.Tables) > 0 {
-			tablesFromString = utils.TablesFromString(dumpOptions.TemporalOptions.Tables)
-		}
-
-		// Merging both lists (tables and databases)
-		if len(tablesFromDatabases) > 0 {
-			tablesToParse = tablesFromDatabases
-		}
-		if len(tablesFromString) > 0 {
-			if len(tablesToParse) > 0 {
-				for table := range tablesFromString {
-					if _, ok := tablesToParse[table]; !ok {
-						tablesToParse[table] = true
-					}
-				}
-			} else {
-				tablesToParse = tablesFromString
-			}
+		for _, table := range plan.Tables {
+			fmt.Printf("   %d -> %s\n", len(table.Chunks), table.FullName())
 		}
 	}
 
-	// Adding the utils to the task manager.
-	// We create one task per table
-	for table := range tablesToParse {
-		t := strings.Split(table, ".")
-		task := utils.NewTask(
-			t[0], t[1],
-			dumpOptions.ChunkSize,
-			dumpOptions.OutputChunkSize,
-			&taskManager)
-		task.PrintInfo()
-		taskManager.AddTask(&task)
-		log.Debugf("Table: %+v", task.Table)
-	}
-
-	log.Debugf("Added %d connections to the taskManager", dumpOptions.Threads)
-
-	taskManager.AddWorkersDB()
-
-	// Creating the chunks from the tables.
-	taskManager.CreateChunksWaitGroup.Add(1)
-
-	go taskManager.CreateChunks(dbchunks)
-
-	if dumpOptions.TemporalOptions.DryRun && dumpOptions.TemporalOptions.Execute {
-		log.Fatalf("Flags --dry-run and --execute are mutually exclusive")
-
-	}
-	go taskManager.PrintStatus()
-
-	if dumpOptions.TemporalOptions.DryRun {
-		go taskManager.CleanChunkChannel()
-		taskManager.CreateChunksWaitGroup.Wait()
-		close(taskManager.ChunksChannel)
-		taskManager.DisplaySummary()
-	}
-
-	if dumpOptions.TemporalOptions.Execute {
-		if err := os.MkdirAll(dumpOptions.DestinationDir, 0755); err != nil {
-			log.Fatalf("Error creating directory: %s\n%s",
-				dumpOptions.DestinationDir, err.Error())
+	if flagExecute {
+		result, err := dumper.Run(ctx)
+		if err != nil {
+			fatalError(err, "The dump failed")
 		}
-		taskManager.GetTransactions(dumpOptions.LockTables, dumpOptions.TemporalOptions.AllDatabases)
-
-		taskManager.StartWorkers()
-		log.Debugf("ProcessChunksWaitGroup, %+v", taskManager.ProcessChunksWaitGroup)
-		taskManager.CreateChunksWaitGroup.Wait()
-		close(taskManager.ChunksChannel)
-		taskManager.ProcessChunksWaitGroup.Wait()
-		taskManager.WriteTablesSQL(dumpOptions.AddDropTable)
-		log.Info("Waiting for the creation of all the chunks.")
+		log.With("rows", result.Rows, "tables", result.Tables, "chunks", result.Chunks, "bytes", result.Bytes,
+			"destination", result.Destination, "duration", result.Duration).Infof(
+			"Dumped %d rows of %d tables in %d chunks to %s", result.Rows, result.Tables,
+			result.Chunks, result.Destination)
 	}
 
 	executionTime := time.Since(startExecution)
 
-	log.Infof("Execution time: %s  ", executionTime.String())
+	log.With("duration", executionTime).Infof("Execution time: %s  ", executionTime.String())
+
+}
 
+// interruptContext return a context canceled by the first SIGINT or SIGTERM
+// so the process stops cleanly. The second signal exits at once.
+func interruptContext(process string) (context.Context, context.CancelCauseFunc) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-c
+		log.With("signal", sig.String()).Warningf("Got the signal %s, stopping the %s. Send it again to exit at once.",
+			sig, process)
+		cancel(fmt.Errorf("got the signal %s", sig))
+		sig = <-c
+		log.Errorf("Got the signal %s again, exiting.", sig)
+		os.Exit(exitInterrupted)
+	}()
+	return ctx, cancel
 }
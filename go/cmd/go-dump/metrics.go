@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/ChaosHour/go-dump/go/log"
+	"github.com/ChaosHour/go-dump/go/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// serveMetrics serves the metrics of the dump, with the ones of the Go
+// runtime and the process, in /metrics of the address. The server runs
+// until the process exits.
+func serveMetrics(address string) (*utils.Metrics, error) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	metrics, err := utils.NewMetrics(registry)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, &utils.DumpError{Kind: utils.ErrIO, Err: fmt.Errorf("error listening on %s: %w", address, err)}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	go func() {
+		if err := http.Serve(listener, mux); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorf("Error serving the metrics: %s", err.Error())
+		}
+	}()
+	log.Infof("Serving the metrics on http://%s/metrics", listener.Addr())
+	return metrics, nil
+}
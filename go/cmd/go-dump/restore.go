@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ChaosHour/go-dump/go/dump"
+	"github.com/ChaosHour/go-dump/go/utils"
+
+	"github.com/ChaosHour/go-dump/go/log"
+)
+
+func PrintRestoreUsage(flags map[string]*flag.Flag) {
+
+	w := tabwriter.NewWriter(os.Stdout, 30, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "Usage: go-dump restore --destination path [--threads num] [--help] [--debug] [--quiet] [--mysql-user str] [--mysql-password str] [--mysql-host str] [--mysql-port num] [--mysql-socket path] [--encrypt-key-file path] [--encrypt-passphrase str] [--grants] [--allow-incomplete] [--ini-file str]")
+
+	fmt.Fprintln(w, "go-dump restore loads a directory created by go-dump. The table definitions are created first and then the data files are loaded in parallel, one file per thread.")
+	fmt.Fprint(w, "Example: go-dump restore --destination /tmp/dbdump --threads 4 --mysql-user myuser --mysql-password password\n\n")
+	fmt.Fprint(w, "Options description\n\n")
+
+	fmt.Fprintln(w, "# General:")
+	for _, opt := range []string{"help", "debug", "quiet", "threads", "ini-file"} {
+		printOption(w, flags[opt])
+	}
+
+	fmt.Fprintln(w, "\n# MySQL options:")
+	for _, opt := range []string{"mysql-user", "mysql-password", "mysql-host", "mysql-port", "mysql-socket"} {
+		printOption(w, flags[opt])
+	}
+
+	fmt.Fprintln(w, "\n# Input options:")
+	for _, opt := range []string{"destination", "encrypt-key-file", "encrypt-passphrase", "grants", "allow-incomplete"} {
+		printOption(w, flags[opt])
+	}
+	w.Flush()
+}
+
+// restoreMain is the entry point for "go-dump restore".
+func restoreMain(args []string) {
+	startExecution := time.Now()
+
+	var (
+		flagHelp            bool
+		flagDebug           bool
+		flagQuiet           bool
+		flagGrants          bool
+		flagAllowIncomplete bool
+		flagIniFile         string
+	)
+
+	options := dump.DefaultOptions()
+	restoreFlags := flag.NewFlagSet("restore", flag.ExitOnError)
+	restoreFlags.StringVar(&options.MySQLHost.HostName, "mysql-host", options.MySQLHost.HostName, "MySQL hostname.")
+	restoreFlags.StringVar(&options.MySQLHost.SocketFile, "mysql-socket", options.MySQLHost.SocketFile, "MySQL socket file.")
+	restoreFlags.IntVar(&options.MySQLHost.Port, "mysql-port", options.MySQLHost.Port, "MySQL port number")
+	restoreFlags.StringVar(&options.MySQLCredentials.User, "mysql-user", options.MySQLCredentials.User, "MySQL user name.")
+	restoreFlags.StringVar(&options.MySQLCredentials.Password, "mysql-password", options.MySQLCredentials.Password, "MySQL password.")
+	restoreFlags.IntVar(&options.Threads, "threads", options.Threads, "Number of threads to use.")
+	restoreFlags.StringVar(&options.DestinationDir, "destination", "", "Directory with the dump to restore.")
+	restoreFlags.StringVar(&options.EncryptionOptions.KeyFile, "encrypt-key-file", "", "Key file to decrypt an encrypted dump.")
+	restoreFlags.StringVar(&options.EncryptionOptions.Passphrase, "encrypt-passphrase", "", "Passphrase to decrypt an encrypted dump.")
+	restoreFlags.BoolVar(&flagGrants, "grants", false, "Create the accounts of grants.sql, written with --dump-grants, after the data. The existing accounts keep their passwords.")
+	restoreFlags.BoolVar(&flagAllowIncomplete, "allow-incomplete", false, "Load a dump that was interrupted, with checkpoint.jsonl, or that doesn't have manifest.json. The tables may not have all their rows.")
+	restoreFlags.BoolVar(&flagDebug, "debug", false, "Display debug information.")
+	restoreFlags.BoolVar(&flagQuiet, "quiet", false, "Do not display INFO messages during the process.")
+	restoreFlags.BoolVar(&flagHelp, "help", false, "Display this message.")
+	restoreFlags.StringVar(&flagIniFile, "ini-file", "", "INI file to read the configuration options.")
+
+	restoreFlags.Parse(args)
+
+	if flagIniFile != "" {
+		if err := dump.ParseIniFile(flagIniFile, restoreFlags); err != nil {
+			fatalError(err, "Error reading the options")
+		}
+	}
+
+	flags := make(map[string]*flag.Flag)
+	restoreFlags.VisitAll(func(f *flag.Flag) {
+		flags[f.Name] = f
+	})
+
+	if flagHelp {
+		PrintRestoreUsage(flags)
+		return
+	}
+
+	if flagDebug {
+		log.SetLevel(log.DEBUG)
+	} else if flagQuiet {
+		log.SetLevel(log.WARNING)
+	} else {
+		log.SetLevel(log.INFO)
+	}
+
+	if options.DestinationDir == "" {
+		log.Fatal("--destination dir is required, use --help for more information.")
+	}
+	if utils.IsS3Destination(options.DestinationDir) {
+		log.Fatal("Restoring from S3 is not supported, download the dump to a local directory first.")
+	}
+
+	restorer := utils.NewRestorer(&utils.RestoreOptions{
+		MySQLHost:        options.MySQLHost,
+		MySQLCredentials: options.MySQLCredentials,
+		Threads:          options.Threads,
+		SourceDir:        options.DestinationDir,
+		Encryption:       options.EncryptionOptions,
+		Grants:           flagGrants,
+		AllowIncomplete:  flagAllowIncomplete,
+	})
+
+	if err := restorer.Run(); err != nil {
+		fatalError(err, "Error restoring the dump")
+	}
+
+	log.Infof("Execution time: %s  ", time.Since(startExecution).String())
+}
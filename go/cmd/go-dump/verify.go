@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ChaosHour/go-dump/go/dump"
+	"github.com/ChaosHour/go-dump/go/utils"
+
+	"github.com/ChaosHour/go-dump/go/log"
+)
+
+func PrintVerifyUsage(flags map[string]*flag.Flag) {
+
+	w := tabwriter.NewWriter(os.Stdout, 30, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "Usage: go-dump verify --destination path [--threads num] [--help] [--debug] [--quiet] [--mysql-user str] [--mysql-password str] [--mysql-host str] [--mysql-port num] [--mysql-socket path] [--ini-file str]")
+
+	fmt.Fprintln(w, "go-dump verify reads again each chunk of a dump from a server, the source or a restored copy, and compares the number of rows and the row checksums with the manifest.json of the dump. It exits with an error if any chunk doesn't match.")
+	fmt.Fprint(w, "Example: go-dump verify --destination /tmp/dbdump --threads 4 --mysql-user myuser --mysql-password password\n\n")
+	fmt.Fprint(w, "Options description\n\n")
+
+	fmt.Fprintln(w, "# General:")
+	for _, opt := range []string{"help", "debug", "quiet", "threads", "ini-file"} {
+		printOption(w, flags[opt])
+	}
+
+	fmt.Fprintln(w, "\n# MySQL options:")
+	for _, opt := range []string{"mysql-user", "mysql-password", "mysql-host", "mysql-port", "mysql-socket"} {
+		printOption(w, flags[opt])
+	}
+
+	fmt.Fprintln(w, "\n# Input options:")
+	for _, opt := range []string{"destination"} {
+		printOption(w, flags[opt])
+	}
+	w.Flush()
+}
+
+// verifyMain is the entry point for "go-dump verify".
+func verifyMain(args []string) {
+	startExecution := time.Now()
+
+	var (
+		flagHelp    bool
+		flagDebug   bool
+		flagQuiet   bool
+		flagIniFile string
+	)
+
+	options := dump.DefaultOptions()
+	verifyFlags := flag.NewFlagSet("verify", flag.ExitOnError)
+	verifyFlags.StringVar(&options.MySQLHost.HostName, "mysql-host", options.MySQLHost.HostName, "MySQL hostname.")
+	verifyFlags.StringVar(&options.MySQLHost.SocketFile, "mysql-socket", options.MySQLHost.SocketFile, "MySQL socket file.")
+	verifyFlags.IntVar(&options.MySQLHost.Port, "mysql-port", options.MySQLHost.Port, "MySQL port number")
+	verifyFlags.StringVar(&options.MySQLCredentials.User, "mysql-user", options.MySQLCredentials.User, "MySQL user name.")
+	verifyFlags.StringVar(&options.MySQLCredentials.Password, "mysql-password", options.MySQLCredentials.Password, "MySQL password.")
+	verifyFlags.IntVar(&options.Threads, "threads", options.Threads, "Number of threads to use.")
+	verifyFlags.StringVar(&options.DestinationDir, "destination", "", "Directory with the dump to verify.")
+	verifyFlags.BoolVar(&flagDebug, "debug", false, "Display debug information.")
+	verifyFlags.BoolVar(&flagQuiet, "quiet", false, "Do not display INFO messages during the process.")
+	verifyFlags.BoolVar(&flagHelp, "help", false, "Display this message.")
+	verifyFlags.StringVar(&flagIniFile, "ini-file", "", "INI file to read the configuration options.")
+
+	verifyFlags.Parse(args)
+
+	if flagIniFile != "" {
+		if err := dump.ParseIniFile(flagIniFile, verifyFlags); err != nil {
+			fatalError(err, "Error reading the options")
+		}
+	}
+
+	flags := make(map[string]*flag.Flag)
+	verifyFlags.VisitAll(func(f *flag.Flag) {
+		flags[f.Name] = f
+	})
+
+	if flagHelp {
+		PrintVerifyUsage(flags)
+		return
+	}
+
+	if flagDebug {
+		log.SetLevel(log.DEBUG)
+	} else if flagQuiet {
+		log.SetLevel(log.WARNING)
+	} else {
+		log.SetLevel(log.INFO)
+	}
+
+	if options.DestinationDir == "" {
+		log.Fatal("--destination dir is required, use --help for more information.")
+	}
+	if utils.IsS3Destination(options.DestinationDir) {
+		log.Fatal("Verifying a dump in S3 is not supported, download the manifest.json to a local directory first.")
+	}
+
+	verifier := utils.NewVerifier(&utils.VerifyOptions{
+		MySQLHost:        options.MySQLHost,
+		MySQLCredentials: options.MySQLCredentials,
+		Threads:          options.Threads,
+		SourceDir:        options.DestinationDir,
+	})
+
+	// The first SIGINT or SIGTERM stops the workers after their current
+	// chunk. The second one exits at once.
+	ctx, cancel := interruptContext("verification")
+	defer cancel(nil)
+
+	results, err := verifier.Run(ctx)
+	if err != nil {
+		fatalError(err, "Error verifying the dump")
+	}
+	utils.WriteVerifyReport(os.Stdout, results)
+
+	failed := 0
+	for _, result := range results {
+		if !result.OK() {
+			failed++
+		}
+	}
+	log.Infof("Execution time: %s  ", time.Since(startExecution).String())
+	if failed > 0 {
+		log.Fatalf("The verification failed for %d of %d tables", failed, len(results))
+	}
+	log.Infof("The verification passed for %d tables", len(results))
+}
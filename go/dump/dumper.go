@@ -0,0 +1,441 @@
+// Package dump runs the dumps of go-dump from other Go programs. The command
+// line is a wrapper over it.
+package dump
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ChaosHour/go-dump/go/log"
+	"github.com/ChaosHour/go-dump/go/utils"
+)
+
+// Version is the version of go-dump written in the manifest. The Makefile
+// sets it from the VERSION file.
+var Version = "0.01"
+
+// Dumper dumps the tables selected by its options. A Dumper can run several
+// dumps, one at a time.
+type Dumper struct {
+	options *Options
+}
+
+// New creates a dumper with the options, once they are validated. The
+// options must not be changed while the dumper is used.
+func New(options *Options) (*Dumper, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	return &Dumper{options: options}, nil
+}
+
+// TablePlan is a table of the plan of a dump, with its chunks.
+type TablePlan struct {
+	Schema string
+	Name   string
+	Engine string
+	Key    []string // columns of the key used to split the chunks
+	Chunks []*utils.ChunkRange
+}
+
+// FullName return the name of the table as schema.table.
+func (t *TablePlan) FullName() string {
+	return t.Schema + "." + t.Name
+}
+
+// Plan is the list of tables of a dump and how they are split in chunks,
+// sorted by name.
+type Plan struct {
+	Tables []*TablePlan
+}
+
+// Chunks return the number of chunks of all the tables.
+func (p *Plan) Chunks() int {
+	var chunks int
+	for _, table := range p.Tables {
+		chunks += len(table.Chunks)
+	}
+	return chunks
+}
+
+// Result is the summary of a dump. The manifest has the details.
+type Result struct {
+	Destination string
+	Duration    time.Duration
+	Tables      int
+	Chunks      uint64
+	Rows        uint64
+	Bytes       uint64 // bytes written, after the compression and the encryption
+	Consistent  bool
+	Manifest    *utils.Manifest
+}
+
+// newResult return the summary of the manifest.
+func newResult(destination string, manifest *utils.Manifest) *Result {
+	result := &Result{
+		Destination: destination,
+		Duration:    manifest.EndTime.Sub(manifest.StartTime),
+		Tables:      len(manifest.Tables),
+		Consistent:  manifest.Consistent,
+		Manifest:    manifest,
+	}
+	for _, table := range manifest.Tables {
+		result.Chunks += table.Chunks
+		result.Rows += table.Rows
+		result.Bytes += table.Bytes
+	}
+	for _, file := range manifest.Files {
+		result.Bytes += file.Bytes
+	}
+	return result
+}
+
+// interruptedError return the error of a dump stopped because ctx is done.
+func interruptedError(ctx context.Context) error {
+	return &utils.DumpError{Kind: utils.ErrInterrupted,
+		Err: fmt.Errorf("the dump was interrupted: %w", context.Cause(ctx))}
+}
+
+// dumpRun is the state of a dump while Plan or Run use it.
+type dumpRun struct {
+	options     *utils.DumpOptions
+	taskManager *utils.TaskManager
+	db          *sql.DB // connection of the task manager
+	dbChunks    *sql.DB // connection to create the chunks
+	closers     []func()
+}
+
+func (r *dumpRun) close() {
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		r.closers[i]()
+	}
+}
+
+// start connects to the server and creates a task for each table to dump.
+func (d *Dumper) start(ctx context.Context, options *utils.DumpOptions) (*dumpRun, error) {
+	run := &dumpRun{options: options}
+	var err error
+
+	run.db, err = utils.GetMySQLConnection(run.options.MySQLHost, run.options.MySQLCredentials)
+	if err != nil {
+		return nil, err
+	}
+	run.closers = append(run.closers, func() { run.db.Close() })
+
+	run.dbChunks, err = utils.GetMySQLConnection(run.options.MySQLHost, run.options.MySQLCredentials)
+	if err != nil {
+		run.close()
+		return nil, err
+	}
+	run.closers = append(run.closers, func() { run.dbChunks.Close() })
+
+	var wgCreateChunks, wgProcessChunks sync.WaitGroup
+	taskManager := utils.NewTaskManager(
+		&wgCreateChunks,
+		&wgProcessChunks,
+		make(chan utils.DataChunk, run.options.ChannelBufferSize),
+		run.db,
+		run.options)
+	run.taskManager = &taskManager
+	run.closers = append(run.closers, run.taskManager.CloseWorkersDB)
+
+	tables, err := d.listTables(run)
+	if err != nil {
+		run.close()
+		return nil, err
+	}
+
+	// We create one task per table
+	for _, table := range tables {
+		if ctx.Err() != nil {
+			run.close()
+			return nil, interruptedError(ctx)
+		}
+		t := strings.SplitN(table, ".", 2)
+		if len(t) != 2 {
+			run.close()
+			return nil, invalidOptions("the table %s is not in the format schema.table", table)
+		}
+		task, err := utils.NewTask(
+			t[0], t[1],
+			run.options.ChunkSize,
+			run.options.OutputChunkSize,
+			run.taskManager)
+		if err != nil {
+			run.close()
+			return nil, fmt.Errorf("error getting the table %s: %w", table, err)
+		}
+		task.PrintInfo()
+		run.taskManager.AddTask(task)
+		log.Debugf("Table: %+v", task.Table)
+	}
+	return run, nil
+}
+
+// listTables return the tables of the databases and the tables of the
+// options that match the filters, and adds the schemas dumped completely to
+// the task manager.
+func (d *Dumper) listTables(run *dumpRun) ([]string, error) {
+	var tables map[string]bool
+	var err error
+	filter := run.options.TableFilter
+
+	if d.options.AllDatabases {
+		tables, err = utils.TablesFromAllDatabases(run.dbChunks)
+		if err != nil {
+			return nil, err
+		}
+		schemas, err := utils.SchemasFromAllDatabases(run.dbChunks)
+		if err != nil {
+			return nil, err
+		}
+		for _, schema := range schemas {
+			if filter.MatchSchema(schema) {
+				run.taskManager.AddSchema(schema)
+			}
+		}
+	} else {
+		tables = make(map[string]bool)
+		if len(d.options.Databases) > 0 {
+			tables, err = utils.TablesFromDatabase(strings.Join(d.options.Databases, ","), run.dbChunks)
+			if err != nil {
+				return nil, err
+			}
+			log.Debugf("tablesFromDatabases: %v ", tables)
+			for _, schema := range d.options.Databases {
+				if filter.MatchSchema(schema) {
+					run.taskManager.AddSchema(schema)
+				}
+			}
+		}
+		for _, table := range d.options.Tables {
+			tables[table] = true
+		}
+	}
+
+	// Applying the include, exclude and exclude databases patterns.
+	tables, excludedTables := filter.FilterTables(tables)
+	if len(excludedTables) > 0 {
+		log.Infof("Skipping %d tables excluded by the filters", len(excludedTables))
+		log.Debugf("Excluded tables: %s", strings.Join(excludedTables, ", "))
+	}
+
+	var names []string
+	for table := range tables {
+		names = append(names, table)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Plan connects to the server and splits the tables in chunks, without
+// reading the data.
+func (d *Dumper) Plan(ctx context.Context) (*Plan, error) {
+	run, err := d.start(ctx, d.options.dumpOptions())
+	if err != nil {
+		return nil, err
+	}
+	defer run.close()
+	tm := run.taskManager
+
+	plan := new(Plan)
+	tables := make(map[*utils.Task]*TablePlan)
+	for _, task := range tm.GetTasksPool() {
+		table := &TablePlan{
+			Schema: task.Table.GetUnescapedSchema(),
+			Name:   task.Table.GetUnescapedName(),
+			Engine: task.Table.Engine,
+			Key:    task.Table.GetKeyForChunks(),
+		}
+		tables[task] = table
+		plan.Tables = append(plan.Tables, table)
+	}
+	sort.Slice(plan.Tables, func(i, j int) bool { return plan.Tables[i].FullName() < plan.Tables[j].FullName() })
+
+	if !run.options.NoData {
+		tm.CreateChunksWaitGroup.Add(1)
+		go tm.CreateChunks(ctx, run.dbChunks)
+
+		done := make(chan struct{})
+		go func() {
+			for chunk := range tm.ChunksChannel {
+				table := tables[chunk.Task]
+				table.Chunks = append(table.Chunks, chunk.GetChunkRange(0, 0))
+			}
+			close(done)
+		}()
+		tm.CreateChunksWaitGroup.Wait()
+		close(tm.ChunksChannel)
+		<-done
+	}
+
+	if ctx.Err() != nil {
+		tm.Fail(interruptedError(ctx))
+	}
+	if err := tm.Err(); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// Run dumps the tables and writes the manifest. If the dump fails, the
+// complete files and the checkpoint are kept, so the dump can be resumed.
+// When ctx is done the dump stops with an error of the kind
+// utils.ErrInterrupted.
+func (d *Dumper) Run(ctx context.Context) (*Result, error) {
+	startExecution := time.Now()
+
+	// Reading the progress of the interrupted dump.
+	var checkpoint *utils.CheckpointState
+	if d.options.Resume {
+		var err error
+		checkpoint, err = utils.ReadCheckpoint(d.options.DestinationDir)
+		if err != nil {
+			if _, errManifest := os.Stat(filepath.Join(d.options.DestinationDir, utils.ManifestFile)); errManifest == nil {
+				return nil, fmt.Errorf("the dump in %s is complete, there is nothing to resume", d.options.DestinationDir)
+			}
+			return nil, &utils.DumpError{Kind: utils.ErrIO,
+				Err: fmt.Errorf("error reading the checkpoint of %s: %w", d.options.DestinationDir, err)}
+		}
+	}
+
+	options := d.options.dumpOptions()
+	if d.options.Encrypt {
+		var key *utils.EncryptionKey
+		var err error
+		if checkpoint != nil && checkpoint.Encryption != nil {
+			// The files of the interrupted dump must be encrypted with the same key.
+			key, err = d.options.EncryptionOptions.GetKey(checkpoint.Encryption.KeyID, checkpoint.Encryption.Salt)
+		} else {
+			key, err = d.options.EncryptionOptions.NewKey()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error getting the encryption key: %w", err)
+		}
+		options.EncryptionKey = key
+		log.Infof("Encrypting the files with the key %s", key.ID)
+	}
+	if checkpoint != nil {
+		if err := checkpoint.CheckOptions(options); err != nil {
+			return nil, fmt.Errorf("can not resume the dump: %w", err)
+		}
+	}
+
+	// Setting up the S3 destination, the local directory is the default. The
+	// uploads don't use ctx, so the complete files can be closed when the
+	// dump is interrupted.
+	if options.Sink == nil && utils.IsS3Destination(options.DestinationDir) {
+		sink, err := utils.NewS3Sink(context.Background(), options.DestinationDir, options.S3Options)
+		if err != nil {
+			return nil, &utils.DumpError{Kind: utils.ErrIO,
+				Err: fmt.Errorf("error setting up the destination %s: %w", options.DestinationDir, err)}
+		}
+		options.Sink = sink
+	}
+
+	run, err := d.start(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	defer run.close()
+	tm := run.taskManager
+
+	if checkpoint != nil {
+		if err := tm.ResumeTasks(checkpoint); err != nil {
+			return nil, &utils.DumpError{Kind: utils.ErrIO, Err: fmt.Errorf("error resuming the dump: %w", err)}
+		}
+	}
+
+	// Without data there are no chunks, so the workers, the checkpoint and
+	// the transactions are not needed.
+	if !run.options.NoData {
+		if err := tm.AddWorkersDB(); err != nil {
+			return nil, err
+		}
+		log.Debugf("Added %d connections to the taskManager", run.options.Threads)
+
+		// The checkpoint records the chunks as they are created, so it starts
+		// before them. It's only written to a local destination.
+		if run.options.Sink == nil {
+			if err := os.MkdirAll(d.options.DestinationDir, 0755); err != nil {
+				return nil, &utils.DumpError{Kind: utils.ErrIO,
+					Err: fmt.Errorf("error creating directory %s: %w", d.options.DestinationDir, err)}
+			}
+			if err := tm.StartCheckpoint(d.options.Resume); err != nil {
+				return nil, &utils.DumpError{Kind: utils.ErrIO, Err: fmt.Errorf("error creating the checkpoint: %w", err)}
+			}
+		}
+
+		// Creating the chunks from the tables.
+		tm.CreateChunksWaitGroup.Add(1)
+		go tm.CreateChunks(ctx, run.dbChunks)
+		var reporter *utils.ProgressReporter
+		if d.options.ProgressInterval > 0 {
+			report := d.options.OnProgress
+			if report == nil {
+				report = utils.LogProgress
+			}
+			reporter = utils.NewProgressReporter(tm, d.options.ProgressInterval, report)
+			reporter.Start()
+		}
+
+		if err := tm.GetTransactions(ctx, d.options.LockTables, d.options.AllDatabases); err != nil {
+			tm.Fail(err)
+		} else {
+			tm.StartWorkers(ctx)
+		}
+		log.Debugf("ProcessChunksWaitGroup, %+v", tm.ProcessChunksWaitGroup)
+		tm.CreateChunksWaitGroup.Wait()
+		close(tm.ChunksChannel)
+		tm.ProcessChunksWaitGroup.Wait()
+		if reporter != nil {
+			reporter.Stop()
+		}
+	}
+
+	// The manifest is not written for a failed dump. The checkpoint is
+	// kept, so the dump can be resumed.
+	if ctx.Err() != nil {
+		tm.Fail(interruptedError(ctx))
+	}
+	if err := tm.Err(); err != nil {
+		if err := tm.StopCheckpoint(err); err != nil {
+			log.Errorf("Error writing the checkpoint: %s", err)
+		}
+		if run.options.Sink == nil {
+			log.Infof("The complete files are kept in %s, use --resume to continue the dump",
+				d.options.DestinationDir)
+		}
+		return nil, err
+	}
+
+	if err := tm.WriteTablesSQL(d.options.AddDropTable); err != nil {
+		return nil, err
+	}
+	if err := tm.WriteSchemaObjectsSQL(d.options.AddDropTable); err != nil {
+		return nil, err
+	}
+	if d.options.DumpGrants {
+		if err := tm.WriteGrantsSQL(); err != nil {
+			return nil, err
+		}
+	}
+	manifest, err := tm.WriteManifest(Version, startExecution)
+	if err != nil {
+		return nil, err
+	}
+	if err := tm.Sink.Commit(); err != nil {
+		return nil, &utils.DumpError{Kind: utils.ErrIO, Err: fmt.Errorf("error committing the dump: %w", err)}
+	}
+	if err := tm.RemoveCheckpoint(); err != nil {
+		log.Warningf("Error removing the checkpoint: %s", err.Error())
+	}
+	return newResult(d.options.DestinationDir, manifest), nil
+}
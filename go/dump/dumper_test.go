@@ -0,0 +1,213 @@
+package dump
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ChaosHour/go-dump/go/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func getTestOptions(dir string) *Options {
+	options := DefaultOptions()
+	options.MySQLHost = &utils.MySQLHost{HostName: "127.0.0.1", Port: 3306}
+	options.MySQLCredentials = &utils.MySQLCredentials{User: "root", Password: "s3cr3t"}
+	options.Tables = []string{"sakila.actor", "sakila.city"}
+	options.ChunkSize = 100
+	options.DestinationDir = dir
+	return options
+}
+
+func TestPlan(t *testing.T) {
+	dumper, err := New(getTestOptions(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := dumper.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Tables) != 2 || plan.Tables[0].FullName() != "sakila.actor" || plan.Tables[1].FullName() != "sakila.city" {
+		t.Fatalf("Unexpected tables %v", plan.Tables)
+	}
+	city := plan.Tables[1]
+	if len(city.Chunks) < 2 || !city.Chunks[len(city.Chunks)-1].Last || len(city.Key) != 1 || city.Key[0] != "city_id" {
+		t.Errorf("Unexpected chunks %d of the key %v", len(city.Chunks), city.Key)
+	}
+	if plan.Chunks() != len(plan.Tables[0].Chunks)+len(city.Chunks) {
+		t.Errorf("Unexpected number of chunks %d", plan.Chunks())
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	options := getTestOptions(dir)
+	options.Threads = 2
+	dumper, err := New(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := dumper.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Tables != 2 || result.Rows != 800 || result.Chunks < 8 || result.Bytes == 0 || !result.Consistent {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	if _, err := utils.ReadManifest(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, utils.CheckpointFile)); !os.IsNotExist(err) {
+		t.Errorf("The checkpoint should be removed, got %v", err)
+	}
+}
+
+func TestRunInterrupted(t *testing.T) {
+	dir := t.TempDir()
+	dumper, err := New(getTestOptions(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := dumper.Run(ctx); !errors.Is(err, utils.ErrInterrupted) {
+		t.Fatalf("Expected an interrupted dump and got %v", err)
+	}
+	if _, err := utils.ReadManifest(dir); err == nil {
+		t.Error("The manifest should not be written")
+	}
+}
+
+func TestRunProgress(t *testing.T) {
+	options := getTestOptions(t.TempDir())
+	options.ProgressInterval = 10 * time.Millisecond
+	var reports []*utils.Progress
+	options.OnProgress = func(progress *utils.Progress) {
+		reports = append(reports, progress)
+	}
+	dumper, err := New(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dumper.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) == 0 {
+		t.Fatal("No progress reported")
+	}
+	final := reports[len(reports)-1]
+	if !final.Final || final.Rows != 800 || final.TablesDone != 2 || final.Percent() != 100 || final.Bytes == 0 {
+		t.Errorf("Unexpected final progress %+v", final)
+	}
+	for _, report := range reports[:len(reports)-1] {
+		if report.Final || report.Rows > final.Rows {
+			t.Errorf("Unexpected progress %+v", report)
+		}
+	}
+}
+
+func TestRunMetrics(t *testing.T) {
+	options := getTestOptions(t.TempDir())
+	options.Threads = 2
+	registry := prometheus.NewRegistry()
+	metrics, err := utils.NewMetrics(registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options.Metrics = metrics
+	dumper, err := New(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := dumper.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := fmt.Sprintf(`
+# HELP godump_chunks_done_total Chunks dumped by the workers.
+# TYPE godump_chunks_done_total counter
+godump_chunks_done_total{schema="sakila",table="actor"} 2
+godump_chunks_done_total{schema="sakila",table="city"} %d
+# HELP godump_chunks_in_queue Chunks waiting for a worker.
+# TYPE godump_chunks_in_queue gauge
+godump_chunks_in_queue 0
+# HELP godump_rows_total Rows written.
+# TYPE godump_rows_total counter
+godump_rows_total{schema="sakila",table="actor"} 200
+godump_rows_total{schema="sakila",table="city"} 600
+`, result.Chunks-2)
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "godump_chunks_done_total",
+		"godump_chunks_in_queue", "godump_rows_total"); err != nil {
+		t.Error(err)
+	}
+	if count, err := testutil.GatherAndCount(registry, "godump_chunk_query_duration_seconds"); err != nil || count != 1 {
+		t.Errorf("Unexpected query latencies %d, %v", count, err)
+	}
+}
+
+// limitedSink is a FileSink that limits the open data files of the workers
+// and records the most that were open at the same time.
+type limitedSink struct {
+	*utils.FileSink
+	mu      sync.Mutex
+	open    int
+	maxOpen int
+}
+
+func (s *limitedSink) MaxOpenFiles() int {
+	return 1
+}
+
+func (s *limitedSink) Create(name string) (utils.SinkWriter, error) {
+	writer, err := s.FileSink.Create(name)
+	if err != nil || !strings.Contains(name, "-thread") {
+		return writer, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.open++
+	s.maxOpen = max(s.maxOpen, s.open)
+	return &limitedWriter{SinkWriter: writer, sink: s}, nil
+}
+
+type limitedWriter struct {
+	utils.SinkWriter
+	sink *limitedSink
+}
+
+func (w *limitedWriter) Close() error {
+	w.sink.mu.Lock()
+	w.sink.open--
+	w.sink.mu.Unlock()
+	return w.SinkWriter.Close()
+}
+
+func TestRunMaxOpenFiles(t *testing.T) {
+	dir := t.TempDir()
+	options := getTestOptions(dir)
+	sink := &limitedSink{FileSink: utils.NewFileSink(dir)}
+	options.Sink = sink
+	dumper, err := New(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := dumper.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Rows != 800 {
+		t.Errorf("Unexpected rows %d", result.Rows)
+	}
+	if sink.maxOpen != 1 {
+		t.Errorf("Unexpected open data files %d", sink.maxOpen)
+	}
+}
@@ -0,0 +1,252 @@
+package dump
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ChaosHour/go-dump/go/log"
+	"github.com/ChaosHour/go-dump/go/utils"
+	"gopkg.in/ini.v1"
+)
+
+// listValue is a flag with a comma separated list.
+type listValue struct {
+	list *[]string
+}
+
+func (v *listValue) String() string {
+	if v.list == nil {
+		return ""
+	}
+	return strings.Join(*v.list, ",")
+}
+
+func (v *listValue) Set(value string) error {
+	*v.list = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*v.list = append(*v.list, item)
+		}
+	}
+	return nil
+}
+
+// isolationLevelValue is a flag with the name of an isolation level.
+type isolationLevelValue struct {
+	level *sql.IsolationLevel
+}
+
+func (v *isolationLevelValue) String() string {
+	if v.level == nil {
+		return ""
+	}
+	return isolationLevelName(*v.level)
+}
+
+func (v *isolationLevelValue) Set(value string) error {
+	level, err := ParseIsolationLevel(value)
+	if err != nil {
+		return err
+	}
+	*v.level = level
+	return nil
+}
+
+// normalizeTableName converts a table name like "schema.table" to "`schema`.`table`"
+func normalizeTableName(tableName string) string {
+	if strings.Contains(tableName, ".") {
+		parts := strings.Split(tableName, ".")
+		if len(parts) == 2 {
+			return fmt.Sprintf("`%s`.`%s`", parts[0], parts[1])
+		}
+	}
+	return tableName
+}
+
+// whereValue is the --where flag: a condition for all the tables, or
+// "table:condition,table2:condition2".
+type whereValue struct {
+	options *Options
+}
+
+func (v *whereValue) String() string {
+	if v.options == nil {
+		return ""
+	}
+	return v.options.GlobalWhereCondition
+}
+
+func (v *whereValue) Set(value string) error {
+	if !strings.Contains(value, ":") {
+		v.options.GlobalWhereCondition = value
+		return nil
+	}
+	if v.options.WhereConditions == nil {
+		v.options.WhereConditions = make(map[string]string)
+	}
+	for _, part := range strings.Split(value, ",") {
+		if tableCond := strings.SplitN(strings.TrimSpace(part), ":", 2); len(tableCond) == 2 {
+			v.options.WhereConditions[normalizeTableName(tableCond[0])] = tableCond[1]
+		}
+	}
+	return nil
+}
+
+// AddFlags defines the flags of the options in the flag set, with the
+// current values as defaults.
+func (o *Options) AddFlags(fs *flag.FlagSet) {
+	fs.Var(&listValue{&o.Tables}, "tables", "List of comma separated tables to dump. Each table should have the database name included, for example \"mydb.mytable,mydb2.mytable2\".")
+	fs.Var(&listValue{&o.Databases}, "databases", "List of comma separated databases to dump.")
+	fs.BoolVar(&o.AllDatabases, "all-databases", o.AllDatabases, "Dump all the databases.")
+	fs.StringVar(&o.Include, "include", o.Include, "List of comma separated patterns of the tables to dump, applied to the tables of --databases, --tables and --all-databases. A pattern is a glob like \"shop.order_*\", or a regular expression between slashes like \"/^shop\\.order_[0-9]+$/\", matched with \"schema.table\". A glob without a dot matches the table name in any database.")
+	fs.StringVar(&o.Exclude, "exclude", o.Exclude, "List of comma separated patterns of the tables to skip, like --include. The tables matching both are skipped.")
+	fs.StringVar(&o.ExcludeDatabases, "exclude-databases", o.ExcludeDatabases, "List of comma separated patterns of the databases to skip, with their tables, views, triggers, routines and events.")
+	fs.StringVar(&o.MySQLHost.HostName, "mysql-host", o.MySQLHost.HostName, "MySQL hostname.")
+	fs.StringVar(&o.MySQLHost.SocketFile, "mysql-socket", o.MySQLHost.SocketFile, "MySQL socket file.")
+	fs.IntVar(&o.MySQLHost.Port, "mysql-port", o.MySQLHost.Port, "MySQL port number")
+	fs.StringVar(&o.MySQLCredentials.User, "mysql-user", o.MySQLCredentials.User, "MySQL user name.")
+	fs.StringVar(&o.MySQLCredentials.Password, "mysql-password", o.MySQLCredentials.Password, "MySQL password.")
+	fs.IntVar(&o.Threads, "threads", o.Threads, "Number of threads to use.")
+	fs.Uint64Var(&o.ChunkSize, "chunk-size", o.ChunkSize, "Chunk size to get the rows.")
+	fs.Uint64Var(&o.OutputChunkSize, "output-chunk-size", o.OutputChunkSize, "Chunk size to output the rows.")
+	fs.Uint64Var(&o.MaxStatementBytes, "max-statement-bytes", o.MaxStatementBytes, "Maximum size in bytes of each INSERT statement. Use a value lower than max_allowed_packet of the server where the dump is restored. 0 means no limit.")
+	fs.IntVar(&o.ChannelBufferSize, "channel-buffer-size", o.ChannelBufferSize, "Task channel buffer size.")
+	fs.BoolVar(&o.LockTables, "lock-tables", o.LockTables, "Lock tables to get consistent backup.")
+	fs.StringVar(&o.TablesWithoutUKOption, "tables-without-uniquekey", o.TablesWithoutUKOption, "Action to have with tables without any primary or unique key. Valid actions are: 'error', 'single-chunk'.")
+	fs.StringVar(&o.DestinationDir, "destination", o.DestinationDir, "Directory to store the dumps, or s3://bucket/prefix to upload them to an S3 compatible storage.")
+	fs.BoolVar(&o.SkipUseDatabase, "skip-use-database", o.SkipUseDatabase, "Skip USE \"database\" in the dump.")
+	fs.BoolVar(&o.RowChecksum, "row-checksum", o.RowChecksum, "Compute a checksum of the rows of each table and write it in the manifest.")
+	fs.BoolVar(&o.Views, "views", o.Views, "Dump the views of the databases in a file per database, created after the tables when restoring.")
+	fs.BoolVar(&o.Triggers, "triggers", o.Triggers, "Dump the triggers of the tables in a file per database, created after the data when restoring.")
+	fs.BoolVar(&o.Routines, "routines", o.Routines, "Dump the stored procedures and functions of the databases in a file per database.")
+	fs.BoolVar(&o.Events, "events", o.Events, "Dump the events of the databases in a file per database, created after the data when restoring.")
+	fs.BoolVar(&o.NoData, "no-data", o.NoData, "Only dump the definitions of the tables and the other objects. The data is not read, so there are no transactions.")
+	fs.BoolVar(&o.NoCreateInfo, "no-create-info", o.NoCreateInfo, "Only dump the data of the tables, without the -definition.sql files, to load it into tables that already exist.")
+	fs.BoolVar(&o.DumpGrants, "dump-grants", o.DumpGrants, "Dump the accounts of the server with their privileges and roles in grants.sql.")
+	fs.StringVar(&o.GrantsUsers, "grants-users", o.GrantsUsers, "List of comma separated accounts to dump with --dump-grants, as LIKE patterns of user@host, for example \"app%@%,backup@localhost\". All the accounts by default.")
+	fs.Uint64Var(&o.ChunksPerFile, "chunks-per-file", o.ChunksPerFile, "Number of chunks written in a data file before starting a new one. Only the chunks of complete files are kept by --resume, so a smaller number loses less work when a dump is interrupted. 0 means no limit, the files are closed at the end of the dump.")
+	fs.BoolVar(&o.Resume, "resume", o.Resume, "Resume an interrupted dump in the destination directory, dumping only the chunks that are missing. The options must be the same as in the interrupted dump.")
+	fs.BoolVar(&o.GetMasterStatus, "get-master-status", o.GetMasterStatus, "Get the master data.")
+	fs.BoolVar(&o.GetSlaveStatus, "get-slave-status", o.GetSlaveStatus, "Get the slave data.")
+	fs.BoolVar(&o.AddDropTable, "add-drop-table", o.AddDropTable, "Add drop table before create table.")
+	fs.BoolVar(&o.Compress, "compress", o.Compress, "Enable compression to the output files.")
+	fs.StringVar(&o.CompressAlgorithm, "compress-algorithm", o.CompressAlgorithm, "Algorithm used with --compress. Valid algorithms are: 'gzip' (.gz), 'zstd' (.zst), 'lz4' (.lz4).")
+	fs.IntVar(&o.CompressLevel, "compress-level", o.CompressLevel, "Compression level from 1 (best speed) to 9 (best compression) for all the algorithms.")
+	fs.BoolVar(&o.Encrypt, "encrypt", o.Encrypt, "Encrypt the output files with AES-256-GCM, after the compression. The files get the .enc suffix.")
+	fs.StringVar(&o.EncryptionOptions.KeyFile, "encrypt-key-file", o.EncryptionOptions.KeyFile, "File with the 32 bytes key to encrypt the dump, raw or as 64 hex characters.")
+	fs.StringVar(&o.EncryptionOptions.Passphrase, "encrypt-passphrase", o.EncryptionOptions.Passphrase, "Passphrase to derive the key to encrypt the dump. Use it in the ini file to keep it out of the process list.")
+	fs.StringVar(&o.OutputFormat, "output-format", o.OutputFormat, "Format of the data files. Valid formats are: 'sql', 'csv', 'tsv', 'jsonl', 'parquet'.")
+	fs.StringVar(&o.CSVDelimiter, "csv-delimiter", o.CSVDelimiter, "Field delimiter for the csv and tsv formats, use \\t for a tab. The default is a comma for csv and a tab for tsv.")
+	fs.StringVar(&o.CSVQuote, "csv-quote", o.CSVQuote, "Character used to quote the fields in the csv and tsv formats. Empty to never quote, the delimiter and the line breaks in the fields are escaped with a backslash instead.")
+	fs.StringVar(&o.CSVNull, "csv-null", o.CSVNull, "Value written for NULL in the csv and tsv formats: \\N, with the backslashes of the strings escaped, or NULL, with the strings equal to NULL quoted. NULL needs --csv-quote.")
+	fs.StringVar(&o.S3Options.Endpoint, "s3-endpoint", o.S3Options.Endpoint, "URL of the S3 compatible storage, for example http://127.0.0.1:9000. Empty for AWS S3.")
+	fs.StringVar(&o.S3Options.Region, "s3-region", o.S3Options.Region, "Region of the bucket. Empty to use the AWS configuration or us-east-1.")
+	fs.StringVar(&o.S3Options.AccessKey, "s3-access-key", o.S3Options.AccessKey, "Access key of the storage. Empty to use the AWS environment variables and configuration files.")
+	fs.StringVar(&o.S3Options.SecretKey, "s3-secret-key", o.S3Options.SecretKey, "Secret key of the storage.")
+	fs.BoolVar(&o.S3Options.PathStyle, "s3-path-style", o.S3Options.PathStyle, "Use path style urls (endpoint/bucket/key), needed by most S3 compatible storages.")
+	fs.Uint64Var(&o.S3Options.PartSize, "s3-part-size", o.S3Options.PartSize, "Size in bytes of the parts of the multipart uploads. The minimum is 5 MiB. Each thread keeps up to 4 data files open with a part in memory, so the uploads use up to threads x 4 x part size of memory.")
+	fs.IntVar(&o.S3Options.MaxRetries, "s3-max-retries", o.S3Options.MaxRetries, "Number of retries of each failed request to the storage.")
+	fs.Var(&isolationLevelValue{&o.IsolationLevel}, "isolation-level", "Isolation level to use. If you need a consitent backup, leave the default 'REPEATABLE READ', other options READ COMMITTED, READ UNCOMMITTED and SERIALIZABLE.")
+	fs.BoolVar(&o.Consistent, "consistent", o.Consistent, "Get a consistent backup.")
+	fs.DurationVar(&o.ProgressInterval, "progress-interval", o.ProgressInterval, "Time between the reports of the progress of the dump, like \"30s\". 0 disables them.")
+	fs.Var(&whereValue{o}, "where", "Custom WHERE condition for selective dumping (e.g., \"status = 'active'\" or \"table:condition,table2:condition2\").")
+}
+
+// mysqlIniOptions are the options of the [client] and [mysqldump] sections
+// of the ini file, with the name of their flag.
+var mysqlIniOptions = map[string]string{
+	"user":     "mysql-user",
+	"password": "mysql-password",
+	"host":     "mysql-host",
+	"port":     "mysql-port",
+	"socket":   "mysql-socket",
+}
+
+// commandOptions are the options of the go-dump commands that are not in
+// Options, like --debug or --grants of go-dump restore.
+var commandOptions = map[string]bool{
+	"help":             true,
+	"version":          true,
+	"debug":            true,
+	"quiet":            true,
+	"dry-run":          true,
+	"execute":          true,
+	"ini-file":         true,
+	"progress-bar":     true,
+	"metrics-listen":   true,
+	"log-format":       true,
+	"log-file":         true,
+	"grants":           true,
+	"allow-incomplete": true,
+}
+
+// isOption return true if the name is an option of any go-dump command.
+func isOption(name string) bool {
+	fs := flag.NewFlagSet("go-dump", flag.ContinueOnError)
+	DefaultOptions().AddFlags(fs)
+	return fs.Lookup(name) != nil || commandOptions[name]
+}
+
+// ParseIniFile sets the flags of the [go-dump] section of the ini file, and
+// the MySQL options of the [client] and [mysqldump] sections, that are not
+// set in the command line. The ini file can be shared by the go-dump
+// commands, so the options of the other commands are skipped.
+func ParseIniFile(iniFile string, fs *flag.FlagSet) error {
+	cfg, err := ini.Load(iniFile)
+	if err != nil {
+		return &utils.DumpError{Kind: utils.ErrIO, Err: fmt.Errorf("failed to read the ini file %s: %w", iniFile, err)}
+	}
+
+	flagSet := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { flagSet[f.Name] = true })
+
+	for _, section := range cfg.Sections() {
+		for _, key := range section.Keys() {
+			var name string
+			switch section.Name() {
+			case "client", "mysqldump":
+				if name = mysqlIniOptions[key.Name()]; name == "" {
+					continue
+				}
+			case "go-dump":
+				name = key.Name()
+			default:
+				continue
+			}
+			if flagSet[name] {
+				continue
+			}
+			if fs.Lookup(name) == nil {
+				if !isOption(name) {
+					log.Warningf("Unknown option %s", key.Name())
+				}
+				continue
+			}
+			// The empty numbers keep the default, like a missing option.
+			if key.Value() == "" && isNumberFlag(fs.Lookup(name)) {
+				continue
+			}
+			if err := fs.Set(name, key.Value()); err != nil {
+				return fmt.Errorf("error in the ini file %s: invalid value %s for %s: %w",
+					iniFile, key.Value(), key.Name(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// isNumberFlag return true if the value of the flag is a number.
+func isNumberFlag(f *flag.Flag) bool {
+	getter, ok := f.Value.(flag.Getter)
+	if !ok {
+		return false
+	}
+	switch getter.Get().(type) {
+	case int, uint64, time.Duration:
+		return true
+	}
+	return false
+}
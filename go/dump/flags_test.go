@@ -0,0 +1,102 @@
+package dump
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ChaosHour/go-dump/go/log"
+	"github.com/ChaosHour/go-dump/go/utils"
+)
+
+func TestAddFlags(t *testing.T) {
+	options := DefaultOptions()
+	fs := flag.NewFlagSet("go-dump", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	options.AddFlags(fs)
+
+	err := fs.Parse([]string{"--databases", "sakila, shop", "--tables", "test.city",
+		"--isolation-level", "read committed", "--consistent=false",
+		"--where", "sakila.city:city_id < 10,actor:actor_id > 5", "--threads", "4",
+		"--progress-interval", "30s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(options.Databases) != 2 || options.Databases[1] != "shop" || options.Tables[0] != "test.city" {
+		t.Errorf("Unexpected databases %v and tables %v", options.Databases, options.Tables)
+	}
+	if options.IsolationLevel != sql.LevelReadCommitted || options.Consistent || options.Threads != 4 ||
+		options.ProgressInterval != 30*time.Second || options.ChunksPerFile != utils.DefaultChunksPerFile {
+		t.Errorf("Unexpected options %+v", options)
+	}
+	if options.WhereConditions["`sakila`.`city`"] != "city_id < 10" || options.WhereConditions["actor"] != "actor_id > 5" {
+		t.Errorf("Unexpected where conditions %v", options.WhereConditions)
+	}
+
+	if err := fs.Parse([]string{"--isolation-level", "snapshot"}); err == nil {
+		t.Error("Expected an error with an unknown isolation level")
+	}
+}
+
+func TestParseIniFile(t *testing.T) {
+	options := DefaultOptions()
+	fs := flag.NewFlagSet("go-dump", flag.ContinueOnError)
+	options.AddFlags(fs)
+	var execute bool
+	fs.BoolVar(&execute, "execute", false, "Execute the dump.")
+
+	if err := fs.Parse([]string{"--mysql-user", "root"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ParseIniFile("../../test/test.ini", fs); err != nil {
+		t.Fatal(err)
+	}
+
+	if options.Threads != 3 || options.ChunkSize != 20000 || options.CompressLevel != 9 || !execute {
+		t.Errorf("Unexpected options %+v", options)
+	}
+	if options.MySQLCredentials.User != "root" {
+		t.Errorf("The MySQL user of the command line shouldn't change")
+	}
+	// The [go-dump] section comes after [mysqldump] and [client].
+	if options.MySQLCredentials.Password != "simpletest" || options.MySQLHost.HostName != "localhost" ||
+		options.MySQLHost.SocketFile != "" {
+		t.Errorf("Unexpected MySQL options %+v %+v", options.MySQLCredentials, options.MySQLHost)
+	}
+	if len(options.Databases) != 1 || options.Databases[0] != "test" || len(options.Tables) != 0 {
+		t.Errorf("Unexpected databases %v and tables %v", options.Databases, options.Tables)
+	}
+
+	if err := ParseIniFile("../../test/missing.ini", fs); !errors.Is(err, utils.ErrIO) {
+		t.Fatalf("Expected an I/O error and got %v", err)
+	}
+}
+
+func TestParseIniFileOtherCommand(t *testing.T) {
+	// The flags of go-dump restore, the options of the dump in test.ini are
+	// skipped without a warning.
+	options := DefaultOptions()
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	fs.IntVar(&options.Threads, "threads", options.Threads, "Number of threads to use.")
+	fs.StringVar(&options.DestinationDir, "destination", "", "Directory with the dump to restore.")
+
+	var warnings bytes.Buffer
+	log.SetOutput(&warnings)
+	defer log.SetOutput(os.Stderr)
+	if err := ParseIniFile("../../test/test.ini", fs); err != nil {
+		t.Fatal(err)
+	}
+	if options.Threads != 3 || options.DestinationDir != "/tmp/sss" || options.ChunkSize != 1000 {
+		t.Errorf("Unexpected options %+v", options)
+	}
+	if strings.Count(warnings.String(), "Unknown option") != 1 ||
+		!strings.Contains(warnings.String(), "Unknown option non-valid-option") {
+		t.Errorf("Unexpected warnings %q", warnings.String())
+	}
+}
@@ -0,0 +1,300 @@
+package dump
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ChaosHour/go-dump/go/utils"
+)
+
+// ErrInvalidOptions is the kind of the errors of Validate.
+var ErrInvalidOptions = errors.New("invalid options")
+
+// Options are the options of a dump. They are the options of the command
+// line without the ones that only change how it runs, like --dry-run or
+// --debug. Start from DefaultOptions, the zero value is not valid.
+type Options struct {
+	MySQLHost             *utils.MySQLHost
+	MySQLCredentials      *utils.MySQLCredentials
+	S3Options             *utils.S3Options
+	Databases             []string // databases to dump
+	Tables                []string // tables to dump, as schema.table
+	AllDatabases          bool
+	Include               string // patterns of the tables to dump
+	Exclude               string // patterns of the tables to skip
+	ExcludeDatabases      string // patterns of the databases to skip
+	Threads               int
+	ChunkSize             uint64
+	OutputChunkSize       uint64 // ChunkSize if 0
+	MaxStatementBytes     uint64
+	ChannelBufferSize     int
+	LockTables            bool
+	Consistent            bool
+	IsolationLevel        sql.IsolationLevel
+	TablesWithoutUKOption string
+	DestinationDir        string // local directory or s3://bucket/prefix
+	Sink                  utils.Sink
+	Resume                bool
+	AddDropTable          bool
+	GetMasterStatus       bool
+	GetSlaveStatus        bool
+	SkipUseDatabase       bool
+	Compress              bool
+	CompressAlgorithm     string
+	CompressLevel         int
+	Encrypt               bool
+	EncryptionOptions     *utils.EncryptionOptions
+	RowChecksum           bool
+	ChunksPerFile         uint64
+	Views                 bool
+	Triggers              bool
+	Routines              bool
+	Events                bool
+	NoData                bool
+	NoCreateInfo          bool
+	DumpGrants            bool
+	GrantsUsers           string
+	OutputFormat          string
+	CSVDelimiter          string
+	CSVQuote              string
+	CSVNull               string
+	WhereConditions       map[string]string     // table -> where condition
+	GlobalWhereCondition  string                // fallback for all tables
+	ProgressInterval      time.Duration         // time between the reports of the progress, 0 for none
+	OnProgress            func(*utils.Progress) // gets the reports, utils.LogProgress if nil
+	Metrics               *utils.Metrics        // no metrics if nil
+}
+
+// DefaultOptions return the options with the defaults of the command line.
+func DefaultOptions() *Options {
+	return &Options{
+		MySQLHost:             &utils.MySQLHost{HostName: "localhost", Port: 3306},
+		MySQLCredentials:      &utils.MySQLCredentials{User: "root"},
+		S3Options:             &utils.S3Options{PartSize: utils.DefaultS3PartSize, MaxRetries: 5},
+		Threads:               1,
+		ChunkSize:             1000,
+		ChunksPerFile:         utils.DefaultChunksPerFile,
+		ChannelBufferSize:     1000,
+		LockTables:            true,
+		Consistent:            true,
+		IsolationLevel:        sql.LevelRepeatableRead,
+		TablesWithoutUKOption: "error",
+		CompressAlgorithm:     utils.CompressAlgorithmGzip,
+		CompressLevel:         1,
+		EncryptionOptions:     &utils.EncryptionOptions{},
+		OutputFormat:          utils.OutputFormatSQL,
+		CSVQuote:              "\"",
+		CSVNull:               utils.DefaultCSVNull,
+		WhereConditions:       make(map[string]string),
+		ProgressInterval:      10 * time.Second,
+	}
+}
+
+// isolationLevels are the isolation levels by their name in MySQL.
+var isolationLevels = map[string]sql.IsolationLevel{
+	"SERIALIZABLE":     sql.LevelSerializable,
+	"REPEATABLE READ":  sql.LevelRepeatableRead,
+	"READ COMMITTED":   sql.LevelReadCommitted,
+	"READ UNCOMMITTED": sql.LevelReadUncommitted,
+}
+
+// ParseIsolationLevel return the isolation level of a name like
+// "REPEATABLE READ".
+func ParseIsolationLevel(name string) (sql.IsolationLevel, error) {
+	level, ok := isolationLevels[strings.ToUpper(strings.TrimSpace(name))]
+	if !ok {
+		return 0, fmt.Errorf("unknown isolation level %s", name)
+	}
+	return level, nil
+}
+
+// isolationLevelName return the name in MySQL of an isolation level.
+func isolationLevelName(level sql.IsolationLevel) string {
+	for name, l := range isolationLevels {
+		if l == level {
+			return name
+		}
+	}
+	return level.String()
+}
+
+func invalidOptions(format string, args ...interface{}) error {
+	return &utils.DumpError{Kind: ErrInvalidOptions, Err: fmt.Errorf(format, args...)}
+}
+
+// Validate checks that the options can be used together. The errors are of
+// the kind ErrInvalidOptions.
+func (o *Options) Validate() error {
+	if o.MySQLHost == nil || o.MySQLCredentials == nil {
+		return invalidOptions("the MySQL host and credentials are required")
+	}
+	if o.Threads < 1 {
+		return invalidOptions("the number of threads must be at least 1")
+	}
+	switch o.TablesWithoutUKOption {
+	case "error", "single-chunk":
+	case "skip":
+		return invalidOptions("the option \"skip\" for the tables without unique key is not implemented yet")
+	default:
+		return invalidOptions("\"%s\" is not a valid option for the tables without unique key", o.TablesWithoutUKOption)
+	}
+
+	// A consistent dump needs the tables locked while the transactions start,
+	// and the same snapshot in all of them.
+	if !o.LockTables && o.Consistent {
+		return invalidOptions("lock tables is required to get a consistent backup")
+	}
+	if _, ok := isolationLevels[isolationLevelName(o.IsolationLevel)]; !ok {
+		return invalidOptions("unknown isolation level %s", o.IsolationLevel)
+	}
+	if o.Consistent && o.IsolationLevel != sql.LevelRepeatableRead && o.IsolationLevel != sql.LevelSerializable {
+		return invalidOptions("the isolation level \"%s\" is not compatible with a consistent backup",
+			isolationLevelName(o.IsolationLevel))
+	}
+
+	if o.DestinationDir == "" && o.Sink == nil {
+		return invalidOptions("the destination is required")
+	}
+	if o.Sink == nil && utils.IsS3Destination(o.DestinationDir) {
+		if _, _, err := utils.ParseS3Destination(o.DestinationDir); err != nil {
+			return invalidOptions("%s", err.Error())
+		}
+		if o.S3Options == nil {
+			return invalidOptions("the S3 options are required with an S3 destination")
+		}
+		if o.S3Options.PartSize < utils.MinS3PartSize {
+			return invalidOptions("the size of the S3 parts must be at least %d", utils.MinS3PartSize)
+		}
+		if o.S3Options.MaxRetries < 0 {
+			return invalidOptions("the number of S3 retries can not be negative")
+		}
+	}
+	for _, table := range o.Tables {
+		if t := strings.SplitN(table, ".", 2); len(t) != 2 || t[0] == "" || t[1] == "" {
+			return invalidOptions("the table %s is not in the format schema.table", table)
+		}
+	}
+	if o.ChunkSize == 0 {
+		return invalidOptions("the chunk size must be greater than 0")
+	}
+	if o.ProgressInterval < 0 {
+		return invalidOptions("the interval of the progress reports can not be negative")
+	}
+
+	if !utils.IsValidOutputFormat(o.OutputFormat) {
+		return invalidOptions("unknown output format %s", o.OutputFormat)
+	}
+	if utils.IsDelimitedFormat(o.OutputFormat) {
+		csvOptions := o.dumpOptions().GetCSVOptions()
+		if len(csvOptions.Delimiter) != 1 || len(csvOptions.Quote) > 1 {
+			return invalidOptions("the CSV delimiter and quote must be a single character")
+		}
+		if csvOptions.Delimiter == csvOptions.Quote {
+			return invalidOptions("the CSV delimiter and quote must be different")
+		}
+		if csvOptions.Delimiter == `\` || csvOptions.Quote == `\` {
+			return invalidOptions("the CSV delimiter and quote can not be the escape character \\")
+		}
+		switch csvOptions.Null {
+		case utils.DefaultCSVNull:
+		case utils.CSVNullWord:
+			if csvOptions.Quote == "" {
+				return invalidOptions("the CSV NULL value %s needs a quote character", utils.CSVNullWord)
+			}
+		default:
+			return invalidOptions("the CSV NULL value must be %s or %s, the values read as NULL by LOAD DATA",
+				utils.DefaultCSVNull, utils.CSVNullWord)
+		}
+	}
+	if !utils.IsValidCompressAlgorithm(o.CompressAlgorithm) {
+		return invalidOptions("unknown compression algorithm %s", o.CompressAlgorithm)
+	}
+	if o.CompressLevel < 1 || o.CompressLevel > 9 {
+		return invalidOptions("the compression level must be a number between 1 and 9")
+	}
+	if o.Encrypt && o.EncryptionOptions == nil {
+		return invalidOptions("the encryption options are required to encrypt the dump")
+	}
+	if _, err := utils.NewTableFilter(o.Include, o.Exclude, o.ExcludeDatabases); err != nil {
+		return invalidOptions("%s", err.Error())
+	}
+
+	if o.NoData && o.NoCreateInfo {
+		return invalidOptions("the options to dump no data and no table definitions are mutually exclusive")
+	}
+	if o.Resume {
+		if o.Sink != nil || utils.IsS3Destination(o.DestinationDir) {
+			return invalidOptions("resuming a dump is only supported with a local destination")
+		}
+		if o.NoData {
+			return invalidOptions("resuming a dump is not supported without data")
+		}
+	}
+	return nil
+}
+
+// dumpOptions return the options for the task manager.
+func (o *Options) dumpOptions() *utils.DumpOptions {
+	outputChunkSize := o.OutputChunkSize
+	if outputChunkSize == 0 {
+		outputChunkSize = o.ChunkSize
+	}
+	tableFilter, _ := utils.NewTableFilter(o.Include, o.Exclude, o.ExcludeDatabases)
+
+	return &utils.DumpOptions{
+		MySQLHost:             o.MySQLHost,
+		MySQLCredentials:      o.MySQLCredentials,
+		S3Options:             o.S3Options,
+		Threads:               o.Threads,
+		ChunkSize:             o.ChunkSize,
+		OutputChunkSize:       outputChunkSize,
+		MaxStatementBytes:     o.MaxStatementBytes,
+		ChannelBufferSize:     o.ChannelBufferSize,
+		LockTables:            o.LockTables,
+		TablesWithoutUKOption: o.TablesWithoutUKOption,
+		DestinationDir:        o.DestinationDir,
+		AddDropTable:          o.AddDropTable,
+		GetMasterStatus:       o.GetMasterStatus,
+		GetSlaveStatus:        o.GetSlaveStatus,
+		SkipUseDatabase:       o.SkipUseDatabase,
+		Compress:              o.Compress,
+		CompressAlgorithm:     o.CompressAlgorithm,
+		CompressLevel:         o.CompressLevel,
+		Encrypt:               o.Encrypt,
+		EncryptionOptions:     o.EncryptionOptions,
+		RowChecksum:           o.RowChecksum,
+		ChunksPerFile:         o.ChunksPerFile,
+		Views:                 o.Views,
+		Triggers:              o.Triggers,
+		Routines:              o.Routines,
+		Events:                o.Events,
+		NoData:                o.NoData,
+		NoCreateInfo:          o.NoCreateInfo,
+		DumpGrants:            o.DumpGrants,
+		GrantsUsers:           o.GrantsUsers,
+		OutputFormat:          o.OutputFormat,
+		CSVDelimiter:          utils.ParseDelimiter(o.CSVDelimiter),
+		CSVQuote:              o.CSVQuote,
+		CSVNull:               o.CSVNull,
+		IsolationLevel:        o.IsolationLevel,
+		Consistent:            o.Consistent,
+		WhereConditions:       o.WhereConditions,
+		GlobalWhereCondition:  o.GlobalWhereCondition,
+		Include:               o.Include,
+		Exclude:               o.Exclude,
+		ExcludeDatabases:      o.ExcludeDatabases,
+		TableFilter:           tableFilter,
+		Sink:                  o.Sink,
+		Metrics:               o.Metrics,
+		TemporalOptions: utils.TemporalOptions{
+			Databases:      strings.Join(o.Databases, ","),
+			Tables:         strings.Join(o.Tables, ","),
+			AllDatabases:   o.AllDatabases,
+			IsolationLevel: isolationLevelName(o.IsolationLevel),
+			Resume:         o.Resume,
+		},
+	}
+}
@@ -0,0 +1,81 @@
+package dump
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	valid := func() *Options {
+		options := DefaultOptions()
+		options.DestinationDir = "/tmp/dump"
+		return options
+	}
+	if err := valid().Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, change := range map[string]func(o *Options){
+		"no destination":        func(o *Options) { o.DestinationDir = "" },
+		"no threads":            func(o *Options) { o.Threads = 0 },
+		"no chunk size":         func(o *Options) { o.ChunkSize = 0 },
+		"consistent unlocked":   func(o *Options) { o.LockTables = false },
+		"consistent read":       func(o *Options) { o.IsolationLevel = sql.LevelReadCommitted },
+		"unknown isolation":     func(o *Options) { o.IsolationLevel = sql.LevelSnapshot },
+		"tables without key":    func(o *Options) { o.TablesWithoutUKOption = "skip" },
+		"output format":         func(o *Options) { o.OutputFormat = "xml" },
+		"csv delimiter":         func(o *Options) { o.OutputFormat, o.CSVDelimiter = "csv", ";;" },
+		"csv escape delimiter":  func(o *Options) { o.OutputFormat, o.CSVDelimiter = "csv", `\` },
+		"csv null":              func(o *Options) { o.OutputFormat, o.CSVNull = "csv", "<NULL>" },
+		"csv null unquoted":     func(o *Options) { o.OutputFormat, o.CSVNull, o.CSVQuote = "tsv", "NULL", "" },
+		"compress level":        func(o *Options) { o.CompressLevel = 10 },
+		"compress algorithm":    func(o *Options) { o.CompressAlgorithm = "bzip2" },
+		"include pattern":       func(o *Options) { o.Include = "/order_(/" },
+		"no data and no create": func(o *Options) { o.NoData, o.NoCreateInfo = true, true },
+		"resume to s3":          func(o *Options) { o.Resume, o.DestinationDir = true, "s3://bucket/dump" },
+		"s3 part size":          func(o *Options) { o.DestinationDir, o.S3Options.PartSize = "s3://bucket/dump", 1024 },
+		"progress interval":     func(o *Options) { o.ProgressInterval = -time.Second },
+		"table without schema":  func(o *Options) { o.Tables = []string{"sakila.city", "city"} },
+	} {
+		options := valid()
+		change(options)
+		if err := options.Validate(); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("Expected invalid options with %s and got %v", name, err)
+		}
+		if _, err := New(options); err == nil {
+			t.Errorf("New should validate the options with %s", name)
+		}
+	}
+
+	// The consistency is not needed with the other isolation levels.
+	options := valid()
+	options.Consistent, options.IsolationLevel = false, sql.LevelReadCommitted
+	if err := options.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDumpOptions(t *testing.T) {
+	options := DefaultOptions()
+	options.DestinationDir = "/tmp/dump"
+	options.Databases = []string{"sakila", "shop"}
+	options.Tables = []string{"test.city"}
+	options.CSVDelimiter = `\t`
+	options.IsolationLevel = sql.LevelSerializable
+
+	do := options.dumpOptions()
+	if do.OutputChunkSize != options.ChunkSize {
+		t.Errorf("The output chunk size should be the chunk size and is %d", do.OutputChunkSize)
+	}
+	if do.TemporalOptions.Databases != "sakila,shop" || do.TemporalOptions.Tables != "test.city" {
+		t.Errorf("Unexpected databases %s and tables %s", do.TemporalOptions.Databases, do.TemporalOptions.Tables)
+	}
+	if do.TemporalOptions.IsolationLevel != "SERIALIZABLE" {
+		t.Errorf("Unexpected isolation level %s", do.TemporalOptions.IsolationLevel)
+	}
+	if do.CSVDelimiter != "\t" || do.TableFilter == nil {
+		t.Errorf("Unexpected delimiter %q or filter %v", do.CSVDelimiter, do.TableFilter)
+	}
+}
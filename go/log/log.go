@@ -0,0 +1,266 @@
+// Package log writes the log of go-dump. It has the functions of
+// github.com/outbrain/golib/log used by go-dump, with the same text format,
+// and can write each entry as a JSON object in a line instead. With adds the
+// fields of an event, like the table or the chunk, to the JSON objects. The
+// entries are written by a log/slog handler.
+package log
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// LogLevel indicates the severity of a log entry.
+type LogLevel int
+
+const (
+	FATAL LogLevel = iota
+	CRITICAL
+	ERROR
+	WARNING
+	NOTICE
+	INFO
+	DEBUG
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case FATAL:
+		return "FATAL"
+	case CRITICAL:
+		return "CRITICAL"
+	case ERROR:
+		return "ERROR"
+	case WARNING:
+		return "WARNING"
+	case NOTICE:
+		return "NOTICE"
+	case INFO:
+		return "INFO"
+	case DEBUG:
+		return "DEBUG"
+	}
+	return "unknown"
+}
+
+// slogLevels are the slog levels of the levels of the log.
+var slogLevels = map[LogLevel]slog.Level{
+	FATAL:    slog.LevelError + 8,
+	CRITICAL: slog.LevelError + 4,
+	ERROR:    slog.LevelError,
+	WARNING:  slog.LevelWarn,
+	NOTICE:   slog.LevelInfo + 2,
+	INFO:     slog.LevelInfo,
+	DEBUG:    slog.LevelDebug,
+}
+
+// levelName return the name of a slog level of the log.
+func levelName(l slog.Level) string {
+	for logLevel, slogLevel := range slogLevels {
+		if slogLevel == l {
+			return logLevel.String()
+		}
+	}
+	return l.String()
+}
+
+// The formats of the entries.
+const (
+	FormatText = "text" // "2006-01-02 15:04:05 INFO message", without the fields
+	FormatJSON = "json" // {"time":"...","level":"INFO","msg":"message","key":"value"}
+)
+
+// TimeFormat is the format of the time in the text entries.
+const TimeFormat = "2006-01-02 15:04:05"
+
+// jsonTimeFormat is the format of the time in the JSON entries.
+const jsonTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+var (
+	mutex            = sync.Mutex{}
+	level            = DEBUG
+	format           = FormatText
+	output io.Writer = os.Stderr
+	logger           = newLogger()
+	exit             = os.Exit
+)
+
+// newLogger return the logger for the format and the output. It's called
+// with the mutex locked.
+func newLogger() *slog.Logger {
+	if format == FormatJSON {
+		return slog.New(slog.NewJSONHandler(output, &slog.HandlerOptions{
+			Level:       slogLevels[level],
+			ReplaceAttr: replaceJSONAttr,
+		}))
+	}
+	return slog.New(&textHandler{level: slogLevels[level], output: output})
+}
+
+// replaceJSONAttr writes the time with milliseconds, the level with the
+// names of the text format and the durations in seconds.
+func replaceJSONAttr(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) == 0 {
+		switch a.Key {
+		case slog.TimeKey:
+			return slog.String(slog.TimeKey, a.Value.Time().Format(jsonTimeFormat))
+		case slog.LevelKey:
+			return slog.String(slog.LevelKey, levelName(a.Value.Any().(slog.Level)))
+		}
+	}
+	if a.Value.Kind() == slog.KindDuration {
+		return slog.Float64(a.Key, a.Value.Duration().Seconds())
+	}
+	return a
+}
+
+// textHandler writes the entries like golib. The fields are not written,
+// the message has the details.
+type textHandler struct {
+	level  slog.Level
+	mutex  sync.Mutex
+	output io.Writer
+}
+
+func (h *textHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.level
+}
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	_, err := fmt.Fprintf(h.output, "%s %s %s\n", r.Time.Format(TimeFormat), levelName(r.Level), r.Message)
+	return err
+}
+
+func (h *textHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h *textHandler) WithGroup(string) slog.Handler      { return h }
+
+// SetLevel sets the level of the entries written. The entries with a
+// higher level, less severe, are discarded.
+func SetLevel(logLevel LogLevel) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	level = logLevel
+	logger = newLogger()
+}
+
+// GetLevel return the level of the entries written.
+func GetLevel() LogLevel {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return level
+}
+
+// SetFormat sets the format of the entries, FormatText or FormatJSON.
+func SetFormat(logFormat string) error {
+	if logFormat != FormatText && logFormat != FormatJSON {
+		return fmt.Errorf("unknown log format %s", logFormat)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	format = logFormat
+	logger = newLogger()
+	return nil
+}
+
+// SetOutput sets where the entries are written, os.Stderr by default.
+func SetOutput(w io.Writer) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	output = w
+	logger = newLogger()
+}
+
+// getLogger return the logger and the format of the log.
+func getLogger() (*slog.Logger, string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return logger, format
+}
+
+// Entry is an entry with the fields of an event.
+type Entry struct {
+	fields []interface{}
+}
+
+// With return an entry with the fields, as pairs of a key and a value, like
+// With("table", "sakila.city", "chunk", 3).
+func With(keyValues ...interface{}) *Entry {
+	return &Entry{fields: keyValues}
+}
+
+// With return a copy of the entry with more fields.
+func (e *Entry) With(keyValues ...interface{}) *Entry {
+	fields := append(append([]interface{}{}, e.fields...), keyValues...)
+	return &Entry{fields: fields}
+}
+
+func (e *Entry) Debug(message string, args ...interface{})    { e.log(DEBUG, joinArgs(message, args)) }
+func (e *Entry) Debugf(message string, args ...interface{})   { e.logf(DEBUG, message, args...) }
+func (e *Entry) Info(message string, args ...interface{})     { e.log(INFO, joinArgs(message, args)) }
+func (e *Entry) Infof(message string, args ...interface{})    { e.logf(INFO, message, args...) }
+func (e *Entry) Warning(message string, args ...interface{})  { e.log(WARNING, joinArgs(message, args)) }
+func (e *Entry) Warningf(message string, args ...interface{}) { e.logf(WARNING, message, args...) }
+func (e *Entry) Error(message string, args ...interface{})    { e.log(ERROR, joinArgs(message, args)) }
+func (e *Entry) Errorf(message string, args ...interface{})   { e.logf(ERROR, message, args...) }
+
+// Eventf writes an event, like a chunk dumped, for the programs that read
+// the JSON log. It's an INFO entry in the JSON format and a DEBUG entry in
+// the text format, where the progress lines have the counters.
+func (e *Entry) Eventf(message string, args ...interface{}) {
+	if _, logFormat := getLogger(); logFormat == FormatJSON {
+		e.logf(INFO, message, args...)
+		return
+	}
+	e.logf(DEBUG, message, args...)
+}
+
+// Fatal writes a FATAL entry and exits with 1.
+func (e *Entry) Fatal(message string, args ...interface{}) {
+	e.log(FATAL, joinArgs(message, args))
+	exit(1)
+}
+
+// Fatalf writes a FATAL entry and exits with 1.
+func (e *Entry) Fatalf(message string, args ...interface{}) {
+	e.logf(FATAL, message, args...)
+	exit(1)
+}
+
+func Debug(message string, args ...interface{})    { (&Entry{}).log(DEBUG, joinArgs(message, args)) }
+func Debugf(message string, args ...interface{})   { (&Entry{}).logf(DEBUG, message, args...) }
+func Info(message string, args ...interface{})     { (&Entry{}).log(INFO, joinArgs(message, args)) }
+func Infof(message string, args ...interface{})    { (&Entry{}).logf(INFO, message, args...) }
+func Warning(message string, args ...interface{})  { (&Entry{}).log(WARNING, joinArgs(message, args)) }
+func Warningf(message string, args ...interface{}) { (&Entry{}).logf(WARNING, message, args...) }
+func Error(message string, args ...interface{})    { (&Entry{}).log(ERROR, joinArgs(message, args)) }
+func Errorf(message string, args ...interface{})   { (&Entry{}).logf(ERROR, message, args...) }
+func Fatal(message string, args ...interface{})    { (&Entry{}).Fatal(message, args...) }
+func Fatalf(message string, args ...interface{})   { (&Entry{}).Fatalf(message, args...) }
+
+// joinArgs return the message with the args separated by spaces, like the
+// functions of golib without format.
+func joinArgs(message string, args []interface{}) string {
+	for _, arg := range args {
+		message += fmt.Sprintf(" %s", arg)
+	}
+	return message
+}
+
+func (e *Entry) logf(logLevel LogLevel, message string, args ...interface{}) {
+	if logLevel > GetLevel() {
+		return
+	}
+	e.log(logLevel, fmt.Sprintf(message, args...))
+}
+
+// log writes the entry with the logger of the format.
+func (e *Entry) log(logLevel LogLevel, message string) {
+	l, _ := getLogger()
+	l.Log(context.Background(), slogLevels[logLevel], message, e.fields...)
+}
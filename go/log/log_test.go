@@ -0,0 +1,118 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// capture sets the format and the level of the log, writing to a buffer,
+// until the test ends.
+func capture(t *testing.T, logFormat string, logLevel LogLevel) *bytes.Buffer {
+	buffer := new(bytes.Buffer)
+	if err := SetFormat(logFormat); err != nil {
+		t.Fatal(err)
+	}
+	SetLevel(logLevel)
+	SetOutput(buffer)
+	t.Cleanup(func() {
+		SetFormat(FormatText)
+		SetLevel(DEBUG)
+		SetOutput(os.Stderr)
+	})
+	return buffer
+}
+
+func TestText(t *testing.T) {
+	buffer := capture(t, FormatText, DEBUG)
+	With("table", "sakila.city").Infof("Table %s processed", "sakila.city")
+	Warning("Lock", "released")
+
+	pattern := regexp.MustCompile(`^\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2} INFO Table sakila.city processed\n` +
+		`\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2} WARNING Lock released\n$`)
+	if !pattern.Match(buffer.Bytes()) {
+		t.Errorf("Unexpected text log %q", buffer.String())
+	}
+}
+
+func TestJSON(t *testing.T) {
+	buffer := capture(t, FormatJSON, DEBUG)
+	With("table", "sakila.city", "chunk", 3).With("duration", 1500*time.Millisecond, "error", errors.New("timeout")).
+		Errorf("Chunk %d failed", 3)
+
+	line := buffer.String()
+	expected := regexp.MustCompile(`^\{"time":"[^"]+","level":"ERROR","msg":"Chunk 3 failed","table":"sakila.city",` +
+		`"chunk":3,"duration":1.5,"error":"timeout"\}\n$`)
+	if !expected.MatchString(line) {
+		t.Errorf("Unexpected JSON log %q", line)
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := time.Parse(time.RFC3339, entry["time"].(string)); err != nil {
+		t.Errorf("Unexpected time %v", entry["time"])
+	}
+}
+
+func TestLevel(t *testing.T) {
+	buffer := capture(t, FormatText, INFO)
+	Debugf("Chunk %d dumped", 1)
+	With("chunk", 1).Debug("Chunk dumped")
+	if buffer.Len() != 0 {
+		t.Errorf("Unexpected debug log %q", buffer.String())
+	}
+	Error("Chunk failed")
+	if buffer.Len() == 0 {
+		t.Error("The error was not logged")
+	}
+	if GetLevel() != INFO {
+		t.Errorf("Unexpected level %s", GetLevel())
+	}
+}
+
+func TestEvent(t *testing.T) {
+	// The events are INFO entries in the JSON log, and DEBUG entries in the
+	// text log.
+	buffer := capture(t, FormatJSON, INFO)
+	With("chunk", 1).Eventf("Chunk %d dumped", 1)
+	if !bytes.Contains(buffer.Bytes(), []byte(`"level":"INFO","msg":"Chunk 1 dumped","chunk":1`)) {
+		t.Errorf("Unexpected event %q", buffer.String())
+	}
+
+	buffer = capture(t, FormatText, INFO)
+	With("chunk", 1).Eventf("Chunk %d dumped", 1)
+	if buffer.Len() != 0 {
+		t.Errorf("Unexpected event in the text log %q", buffer.String())
+	}
+	SetLevel(DEBUG)
+	With("chunk", 1).Eventf("Chunk %d dumped", 1)
+	if !bytes.Contains(buffer.Bytes(), []byte(" DEBUG Chunk 1 dumped\n")) {
+		t.Errorf("Unexpected event %q", buffer.String())
+	}
+}
+
+func TestSetFormat(t *testing.T) {
+	if err := SetFormat("xml"); err == nil {
+		t.Error("Expected an error with an unknown format")
+	}
+}
+
+func TestFatal(t *testing.T) {
+	buffer := capture(t, FormatJSON, DEBUG)
+	code := -1
+	exit = func(c int) { code = c }
+	defer func() { exit = os.Exit }()
+
+	With("exit_code", 2).Fatalf("Cannot connect")
+	if code != 1 {
+		t.Errorf("Unexpected exit code %d", code)
+	}
+	if !bytes.Contains(buffer.Bytes(), []byte(`"level":"FATAL","msg":"Cannot connect","exit_code":2`)) {
+		t.Errorf("Unexpected fatal log %q", buffer.String())
+	}
+}
@@ -2,34 +2,106 @@ package utils
 
 import (
 	"bufio"
-	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"os"
+	"hash"
+	"io"
 	"path/filepath"
 
-	"github.com/outbrain/golib/log"
+	"github.com/parquet-go/parquet-go"
 
 	"strings"
 )
 
-const BufferTypeGzipFile = "gzip"
+// The type of a compressed buffer is the name of the compression algorithm.
+const BufferTypeGzipFile = CompressAlgorithmGzip
+
+const BufferTypeZstdFile = CompressAlgorithmZstd
+
+const BufferTypeLz4File = CompressAlgorithmLz4
 
 const BufferTypeFile = "file"
 
+const BufferTypeParquetFile = "parquet"
+
 type BufferOptions struct {
-	Compress      bool
-	CompressLevel int
-	Type          string
-	Path          string
+	Compress          bool
+	CompressAlgorithm string // gzip if empty
+	CompressLevel     int
+	EncryptionKey     *EncryptionKey // no encryption if nil
+	Type              string
+	Name              string
+	Sink              Sink
+	Table             *Table
 }
 
-// Buffer is the default struct to write the data.
+// Buffer is the default struct to write the data. The data goes through the
+// bufio writer, the compressor and the encryptor, if any, to an object of
+// the Sink.
 type Buffer struct {
-	Type           string
-	Buffer         *bufio.Writer
-	GzipWriter     *gzip.Writer
-	FileDescriptor *os.File
+	Type          string
+	Buffer        *bufio.Writer
+	Compressor    io.WriteCloser
+	Encryptor     io.WriteCloser
+	ParquetWriter *parquet.Writer
+	Writer        SinkWriter
+	FileName      string
+	stored        *checksumWriter // bytes written to the sink
+	data          *checksumWriter // bytes before the compression and the encryption
+	dataReported  uint64          // bytes of data returned by newDataBytes
+}
+
+// checksumWriter counts and hashes the bytes written.
+type checksumWriter struct {
+	w    io.Writer
+	n    uint64
+	hash hash.Hash
+}
+
+func newChecksumWriter(w io.Writer) *checksumWriter {
+	return &checksumWriter{w: w, hash: sha256.New()}
+}
+
+func (c *checksumWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += uint64(n)
+	c.hash.Write(p[:n])
+	return n, err
+}
+
+func (c *checksumWriter) sum() string {
+	return hex.EncodeToString(c.hash.Sum(nil))
+}
+
+// Size return the number of bytes written to the sink, after the
+// compression and the encryption.
+func (b *Buffer) Size() uint64 {
+	return b.stored.n
+}
+
+// newDataBytes return the bytes of data written since the last call. The
+// bytes still in the buffers are counted once they are flushed.
+func (b *Buffer) newDataBytes() uint64 {
+	if b.data == nil {
+		return 0
+	}
+	n := b.data.n - b.dataReported
+	b.dataReported = b.data.n
+	return n
+}
+
+// Checksum return the SHA-256 of the bytes written to the sink. It's only
+// complete after Close.
+func (b *Buffer) Checksum() string {
+	return b.stored.sum()
+}
+
+// DataChecksum return the SHA-256 of the data before the compression and the
+// encryption. It's only complete after Close.
+func (b *Buffer) DataChecksum() string {
+	return b.data.sum()
 }
 
 // Write a slice of bytes into the buffer.
@@ -44,74 +116,176 @@ func (b *Buffer) Flush() error {
 
 // Close execute the close statements for each buffer type.
 func (b *Buffer) Close() error {
+	if b.ParquetWriter != nil {
+		// Writes the footer of the parquet file.
+		if err := b.ParquetWriter.Close(); err != nil {
+			b.Writer.Close()
+			return err
+		}
+	}
 	if err := b.Flush(); err != nil {
+		b.Writer.Close()
 		return err
 	}
 
-	switch b.Type {
-	case BufferTypeGzipFile:
-		if err := b.GzipWriter.Close(); err != nil {
+	if b.Compressor != nil {
+		if err := b.Compressor.Close(); err != nil {
+			b.Writer.Close()
 			return err
 		}
-		return b.FileDescriptor.Close()
-	case BufferTypeFile:
-		return b.FileDescriptor.Close()
 	}
-	return nil
+	if b.Encryptor != nil {
+		if err := b.Encryptor.Close(); err != nil {
+			b.Writer.Close()
+			return err
+		}
+	}
+	return b.Writer.Close()
+}
+
+// Abort closes a buffer that is incomplete because the dump failed. The data
+// written is flushed, and the object is discarded or kept as partial if the
+// writer of the sink implements SinkAborter.
+func (b *Buffer) Abort() error {
+	if b.ParquetWriter == nil {
+		b.Flush()
+	}
+	if b.Compressor != nil {
+		b.Compressor.Close()
+	}
+	if b.Encryptor != nil {
+		b.Encryptor.Close()
+	}
+	if aborter, ok := b.Writer.(SinkAborter); ok {
+		return aborter.Abort()
+	}
+	return b.Writer.Close()
 }
 
 func NewBuffer(options *BufferOptions) (*Buffer, error) {
-	if options.Type == BufferTypeFile {
-		return NewFileBuffer(options.Path, options.Compress, options.CompressLevel), nil
+	sink := options.Sink
+	if sink == nil {
+		return nil, errors.New("Buffer without sink for " + options.Name)
+	}
+	compressAlgorithm := ""
+	if options.Compress {
+		compressAlgorithm = options.CompressAlgorithm
+		if compressAlgorithm == "" {
+			compressAlgorithm = CompressAlgorithmGzip
+		}
+	}
+	switch options.Type {
+	case BufferTypeFile:
+		return NewSinkBuffer(sink, options.Name, compressAlgorithm, options.CompressLevel, options.EncryptionKey)
+	case BufferTypeParquetFile:
+		return NewParquetBuffer(sink, options.Name, options.Table, compressAlgorithm, options.CompressLevel,
+			options.EncryptionKey)
 	}
 	return nil, errors.New("Buffer type " + options.Type + " not susported.")
 }
 
-func NewFileBuffer(fileName string, compress bool, compressLevel int) *Buffer {
-	var fileDescriptor *os.File
-	var err error
-	if compress && !strings.HasSuffix(fileName, ".gz") {
-		fileName = fileName + ".gz"
+// newSinkObject creates the object of the sink for a buffer. With a key the
+// object is encrypted and the name gets the ".enc" suffix. It returns the
+// writer where the buffer should write.
+func newSinkObject(sink Sink, name string, encryptionKey *EncryptionKey) (*Buffer, io.Writer, error) {
+	if encryptionKey != nil {
+		name = name + EncryptExtension
+	}
+	writer, err := sink.Create(name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating the file %s: %v", name, err)
+	}
+	stored := newChecksumWriter(writer)
+	buffer := &Buffer{Type: BufferTypeFile, Writer: writer, FileName: name, stored: stored}
+	if encryptionKey == nil {
+		return buffer, stored, nil
 	}
 
-	fileDescriptor, err = os.Create(fileName)
+	encryptor, err := newEncryptWriter(stored, encryptionKey)
 	if err != nil {
-		log.Fatalf("Error crating the file %s: %s", fileName, err.Error())
+		writer.Close()
+		return nil, nil, fmt.Errorf("error encrypting the file %s: %v", name, err)
 	}
+	buffer.Encryptor = encryptor
+	return buffer, encryptor, nil
+}
 
+// NewSinkBuffer creates a buffer writing to a new object of the sink. With a
+// compression algorithm the data is compressed and the name gets the suffix
+// of the algorithm, like ".gz". An empty algorithm means no compression. The
+// compressed data is encrypted when there is an encryption key.
+func NewSinkBuffer(sink Sink, name string, compressAlgorithm string, compressLevel int,
+	encryptionKey *EncryptionKey) (*Buffer, error) {
+	compress := compressAlgorithm != ""
 	if compress {
-		gzipWriter, err := gzip.NewWriterLevel(fileDescriptor, compressLevel)
+		if !IsValidCompressAlgorithm(compressAlgorithm) {
+			return nil, fmt.Errorf("unknown compression algorithm %s", compressAlgorithm)
+		}
+		if extension := GetCompressExtension(compressAlgorithm); !strings.HasSuffix(name, extension) {
+			name = name + extension
+		}
+	}
+
+	buffer, writer, err := newSinkObject(sink, name, encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if compress {
+		compressor, err := newCompressWriter(writer, compressAlgorithm, compressLevel)
 		if err != nil {
-			log.Fatalf("Error getting gzip writer: %s", err.Error())
+			buffer.Writer.Close()
+			return nil, fmt.Errorf("error getting %s writer: %v", compressAlgorithm, err)
 		}
-		buffer := bufio.NewWriter(gzipWriter)
-		return &Buffer{Type: BufferTypeGzipFile, Buffer: buffer, GzipWriter: gzipWriter}
+		buffer.Type = compressAlgorithm
+		buffer.Compressor = compressor
+		writer = compressor
 	}
-	buffer := bufio.NewWriter(fileDescriptor)
-	return &Buffer{Type: BufferTypeFile, Buffer: buffer}
+	buffer.data = newChecksumWriter(writer)
+	buffer.Buffer = bufio.NewWriter(buffer.data)
+	return buffer, nil
+}
 
+// NewFileBuffer creates a buffer writing to a local file.
+func NewFileBuffer(fileName string, compressAlgorithm string, compressLevel int) (*Buffer, error) {
+	return NewSinkBuffer(NewFileSink(filepath.Dir(fileName)), filepath.Base(fileName),
+		compressAlgorithm, compressLevel, nil)
 }
 
-func NewChunkBuffer(c *DataChunk, workerId int) (*Buffer, error) {
+// NewChunkBuffer creates the data file of a worker for the table of the
+// chunk. The part is 0 for the first file of the worker and the next files
+// get the part in the name, like "mydb.mytable-thread0-1.sql".
+func NewChunkBuffer(c *DataChunk, workerId int, part int) (*Buffer, error) {
 
 	var filename string
+	outputFormat := c.Task.TaskManager.DumpOptions.OutputFormat
+	extension := GetOutputFormatExtension(outputFormat)
 	if c.IsSingleChunk {
-		filename = fmt.Sprintf("%s.sql", c.Task.Table.GetUnescapedFullName())
+		filename = fmt.Sprintf("%s.%s", c.Task.Table.GetUnescapedFullName(), extension)
+	} else if part > 0 {
+		filename = fmt.Sprintf("%s-thread%d-%d.%s", c.Task.Table.GetUnescapedFullName(), workerId, part, extension)
 	} else {
-		filename = fmt.Sprintf("%s-thread%d.sql", c.Task.Table.GetUnescapedFullName(), workerId)
+		filename = fmt.Sprintf("%s-thread%d.%s", c.Task.Table.GetUnescapedFullName(), workerId, extension)
 	}
-	fullpath := filepath.Join(c.Task.TaskManager.DestinationDir, filename)
-
 	bufferOptions := c.Task.TaskManager.GetBufferOptions()
-	bufferOptions.Path = fullpath
+	bufferOptions.Name = filename
+	if outputFormat == OutputFormatParquet {
+		bufferOptions.Type = BufferTypeParquetFile
+		bufferOptions.Table = c.Task.Table
+	}
 
 	buffer, err := NewBuffer(bufferOptions)
 	if err != nil {
 		return nil, err
 	}
+	c.Task.AddDataFile(buffer.FileName)
+
+	if outputFormat != OutputFormatSQL {
+		return buffer, nil
+	}
 
-	fmt.Fprintf(buffer, "SET NAMES utf8;\n")
-	fmt.Fprintf(buffer, "SET GLOBAL MAX_ALLOWED_PACKET=1073741824;\n")
+	// The workers read the rows as utf8mb4 and the TIMESTAMP columns in UTC.
+	fmt.Fprintf(buffer, "SET NAMES utf8mb4;\n")
 	fmt.Fprintf(buffer, "SET TIME_ZONE='+00:00';\n")
 	fmt.Fprintf(buffer, "SET UNIQUE_CHECKS=0;\n")
 	fmt.Fprintf(buffer, "SET FOREIGN_KEY_CHECKS=0;\n")
@@ -123,27 +297,54 @@ func NewChunkBuffer(c *DataChunk, workerId int) (*Buffer, error) {
 func NewTableDefinitionBuffer(t *Task) (*Buffer, error) {
 
 	bufferOptions := t.TaskManager.GetBufferOptions()
-	bufferOptions.Path = fmt.Sprintf("%s/%s-definition.sql", t.TaskManager.DestinationDir, t.Table.GetUnescapedFullName())
+	bufferOptions.Name = fmt.Sprintf("%s-definition.sql", t.Table.GetUnescapedFullName())
 
 	return NewBuffer(bufferOptions)
 
 }
 
-func NewMasterDataBuffer(t *TaskManager) (*Buffer, error) {
-	filename := fmt.Sprintf("%s/master-data.sql", t.DestinationDir)
+// NewLoadDataBuffer creates the buffer for the LOAD DATA statements of a
+// table dumped in a delimited format.
+func NewLoadDataBuffer(t *Task) (*Buffer, error) {
+
+	bufferOptions := t.TaskManager.GetBufferOptions()
+	bufferOptions.Name = fmt.Sprintf("%s-load.sql", t.Table.GetUnescapedFullName())
+
+	return NewBuffer(bufferOptions)
 
+}
+
+// NewSchemaObjectsBuffer creates the buffer for the views, the triggers, the
+// routines or the events of a schema, like "mydb-views.sql".
+func NewSchemaObjectsBuffer(tm *TaskManager, schema string, fileType string) (*Buffer, error) {
+
+	bufferOptions := tm.GetBufferOptions()
+	bufferOptions.Name = fmt.Sprintf("%s-%s.sql", schema, fileType)
+
+	return NewBuffer(bufferOptions)
+
+}
+
+func NewMasterDataBuffer(t *TaskManager) (*Buffer, error) {
 	bufferOptions := t.GetBufferOptions()
-	bufferOptions.Path = filename
+	bufferOptions.Name = "master-data.sql"
 
 	return NewBuffer(bufferOptions)
 
 }
 
 func NewSlaveDataBuffer(t *TaskManager) (*Buffer, error) {
-	filename := fmt.Sprintf("%s/slave-data.sql", t.DestinationDir)
+	bufferOptions := t.GetBufferOptions()
+	bufferOptions.Name = "slave-data.sql"
+
+	return NewBuffer(bufferOptions)
+
+}
 
+// NewGrantsBuffer creates the buffer for the accounts and their privileges.
+func NewGrantsBuffer(t *TaskManager) (*Buffer, error) {
 	bufferOptions := t.GetBufferOptions()
-	bufferOptions.Path = filename
+	bufferOptions.Name = GrantsFile
 
 	return NewBuffer(bufferOptions)
 
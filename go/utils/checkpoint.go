@@ -0,0 +1,374 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ChaosHour/go-dump/go/log"
+)
+
+// CheckpointFile is the log of the progress of a dump, used to resume it.
+// It's removed when the dump finishes.
+const CheckpointFile = "checkpoint.jsonl"
+
+// DefaultChunksPerFile is the default number of chunks of a data file, so
+// the files are closed, and kept by a resumed dump, while the dump runs.
+const DefaultChunksPerFile = 100
+
+// Events of the checkpoint. Each line of the checkpoint is one event.
+const (
+	CheckpointRun          = "run"           // a run of the dump starts
+	CheckpointTransactions = "transactions"  // the transactions of the workers are open
+	CheckpointChunkPlanned = "chunk-planned" // a chunk is created
+	CheckpointTablePlanned = "table-planned" // all the chunks of a table are created
+	CheckpointChunkDone    = "chunk-done"    // a chunk is written in a data file
+	CheckpointFileClosed   = "file-closed"   // a data file is complete
+	CheckpointStopped      = "stopped"       // a run stops before the end, with the error
+)
+
+// CheckpointEvent is a line of the checkpoint.
+type CheckpointEvent struct {
+	Event        string           `json:"event"`
+	Time         time.Time        `json:"time"`
+	Schema       string           `json:"schema,omitempty"`
+	Table        string           `json:"table,omitempty"`
+	Chunk        *ChunkRange      `json:"chunk,omitempty"`
+	Chunks       uint64           `json:"chunks,omitempty"`
+	FileName     string           `json:"file_name,omitempty"`
+	File         *DumpFile        `json:"file,omitempty"`
+	Options      *ManifestOptions `json:"options,omitempty"`
+	Encryption   *EncryptionInfo  `json:"encryption,omitempty"`
+	MasterStatus *MasterStatus    `json:"master_status,omitempty"`
+	Error        string           `json:"error,omitempty"`
+}
+
+// Checkpoint appends the events of a dump to the checkpoint file. Each event
+// is written with a single write, so an interrupted dump leaves at most an
+// incomplete last line.
+type Checkpoint struct {
+	fileName string
+	file     *os.File
+	mutex    sync.Mutex
+}
+
+// NewCheckpoint creates the checkpoint in the directory. When resuming, the
+// events are appended to the checkpoint of the interrupted dump, after the
+// incomplete last line if there is one.
+func NewCheckpoint(dir string, resume bool) (*Checkpoint, error) {
+	fileName := filepath.Join(dir, CheckpointFile)
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if resume {
+		flags = os.O_CREATE | os.O_RDWR | os.O_APPEND
+	}
+	file, err := os.OpenFile(fileName, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if resume {
+		if err := endLine(file); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return &Checkpoint{fileName: fileName, file: file}, nil
+}
+
+// endLine adds a new line at the end of the file if it's missing.
+func endLine(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, info.Size()-1); err != nil {
+		return err
+	}
+	if last[0] != '\n' {
+		_, err = file.Write([]byte{'\n'})
+	}
+	return err
+}
+
+// Write appends an event to the checkpoint. A checkpoint that can't be
+// written doesn't stop the dump.
+func (c *Checkpoint) Write(event *CheckpointEvent) {
+	if c == nil {
+		return
+	}
+	event.Time = time.Now()
+	line, err := json.Marshal(event)
+	if err != nil {
+		log.Warningf("Error writing the checkpoint: %s", err.Error())
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, err := c.file.Write(append(line, '\n')); err != nil {
+		log.Warningf("Error writing the checkpoint: %s", err.Error())
+	}
+}
+
+// Close closes the checkpoint of a dump that stops before the end, so it can
+// be resumed.
+func (c *Checkpoint) Close() error {
+	if c == nil {
+		return nil
+	}
+	return c.file.Close()
+}
+
+// Remove deletes the checkpoint once the dump is complete.
+func (c *Checkpoint) Remove() error {
+	if c == nil {
+		return nil
+	}
+	c.file.Close()
+	return os.Remove(c.fileName)
+}
+
+// DumpRun is a run of a dump that was interrupted.
+type DumpRun struct {
+	StartTime    time.Time     `json:"start_time"`
+	MasterStatus *MasterStatus `json:"master_status,omitempty"`
+	Error        string        `json:"error,omitempty"` // why the run stopped, if it didn't crash
+}
+
+// CheckpointTable is the progress of a table in the checkpoint.
+type CheckpointTable struct {
+	Schema  string
+	Table   string
+	Planned bool                   // all the chunks were created
+	Chunks  map[uint64]*ChunkRange // planned chunks by sequence
+	Done    map[uint64]*CheckpointEvent
+	Files   map[string]*DumpFile // closed data files
+}
+
+// GetPendingChunks return the planned chunks that are not in a closed file,
+// sorted by sequence.
+func (t *CheckpointTable) GetPendingChunks() []*ChunkRange {
+	var pending []*ChunkRange
+	for sequence, chunk := range t.Chunks {
+		if done, ok := t.Done[sequence]; ok && t.Files[done.FileName] != nil {
+			continue
+		}
+		pending = append(pending, chunk)
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Sequence < pending[j].Sequence })
+	return pending
+}
+
+// GetCompletedChunks return the chunks in a closed file, with the rows
+// dumped, sorted by sequence.
+func (t *CheckpointTable) GetCompletedChunks() []*ChunkRange {
+	var completed []*ChunkRange
+	for sequence, done := range t.Done {
+		if _, ok := t.Chunks[sequence]; ok && t.Files[done.FileName] != nil {
+			completed = append(completed, done.Chunk)
+		}
+	}
+	sort.Slice(completed, func(i, j int) bool { return completed[i].Sequence < completed[j].Sequence })
+	return completed
+}
+
+// CheckpointState is the progress of an interrupted dump.
+type CheckpointState struct {
+	Options    *ManifestOptions
+	Encryption *EncryptionInfo
+	Runs       []*DumpRun
+	Tables     map[string]*CheckpointTable // by schema.table
+}
+
+func (s *CheckpointState) getTable(schema string, table string) *CheckpointTable {
+	name := schema + "." + table
+	if _, ok := s.Tables[name]; !ok {
+		s.Tables[name] = &CheckpointTable{Schema: schema, Table: table, Chunks: map[uint64]*ChunkRange{},
+			Done: map[uint64]*CheckpointEvent{}, Files: map[string]*DumpFile{}}
+	}
+	return s.Tables[name]
+}
+
+// ReadCheckpoint reads the checkpoint of an interrupted dump. The tables
+// without all the chunks created are discarded when a new run starts,
+// because the new run creates their chunks again.
+func ReadCheckpoint(dir string) (*CheckpointState, error) {
+	file, err := os.Open(filepath.Join(dir, CheckpointFile))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	state := &CheckpointState{Tables: map[string]*CheckpointTable{}}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		event := new(CheckpointEvent)
+		if err := json.Unmarshal(scanner.Bytes(), event); err != nil {
+			log.Warningf("Ignoring the line %d of the checkpoint: %s", line, err.Error())
+			continue
+		}
+		switch event.Event {
+		case CheckpointRun:
+			if state.Options == nil {
+				state.Options = event.Options
+				state.Encryption = event.Encryption
+			}
+			state.Runs = append(state.Runs, &DumpRun{StartTime: event.Time})
+			for name, table := range state.Tables {
+				if !table.Planned {
+					delete(state.Tables, name)
+				}
+			}
+		case CheckpointTransactions:
+			if len(state.Runs) > 0 {
+				state.Runs[len(state.Runs)-1].MasterStatus = event.MasterStatus
+			}
+		case CheckpointChunkPlanned:
+			if event.Chunk != nil {
+				state.getTable(event.Schema, event.Table).Chunks[event.Chunk.Sequence] = event.Chunk
+			}
+		case CheckpointTablePlanned:
+			state.getTable(event.Schema, event.Table).Planned = true
+		case CheckpointChunkDone:
+			if event.Chunk != nil {
+				state.getTable(event.Schema, event.Table).Done[event.Chunk.Sequence] = event
+			}
+		case CheckpointFileClosed:
+			if event.File != nil {
+				state.getTable(event.Schema, event.Table).Files[event.File.Name] = event.File
+			}
+		case CheckpointStopped:
+			if len(state.Runs) > 0 {
+				state.Runs[len(state.Runs)-1].Error = event.Error
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if state.Options == nil {
+		return nil, fmt.Errorf("the checkpoint doesn't have the options of the dump")
+	}
+	return state, nil
+}
+
+// CheckOptions return an error if the options of the dump are not the same
+// as the options of the interrupted dump, so the files can be mixed.
+func (s *CheckpointState) CheckOptions(do *DumpOptions) error {
+	previous := s.Options
+	options := newManifestOptions(do)
+	checks := []struct {
+		name            string
+		previous, value interface{}
+	}{
+		{"--output-format", previous.OutputFormat, options.OutputFormat},
+		{"--compress", previous.Compress, options.Compress},
+		{"--compress-algorithm", previous.CompressAlgorithm, options.CompressAlgorithm},
+		{"--encrypt", previous.Encrypt, options.Encrypt},
+		{"--row-checksum", previous.RowChecksum, options.RowChecksum},
+		{"--no-create-info", previous.NoCreateInfo, options.NoCreateInfo},
+		{"--csv-delimiter", previous.CSVDelimiter, options.CSVDelimiter},
+		{"--csv-quote", previous.CSVQuote, options.CSVQuote},
+		{"--csv-null", previous.CSVNull, options.CSVNull},
+		{"--where", previous.Where, options.Where},
+		{"--where", fmt.Sprint(previous.WhereConditions), fmt.Sprint(options.WhereConditions)},
+	}
+	for _, check := range checks {
+		if check.previous != check.value {
+			return fmt.Errorf("the option %s is %v and the interrupted dump used %v", check.name, check.value,
+				check.previous)
+		}
+	}
+	return nil
+}
+
+// dataFileRegexp matches the data files of a table, like
+// "sakila.city-thread0-2.sql.gz". The group is the part of the file.
+func dataFileRegexp(table *Table, extension string) *regexp.Regexp {
+	return regexp.MustCompile(`^` + regexp.QuoteMeta(table.GetUnescapedFullName()) +
+		`(?:-thread[0-9]+(?:-([0-9]+))?)?\.` + regexp.QuoteMeta(extension) + `(?:\.|$)`)
+}
+
+// ResumeTasks prepares the tasks to resume an interrupted dump. The tables
+// with all their chunks created only dump the chunks that are not in a
+// closed file, the others start again. The data files that were not closed
+// are removed.
+func (tm *TaskManager) ResumeTasks(state *CheckpointState) error {
+	entries, err := os.ReadDir(tm.DestinationDir)
+	if err != nil {
+		return err
+	}
+	extension := GetOutputFormatExtension(tm.DumpOptions.OutputFormat)
+
+	tm.interruptedRuns = state.Runs
+	var chunksDone, chunksKept int
+	for _, task := range tm.tasksPool {
+		table, ok := state.Tables[task.Table.GetUnescapedFullName()]
+		if ok {
+			chunksDone += len(table.Done)
+		}
+		if ok && table.Planned {
+			task.resume(table)
+			chunksKept += len(table.GetCompletedChunks())
+		}
+
+		fileRegexp := dataFileRegexp(task.Table, extension)
+		for _, entry := range entries {
+			match := fileRegexp.FindStringSubmatch(entry.Name())
+			if match == nil {
+				continue
+			}
+			if table != nil && table.Planned && table.Files[entry.Name()] != nil {
+				if part, err := strconv.Atoi(match[1]); err == nil && part > task.filePart {
+					task.filePart = part
+				}
+				continue
+			}
+			log.Debugf("Removing the incomplete file %s", entry.Name())
+			if err := os.Remove(filepath.Join(tm.DestinationDir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	if chunksDone > 0 && chunksKept == 0 {
+		log.Warningf("None of the %d chunks dumped by the interrupted dump are in a closed file, all the data is "+
+			"dumped again. Use --chunks-per-file to close the files while the dump runs", chunksDone)
+	}
+	return nil
+}
+
+// resume restores the chunks of the table that are complete and keeps the
+// chunks to dump.
+func (t *Task) resume(table *CheckpointTable) {
+	completed := table.GetCompletedChunks()
+	t.resumed = true
+	t.resumeChunks = table.GetPendingChunks()
+	t.TotalChunks = uint64(len(table.Chunks))
+	t.chunksDone = uint64(len(completed))
+	for _, chunk := range completed {
+		t.AddRows(chunk.Rows)
+		if chunk.RowChecksum != "" {
+			checksum, _ := strconv.ParseUint(chunk.RowChecksum, 16, 64)
+			t.AddRowChecksum(checksum)
+		}
+		t.AddChunkRange(chunk)
+	}
+	names := make([]string, 0, len(table.Files))
+	for name := range table.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		t.AddDataFile(name)
+		t.files = append(t.files, table.Files[name])
+	}
+	log.Infof("Resuming %s: %d chunks done and %d chunks to dump", t.Table.GetUnescapedFullName(),
+		len(completed), len(t.resumeChunks))
+}
@@ -0,0 +1,155 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestCheckpoint(t *testing.T, dir string, options *DumpOptions) {
+	checkpoint, err := NewCheckpoint(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := []*ChunkRange{
+		{Sequence: 0, Min: ChunkKey{int64(1)}, Max: ChunkKey{int64(101)}},
+		{Sequence: 1, Min: ChunkKey{int64(101)}, Max: ChunkKey{int64(201)}},
+		{Sequence: 2, Min: ChunkKey{int64(201)}, Last: true},
+	}
+	events := []*CheckpointEvent{
+		{Event: CheckpointRun, Options: newManifestOptions(options)},
+		{Event: CheckpointTransactions, MasterStatus: &MasterStatus{File: "binlog.000002", Position: 157}},
+		{Event: CheckpointChunkPlanned, Schema: "sakila", Table: "city", Chunk: chunks[0]},
+		{Event: CheckpointChunkPlanned, Schema: "sakila", Table: "city", Chunk: chunks[1]},
+		{Event: CheckpointChunkPlanned, Schema: "sakila", Table: "city", Chunk: chunks[2]},
+		{Event: CheckpointTablePlanned, Schema: "sakila", Table: "city"},
+		{Event: CheckpointChunkPlanned, Schema: "sakila", Table: "actor", Chunk: chunks[0]},
+		{Event: CheckpointChunkDone, Schema: "sakila", Table: "city", FileName: "sakila.city-thread0.sql",
+			Chunk: &ChunkRange{Sequence: 0, Rows: 100, RowChecksum: FormatRowChecksum(10)}},
+		{Event: CheckpointChunkDone, Schema: "sakila", Table: "city", FileName: "sakila.city-thread1.sql",
+			Chunk: &ChunkRange{Sequence: 1, Rows: 100, RowChecksum: FormatRowChecksum(20)}},
+		{Event: CheckpointFileClosed, Schema: "sakila", Table: "city",
+			File: &DumpFile{Name: "sakila.city-thread0.sql", Type: RestoreFileData}},
+	}
+	for _, event := range events {
+		checkpoint.Write(event)
+	}
+	checkpoint.file.Close()
+}
+
+func TestReadCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	options := getDumpOptions()
+	options.RowChecksum = true
+	writeTestCheckpoint(t, dir, options)
+
+	// An interrupted write leaves an incomplete line.
+	file, err := os.OpenFile(filepath.Join(dir, CheckpointFile), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte(`{"event":"chunk-do`))
+	file.Close()
+
+	state, err := ReadCheckpoint(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Runs) != 1 || state.Runs[0].MasterStatus == nil || state.Runs[0].MasterStatus.Position != 157 {
+		t.Fatalf("Unexpected runs %+v", state.Runs)
+	}
+	city := state.Tables["sakila.city"]
+	if city == nil || !city.Planned || len(city.Chunks) != 3 {
+		t.Fatalf("Unexpected table %+v", city)
+	}
+	// The chunk 1 is done but its file was not closed.
+	if pending := city.GetPendingChunks(); len(pending) != 2 || pending[0].Sequence != 1 || pending[1].Sequence != 2 {
+		t.Fatalf("Unexpected pending chunks %+v", pending)
+	}
+	if completed := city.GetCompletedChunks(); len(completed) != 1 || completed[0].Rows != 100 {
+		t.Fatalf("Unexpected completed chunks %+v", completed)
+	}
+	if actor := state.Tables["sakila.actor"]; actor == nil || actor.Planned {
+		t.Fatalf("Unexpected table %+v", actor)
+	}
+
+	if err := state.CheckOptions(options); err != nil {
+		t.Fatal(err)
+	}
+	options.OutputFormat = OutputFormatCSV
+	if err := state.CheckOptions(options); err == nil {
+		t.Fatal("Expected an error with a different output format")
+	}
+
+	// A new run discards the tables without all the chunks created. The
+	// first run stopped with a signal.
+	checkpoint, err := NewCheckpoint(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoint.Write(&CheckpointEvent{Event: CheckpointStopped, Error: "the dump was interrupted: got the signal interrupt"})
+	checkpoint.Write(&CheckpointEvent{Event: CheckpointRun, Options: newManifestOptions(options)})
+	checkpoint.file.Close()
+	state, err = ReadCheckpoint(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Runs) != 2 || state.Tables["sakila.actor"] != nil || state.Tables["sakila.city"] == nil {
+		t.Fatalf("Unexpected state %+v", state)
+	}
+	if state.Runs[0].Error != "the dump was interrupted: got the signal interrupt" || state.Runs[1].Error != "" {
+		t.Fatalf("Unexpected errors of the runs %q %q", state.Runs[0].Error, state.Runs[1].Error)
+	}
+	if state.Options.OutputFormat != OutputFormatSQL {
+		t.Fatalf("The options are not the ones of the first run %+v", state.Options)
+	}
+}
+
+func TestResumeTasks(t *testing.T) {
+	dir := t.TempDir()
+	options := getDumpOptions()
+	options.DestinationDir = dir
+	writeTestCheckpoint(t, dir, options)
+	for _, name := range []string{"sakila.city-thread0.sql", "sakila.city-thread1.sql", "sakila.city-thread0-3.sql",
+		"sakila.actor-thread0.sql", "sakila.city-definition.sql", "sakila.cityx-thread0.sql"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	state, err := ReadCheckpoint(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tm := &TaskManager{DumpOptions: options, DestinationDir: dir}
+	city := &Task{Table: &Table{schema: "sakila", name: "city"}, TaskManager: tm}
+	actor := &Task{Table: &Table{schema: "sakila", name: "actor"}, TaskManager: tm}
+	tm.AddTask(city)
+	tm.AddTask(actor)
+	if err := tm.ResumeTasks(state); err != nil {
+		t.Fatal(err)
+	}
+
+	if !city.resumed || city.TotalChunks != 3 || len(city.resumeChunks) != 2 || city.GetRows() != 100 ||
+		city.GetRowChecksum() != 10 || len(city.GetFiles()) != 1 {
+		t.Fatalf("Unexpected task %+v", city)
+	}
+	if actor.resumed {
+		t.Fatal("The table actor should be dumped again")
+	}
+	for name, exists := range map[string]bool{
+		"sakila.city-thread0.sql":    true,
+		"sakila.city-thread1.sql":    false,
+		"sakila.city-thread0-3.sql":  false,
+		"sakila.actor-thread0.sql":   false,
+		"sakila.city-definition.sql": true,
+		"sakila.cityx-thread0.sql":   true,
+	} {
+		if _, err := os.Stat(filepath.Join(dir, name)); (err == nil) != exists {
+			t.Fatalf("The file %s should exist: %v", name, exists)
+		}
+	}
+	if part := city.NextFilePart(); part != 1 {
+		t.Fatalf("Unexpected file part %d", part)
+	}
+}
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc64"
+	"strconv"
+	"time"
+)
+
+var rowChecksumTable = crc64.MakeTable(crc64.ECMA)
+
+// rowChecksum return the CRC-64 of a row as returned by the driver. Each
+// value is encoded as its text representation prefixed with its length, so
+// NULL and an empty string are different and the columns can't be mixed.
+func rowChecksum(values []interface{}) uint64 {
+	var crc uint64
+	var length [binary.MaxVarintLen64 + 1]byte
+	for _, value := range values {
+		if value == nil {
+			crc = crc64.Update(crc, rowChecksumTable, []byte{0})
+			continue
+		}
+		text := rowChecksumValue(value)
+		length[0] = 1
+		n := binary.PutUvarint(length[1:], uint64(len(text)))
+		crc = crc64.Update(crc, rowChecksumTable, length[:n+1])
+		crc = crc64.Update(crc, rowChecksumTable, text)
+	}
+	return crc
+}
+
+func rowChecksumValue(value interface{}) []byte {
+	switch v := value.(type) {
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	case int64:
+		return strconv.AppendInt(nil, v, 10)
+	case uint64:
+		return strconv.AppendUint(nil, v, 10)
+	case float32:
+		return strconv.AppendFloat(nil, float64(v), 'g', -1, 32)
+	case float64:
+		return strconv.AppendFloat(nil, v, 'g', -1, 64)
+	case time.Time:
+		return []byte(v.UTC().Format(time.RFC3339Nano))
+	}
+	return []byte(fmt.Sprint(value))
+}
+
+// FormatRowChecksum return the checksum of the rows of a table as it is
+// written in the manifest.
+func FormatRowChecksum(checksum uint64) string {
+	return fmt.Sprintf("%016x", checksum)
+}
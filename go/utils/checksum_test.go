@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRowChecksum(t *testing.T) {
+	date := time.Date(2006, 2, 15, 4, 45, 25, 0, time.UTC)
+	row := []interface{}{int64(1), []byte("PENELOPE"), nil, 1.5, date}
+
+	// The values of the text protocol have the same checksum.
+	text := []interface{}{[]byte("1"), "PENELOPE", nil, []byte("1.5"), []byte("2006-02-15T04:45:25Z")}
+	if rowChecksum(row) != rowChecksum(text) {
+		t.Fatalf("Unexpected different checksums %x %x", rowChecksum(row), rowChecksum(text))
+	}
+
+	different := [][]interface{}{
+		{int64(1), []byte("PENELOPE"), []byte(""), 1.5, date},
+		{int64(1), []byte("PENELOP"), []byte("E"), 1.5, date},
+		{int64(2), []byte("PENELOPE"), nil, 1.5, date},
+		{int64(1), []byte("PENELOPE"), nil, 1.5},
+	}
+	for _, other := range different {
+		if rowChecksum(row) == rowChecksum(other) {
+			t.Fatalf("Unexpected same checksum for %v", other)
+		}
+	}
+
+	if got := FormatRowChecksum(0xabc); got != "0000000000000abc" {
+		t.Fatalf("Unexpected format %s", got)
+	}
+}
@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+const (
+	CompressAlgorithmGzip = "gzip"
+	CompressAlgorithmZstd = "zstd"
+	CompressAlgorithmLz4  = "lz4"
+)
+
+// compressExtensions are the suffixes of the compressed files.
+var compressExtensions = map[string]string{
+	CompressAlgorithmGzip: ".gz",
+	CompressAlgorithmZstd: ".zst",
+	CompressAlgorithmLz4:  ".lz4",
+}
+
+// IsValidCompressAlgorithm return true if the algorithm is supported.
+func IsValidCompressAlgorithm(algorithm string) bool {
+	_, ok := compressExtensions[algorithm]
+	return ok
+}
+
+// GetCompressExtension return the suffix of the files compressed with the
+// algorithm, for example ".zst".
+func GetCompressExtension(algorithm string) string {
+	return compressExtensions[algorithm]
+}
+
+// GetCompressAlgorithm return the algorithm used to compress a file from the
+// suffix of its name. It's empty if the file is not compressed.
+func GetCompressAlgorithm(fileName string) string {
+	for algorithm, extension := range compressExtensions {
+		if strings.HasSuffix(fileName, extension) {
+			return algorithm
+		}
+	}
+	return ""
+}
+
+// TrimCompressExtension return the name of the file without the compression
+// suffix.
+func TrimCompressExtension(fileName string) string {
+	return strings.TrimSuffix(fileName, GetCompressExtension(GetCompressAlgorithm(fileName)))
+}
+
+// getLz4Level return the lz4 level for a level from 1 to 9. The level 1 is
+// the fast mode, the others are the high compression levels.
+func getLz4Level(level int) lz4.CompressionLevel {
+	levels := []lz4.CompressionLevel{lz4.Fast, lz4.Level2, lz4.Level3, lz4.Level4,
+		lz4.Level5, lz4.Level6, lz4.Level7, lz4.Level8, lz4.Level9}
+	if level < 1 {
+		level = 1
+	} else if level > len(levels) {
+		level = len(levels)
+	}
+	return levels[level-1]
+}
+
+// newCompressWriter return a writer that compresses the data into w. The
+// level goes from 1 (best speed) to 9 (best compression) for all the
+// algorithms.
+func newCompressWriter(w io.Writer, algorithm string, level int) (io.WriteCloser, error) {
+	switch algorithm {
+	case CompressAlgorithmGzip:
+		return gzip.NewWriterLevel(w, level)
+	case CompressAlgorithmZstd:
+		// Each worker writes its own file, so the encoder doesn't need more
+		// goroutines.
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)),
+			zstd.WithEncoderConcurrency(1))
+	case CompressAlgorithmLz4:
+		writer := lz4.NewWriter(w)
+		if err := writer.Apply(lz4.CompressionLevelOption(getLz4Level(level))); err != nil {
+			return nil, err
+		}
+		return writer, nil
+	}
+	return nil, fmt.Errorf("unknown compression algorithm %s", algorithm)
+}
+
+// zstdReader closes the zstd decoder, which doesn't return an error.
+type zstdReader struct {
+	*zstd.Decoder
+}
+
+func (z zstdReader) Close() error {
+	z.Decoder.Close()
+	return nil
+}
+
+// newDecompressReader return a reader that decompresses the data of r.
+func newDecompressReader(r io.Reader, algorithm string) (io.ReadCloser, error) {
+	switch algorithm {
+	case CompressAlgorithmGzip:
+		return gzip.NewReader(r)
+	case CompressAlgorithmZstd:
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return zstdReader{decoder}, nil
+	case CompressAlgorithmLz4:
+		return io.NopCloser(lz4.NewReader(r)), nil
+	}
+	return nil, fmt.Errorf("unknown compression algorithm %s", algorithm)
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGetCompressAlgorithm(t *testing.T) {
+	tests := []struct {
+		fileName, algorithm, name string
+	}{
+		{"sakila.city-thread0.sql.gz", CompressAlgorithmGzip, "sakila.city-thread0.sql"},
+		{"sakila.city-thread0.sql.zst", CompressAlgorithmZstd, "sakila.city-thread0.sql"},
+		{"sakila.city-thread0.csv.lz4", CompressAlgorithmLz4, "sakila.city-thread0.csv"},
+		{"sakila.city-thread0.sql", "", "sakila.city-thread0.sql"},
+	}
+	for _, test := range tests {
+		if algorithm := GetCompressAlgorithm(test.fileName); algorithm != test.algorithm {
+			t.Errorf("File %s: got algorithm %q and we expect %q", test.fileName, algorithm, test.algorithm)
+		}
+		if name := TrimCompressExtension(test.fileName); name != test.name {
+			t.Errorf("File %s: got name %q and we expect %q", test.fileName, name, test.name)
+		}
+	}
+}
+
+func TestCompressAlgorithms(t *testing.T) {
+	dir := t.TempDir()
+	sink := NewFileSink(dir)
+	data := strings.Repeat("INSERT INTO `city` VALUES (1,'A Corua (La Corua)',87);\n", 1000)
+
+	for _, algorithm := range []string{CompressAlgorithmGzip, CompressAlgorithmZstd, CompressAlgorithmLz4} {
+		for _, level := range []int{1, 9} {
+			buffer, err := NewSinkBuffer(sink, "sakila.city-thread0.sql", algorithm, level, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if buffer.Type != algorithm || buffer.FileName != "sakila.city-thread0.sql"+GetCompressExtension(algorithm) {
+				t.Fatalf("Unexpected buffer %s of type %s", buffer.FileName, buffer.Type)
+			}
+			buffer.Write([]byte(data))
+			if err := buffer.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			fileName := filepath.Join(dir, buffer.FileName)
+			info, err := os.Stat(fileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() >= int64(len(data)) {
+				t.Errorf("The %s file with level %d is not compressed: %d bytes", algorithm, level, info.Size())
+			}
+
+			reader, err := NewFileReader(fileName, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(reader)
+			reader.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, []byte(data)) {
+				t.Fatalf("The %s file with level %d doesn't have the same data", algorithm, level)
+			}
+		}
+	}
+
+	if _, err := NewSinkBuffer(sink, "sakila.city-thread0.sql", "bzip2", 1, nil); err == nil {
+		t.Fatal("Expected an error for an unknown algorithm")
+	}
+}
@@ -1,32 +1,41 @@
 package utils
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"io"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
-	"github.com/outbrain/golib/log"
+	"github.com/ChaosHour/go-dump/go/log"
 )
 
 // DataChunk is the structure to handle the information of each chunk
 type DataChunk struct {
-	Min           int64
-	Max           int64
+	Min           []interface{}
+	Max           []interface{}
 	Sequence      uint64
 	Task          *Task
 	IsSingleChunk bool
+	IsFirstChunk  bool // read without the lower bound, only set by verify
 	IsLastChunk   bool
 }
 
 // GetWhereSQL return the where condition for a chunk
 func (dc *DataChunk) GetWhereSQL() string {
 	baseWhere := ""
-	if dc.IsSingleChunk {
+	if dc.IsSingleChunk || (dc.IsFirstChunk && dc.IsLastChunk) {
 		baseWhere = ""
 	} else if dc.IsLastChunk {
-		baseWhere = fmt.Sprintf(" WHERE %s >= ?", dc.Task.Table.GetPrimaryOrUniqueKey())
+		baseWhere = fmt.Sprintf(" WHERE %s", dc.Task.Table.GetKeyConditionSQL(">="))
+	} else if dc.IsFirstChunk {
+		baseWhere = fmt.Sprintf(" WHERE %s", dc.Task.Table.GetKeyConditionSQL("<"))
 	} else {
-		baseWhere = fmt.Sprintf(" WHERE %s BETWEEN ? AND ?", dc.Task.Table.GetPrimaryOrUniqueKey())
+		baseWhere = fmt.Sprintf(" WHERE %s AND %s",
+			dc.Task.Table.GetKeyConditionSQL(">="), dc.Task.Table.GetKeyConditionSQL("<"))
 	}
 
 	// Append custom WHERE if provided
@@ -52,12 +61,84 @@ func (dc *DataChunk) GetWhereSQL() string {
 	return baseWhere
 }
 
+// GetChunkRange return the range of the chunk with the rows dumped from it.
+// The row checksum is only set with the --row-checksum option.
+func (dc *DataChunk) GetChunkRange(rows uint64, checksum uint64) *ChunkRange {
+	chunkRange := &ChunkRange{
+		Sequence: dc.Sequence,
+		Single:   dc.IsSingleChunk,
+		Last:     dc.IsLastChunk,
+		Rows:     rows,
+	}
+	if !dc.IsSingleChunk {
+		chunkRange.Min = dc.Min
+		if !dc.IsLastChunk {
+			chunkRange.Max = dc.Max
+		}
+	}
+	if dc.Task.TaskManager.DumpOptions.RowChecksum {
+		chunkRange.RowChecksum = FormatRowChecksum(checksum)
+	}
+	return chunkRange
+}
+
 func (dc *DataChunk) GetOrderBYSQL() string {
 	if dc.IsSingleChunk {
 		return ""
 	}
 
-	return fmt.Sprintf(" ORDER BY %s", dc.Task.Table.GetPrimaryOrUniqueKey())
+	return fmt.Sprintf(" ORDER BY %s", dc.Task.Table.GetEscapedKeyForChunks())
+}
+
+// GetQueryArgs return the values to bind to the placeholders of the query
+// returned by GetPrepareSQL.
+func (dc *DataChunk) GetQueryArgs() []interface{} {
+	var args []interface{}
+	if dc.IsSingleChunk {
+		return args
+	}
+	if !dc.IsFirstChunk {
+		args = append(args, dc.Min...)
+	}
+	if !dc.IsLastChunk {
+		args = append(args, dc.Max...)
+	}
+	return args
+}
+
+// formatKey return the values of a key as a string for the comments.
+// Binary values or values with control characters are written in hex.
+func formatKey(values []interface{}) string {
+	var s []string
+	for _, value := range values {
+		switch v := value.(type) {
+		case []byte:
+			if isPrintable(v) {
+				s = append(s, "'"+string(ParseString(v))+"'")
+			} else {
+				s = append(s, fmt.Sprintf("0x%X", v))
+			}
+		case time.Time:
+			s = append(s, "'"+v.Format("2006-01-02 15:04:05.999999")+"'")
+		default:
+			s = append(s, fmt.Sprintf("%v", v))
+		}
+	}
+	return "(" + strings.Join(s, ",") + ")"
+}
+
+// isPrintable return true if the value is valid UTF-8 without control
+// characters.
+func isPrintable(value []byte) bool {
+	if !utf8.Valid(value) {
+		return false
+	}
+	for _, r := range string(value) {
+		if unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
 }
 
 func (dc *DataChunk) GetPrepareSQL() string {
@@ -71,33 +152,35 @@ func (dc *DataChunk) GetSampleSQL() string {
 	return fmt.Sprintf("SELECT * FROM %s LIMIT 1", dc.Task.Table.GetFullName())
 }
 
-func (dc *DataChunk) Parse(stmt *sql.Stmt, buffer *Buffer) error {
+// Parse writes the rows of the chunk in the buffer and return the range of
+// the chunk with the rows dumped.
+func (dc *DataChunk) Parse(ctx context.Context, stmt *sql.Stmt, buffer *Buffer) (*ChunkRange, error) {
 
-	var rows *sql.Rows
-	var err error
 	if dc.IsSingleChunk {
 		log.Debugf("Is single chunk %s.", dc.Task.Table.GetFullName())
-		rows, err = stmt.Query()
-	} else {
-		if dc.IsLastChunk {
-			rows, err = stmt.Query(dc.Min)
-			log.Debugf("Last chunk %s.", dc.Task.Table.GetFullName())
-		} else {
-			rows, err = stmt.Query(dc.Min, dc.Max)
-		}
+	} else if dc.IsLastChunk {
+		log.Debugf("Last chunk %s.", dc.Task.Table.GetFullName())
 	}
+	start := time.Now()
+	rows, err := stmt.QueryContext(ctx, dc.GetQueryArgs()...)
+	dc.Task.TaskManager.metrics().observeQuery(time.Since(start))
 
 	if err != nil {
-		log.Fatalf("%s", err.Error())
+		return nil, err
 	}
 
 	tablename := dc.Task.Table.GetFullName()
 
-	if dc.IsSingleChunk {
-		fmt.Fprintf(buffer, "-- Single chunk on %s\n", tablename)
-	} else {
-		fmt.Fprintf(buffer, "-- Chunk %d - from %d to %d\n",
-			dc.Sequence, dc.Min, dc.Max)
+	if dc.Task.TaskManager.DumpOptions.OutputFormat == OutputFormatSQL {
+		if dc.IsSingleChunk {
+			fmt.Fprintf(buffer, "-- Single chunk on %s\n", tablename)
+		} else if dc.IsLastChunk {
+			fmt.Fprintf(buffer, "-- Chunk %d - from %s to the end\n",
+				dc.Sequence, formatKey(dc.Min))
+		} else {
+			fmt.Fprintf(buffer, "-- Chunk %d - from %s to %s\n",
+				dc.Sequence, formatKey(dc.Min), formatKey(dc.Max))
+		}
 	}
 
 	columns, _ := rows.ColumnTypes()
@@ -106,62 +189,112 @@ func (dc *DataChunk) Parse(stmt *sql.Stmt, buffer *Buffer) error {
 	for i := range buff {
 		buff[i] = &data[i]
 	}
-	firstRow := true
-
-	//var rowsNumber = uint64(0)
-	for rows.Next() {
 
-		/*
-			if rowsNumber > 0 && rowsNumber%dc.Task.OutputChunkSize == 0 {
-				fmt.Fprintf(buffer, ");\n\n")
-				firstRow = true
-			}
-		*/
+	writer, err := dc.NewRowWriter(buffer, columns)
+	if err != nil {
+		rows.Close()
+		return nil, err
+	}
 
-		if firstRow {
-			fmt.Fprintf(buffer, "INSERT INTO %s VALUES \n(", dc.Task.Table.GetName())
-		}
+	var count, checksum uint64
+	rowChecksums := dc.Task.TaskManager.DumpOptions.RowChecksum
+	for rows.Next() {
 		err = rows.Scan(buff...)
-
 		if err != nil {
-			panic(err.Error()) // proper error handling instead of panic in your app
+			rows.Close()
+			return nil, err
 		}
-		if !firstRow {
-			fmt.Fprintf(buffer, "),\n(")
-		} else {
-			firstRow = false
+		if rowChecksums {
+			checksum += rowChecksum(data)
 		}
 
-		max := len(data)
-		for i, d := range data {
-
-			switch d.(type) {
-			case []byte:
-				buffer.Write([]byte("'"))
-				buffer.Write(ParseString(d))
-				buffer.Write([]byte("'"))
-			case int64:
-				fmt.Fprintf(buffer, "%d", d)
-			case nil:
-				buffer.Write([]byte("NULL"))
-			case time.Time:
-				fmt.Fprintf(buffer, "%s", d)
-			case float64:
-				fmt.Fprintf(buffer, "%g", d)
-			default:
-				buffer.Write(d.([]byte))
-			}
-			if i != max-1 {
-				fmt.Fprintf(buffer, ",")
-			}
+		if err := writer.WriteRow(data); err != nil {
+			rows.Close()
+			return nil, err
 		}
+		count++
 	}
 	rows.Close()
-	fmt.Fprintf(buffer, ");\n")
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	chunkRange := dc.GetChunkRange(count, checksum)
+	dc.Task.AddRows(count)
+	dc.Task.AddRowChecksum(checksum)
+	dc.Task.AddChunkRange(chunkRange)
+	return chunkRange, nil
+}
+
+// insertWriter writes rows as multi-row INSERT statements. The current
+// statement is closed and a new one is started when it already has maxRows
+// rows or when the next row would make it longer than maxBytes. A value of 0
+// disables the limit. A row longer than maxBytes is written in a statement
+// on its own.
+type insertWriter struct {
+	w        io.Writer
+	header   string
+	maxRows  uint64
+	maxBytes uint64
+	rows     uint64
+	bytes    uint64
+}
+
+func newInsertWriter(w io.Writer, header string, maxRows uint64, maxBytes uint64) *insertWriter {
+	return &insertWriter{
+		w:        w,
+		header:   header,
+		maxRows:  maxRows,
+		maxBytes: maxBytes}
+}
+
+// WriteRow adds a row, already formatted as "(v1,v2,...)", to the current
+// statement.
+func (iw *insertWriter) WriteRow(row []byte) error {
+	if iw.rows > 0 {
+		// ",\n" before the row plus the ";" that closes the statement.
+		fullRows := iw.maxRows > 0 && iw.rows >= iw.maxRows
+		fullBytes := iw.maxBytes > 0 && iw.bytes+uint64(len(row))+3 > iw.maxBytes
+		if fullRows || fullBytes {
+			if err := iw.Close(); err != nil {
+				return err
+			}
+		}
+	}
 
+	var err error
+	if iw.rows == 0 {
+		_, err = io.WriteString(iw.w, iw.header)
+		iw.bytes = uint64(len(iw.header))
+	} else {
+		_, err = io.WriteString(iw.w, ",\n")
+		iw.bytes += 2
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := iw.w.Write(row); err != nil {
+		return err
+	}
+	iw.rows++
+	iw.bytes += uint64(len(row))
 	return nil
 }
 
+// Close ends the current statement, if any.
+func (iw *insertWriter) Close() error {
+	if iw.rows == 0 {
+		return nil
+	}
+	iw.rows = 0
+	iw.bytes = 0
+	_, err := io.WriteString(iw.w, ";\n")
+	return err
+}
+
 // Create a single chunk for a table, this is only when the table doesn't have
 // primary key and the flag --table-without-pk-option is "single-chunk"
 func NewSingleDataChunk(task *Task) DataChunk {
@@ -183,6 +316,19 @@ func NewDataChunk(task *Task) DataChunk {
 		IsLastChunk:   false}
 }
 
+// NewDataChunkFromRange creates a chunk from a range of the manifest or the
+// checkpoint.
+func NewDataChunkFromRange(task *Task, chunkRange *ChunkRange) DataChunk {
+
+	return DataChunk{
+		Min:           chunkRange.Min,
+		Max:           chunkRange.Max,
+		Sequence:      chunkRange.Sequence,
+		Task:          task,
+		IsSingleChunk: chunkRange.Single,
+		IsLastChunk:   chunkRange.Last}
+}
+
 func NewDataLastChunk(task *Task) DataChunk {
 
 	return DataChunk{
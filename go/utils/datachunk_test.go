@@ -1,6 +1,10 @@
 package utils
 
-import "testing"
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
 
 type ChunksTest struct {
 	task                 *Task
@@ -11,15 +15,20 @@ type ChunksTest struct {
 
 var chunksTests = []ChunksTest{
 
-	{task: &task1,
+	{task: task1,
 		expectSingleChunkSQL: "SELECT /*!40001 SQL_NO_CACHE */ * FROM `sakila`.`city`",
-		expectLastChunkSQL:   "SELECT /*!40001 SQL_NO_CACHE */ * FROM `sakila`.`city` WHERE city_id >= ? ORDER BY city_id",
-		expectChunkSQL:       "SELECT /*!40001 SQL_NO_CACHE */ * FROM `sakila`.`city` WHERE city_id BETWEEN ? AND ? ORDER BY city_id"},
+		expectLastChunkSQL:   "SELECT /*!40001 SQL_NO_CACHE */ * FROM `sakila`.`city` WHERE `city_id` >= ? ORDER BY `city_id`",
+		expectChunkSQL:       "SELECT /*!40001 SQL_NO_CACHE */ * FROM `sakila`.`city` WHERE `city_id` >= ? AND `city_id` < ? ORDER BY `city_id`"},
 
-	{task: &task2,
+	{task: task2,
 		expectSingleChunkSQL: "SELECT /*!40001 SQL_NO_CACHE */ * FROM `sakila`.`country`",
-		expectLastChunkSQL:   "SELECT /*!40001 SQL_NO_CACHE */ * FROM `sakila`.`country` WHERE country_id >= ? ORDER BY country_id",
-		expectChunkSQL:       "SELECT /*!40001 SQL_NO_CACHE */ * FROM `sakila`.`country` WHERE country_id BETWEEN ? AND ? ORDER BY country_id"},
+		expectLastChunkSQL:   "SELECT /*!40001 SQL_NO_CACHE */ * FROM `sakila`.`country` WHERE `country_id` >= ? ORDER BY `country_id`",
+		expectChunkSQL:       "SELECT /*!40001 SQL_NO_CACHE */ * FROM `sakila`.`country` WHERE `country_id` >= ? AND `country_id` < ? ORDER BY `country_id`"},
+
+	{task: task4,
+		expectSingleChunkSQL: "SELECT /*!40001 SQL_NO_CACHE */ * FROM `sakila`.`film_actor`",
+		expectLastChunkSQL:   "SELECT /*!40001 SQL_NO_CACHE */ * FROM `sakila`.`film_actor` WHERE (`actor_id`,`film_id`) >= (?,?) ORDER BY `actor_id`,`film_id`",
+		expectChunkSQL:       "SELECT /*!40001 SQL_NO_CACHE */ * FROM `sakila`.`film_actor` WHERE (`actor_id`,`film_id`) >= (?,?) AND (`actor_id`,`film_id`) < (?,?) ORDER BY `actor_id`,`film_id`"},
 }
 
 func TestNewSingleDataChunk(t *testing.T) {
@@ -48,3 +57,88 @@ func TestNewLastDataChunk(t *testing.T) {
 		}
 	}
 }
+
+func TestGetQueryArgs(t *testing.T) {
+	task := &Task{Table: table4, TaskManager: &taskManager}
+	task.chunkMin = []interface{}{int64(1), []byte("a")}
+	task.chunkMax = []interface{}{int64(3), []byte("b")}
+
+	chunk := NewDataChunk(task)
+	if args := chunk.GetQueryArgs(); fmt.Sprint(args) != "[1 [97] 3 [98]]" {
+		t.Fatalf("Got %v and expected [1 [97] 3 [98]]", args)
+	}
+
+	lastChunk := NewDataLastChunk(task)
+	if args := lastChunk.GetQueryArgs(); fmt.Sprint(args) != "[1 [97]]" {
+		t.Fatalf("Got %v and expected [1 [97]]", args)
+	}
+
+	firstChunk := NewDataChunk(task)
+	firstChunk.IsFirstChunk = true
+	if args := firstChunk.GetQueryArgs(); fmt.Sprint(args) != "[3 [98]]" {
+		t.Fatalf("Got %v and expected [3 [98]]", args)
+	}
+	if sql := firstChunk.GetPrepareSQL(); sql != "SELECT /*!40001 SQL_NO_CACHE */ * FROM `schema4`.`table4` "+
+		"WHERE (`pk1`,`pk2`) < (?,?) ORDER BY `pk1`,`pk2`" {
+		t.Fatalf("Unexpected query of the first chunk %s", sql)
+	}
+
+	singleChunk := NewSingleDataChunk(task)
+	if args := singleChunk.GetQueryArgs(); len(args) != 0 {
+		t.Fatalf("Got %v and expected no arguments", args)
+	}
+}
+
+func TestFormatKey(t *testing.T) {
+	tests := []struct {
+		values []interface{}
+		expect string
+	}{
+		{[]interface{}{int64(10)}, "(10)"},
+		{[]interface{}{[]byte("1b4e28ba-2fa1-11d2-883f-0016d3cca427")}, "('1b4e28ba-2fa1-11d2-883f-0016d3cca427')"},
+		{[]interface{}{[]byte("it's")}, "('it\\'s')"},
+		{[]interface{}{[]byte("a\nb")}, "(0x610A62)"},
+		{[]interface{}{[]byte{0x1b, 0x4e, 0x00, 0xff}}, "(0x1B4E00FF)"},
+		{[]interface{}{int64(1), []byte("2018-02-15 11:58:17")}, "(1,'2018-02-15 11:58:17')"},
+	}
+
+	for _, tt := range tests {
+		if key := formatKey(tt.values); key != tt.expect {
+			t.Errorf("Got %s and expected %s", key, tt.expect)
+		}
+	}
+}
+
+func TestInsertWriter(t *testing.T) {
+	tests := []struct {
+		maxRows  uint64
+		maxBytes uint64
+		expect   string
+	}{
+		{0, 0, "INSERT INTO `t` VALUES \n(1,'a'),\n(2,'bb'),\n(3,'c');\n"},
+		{2, 0, "INSERT INTO `t` VALUES \n(1,'a'),\n(2,'bb');\nINSERT INTO `t` VALUES \n(3,'c');\n"},
+		{0, 42, "INSERT INTO `t` VALUES \n(1,'a'),\n(2,'bb');\nINSERT INTO `t` VALUES \n(3,'c');\n"},
+		{0, 10, "INSERT INTO `t` VALUES \n(1,'a');\nINSERT INTO `t` VALUES \n(2,'bb');\nINSERT INTO `t` VALUES \n(3,'c');\n"},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		insert := newInsertWriter(&out, "INSERT INTO `t` VALUES \n", tt.maxRows, tt.maxBytes)
+		for _, row := range []string{"(1,'a')", "(2,'bb')", "(3,'c')"} {
+			if err := insert.WriteRow([]byte(row)); err != nil {
+				t.Fatalf("Unexpected error %s", err.Error())
+			}
+		}
+		if err := insert.Close(); err != nil {
+			t.Fatalf("Unexpected error %s", err.Error())
+		}
+		if out.String() != tt.expect {
+			t.Errorf("Got %q and expected %q", out.String(), tt.expect)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := newInsertWriter(&out, "INSERT INTO `t` VALUES \n", 0, 0).Close(); err != nil || out.Len() != 0 {
+		t.Errorf("Expected no statement for a chunk without rows and got %q", out.String())
+	}
+}
@@ -0,0 +1,351 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// EncryptExtension is the suffix of the encrypted files, it goes after
+	// the suffix of the compression.
+	EncryptExtension = ".enc"
+	// EncryptionAlgorithm is the cipher used to encrypt the files.
+	EncryptionAlgorithm = "AES-256-GCM"
+
+	encryptMagic       = "GDENC1"
+	encryptSegmentSize = 64 * 1024
+	encryptKeySize     = 32
+	encryptSaltSize    = 16
+
+	// Parameters of scrypt to derive a key from a passphrase.
+	scryptN = 32768
+	scryptR = 8
+	scryptP = 1
+)
+
+// EncryptionKey is the key used to encrypt the files of a dump.
+type EncryptionKey struct {
+	ID   string // hex of the first bytes of the SHA-256 of the key
+	Key  []byte
+	Salt []byte // salt of the passphrase, empty for the key files
+}
+
+func newEncryptionKey(key []byte, salt []byte) *EncryptionKey {
+	sum := sha256.Sum256(key)
+	return &EncryptionKey{ID: hex.EncodeToString(sum[:8]), Key: key, Salt: salt}
+}
+
+// EncryptionInfo is the information about the key of a dump, written in
+// the manifest. It doesn't contain the key.
+type EncryptionInfo struct {
+	Algorithm string `json:"algorithm"`
+	KeyID     string `json:"key_id"`
+	KeySource string `json:"key_source"`
+	KDF       string `json:"kdf,omitempty"`
+	Salt      []byte `json:"salt,omitempty"`
+}
+
+// GetInfo return the information about the key to record in the dump.
+func (k *EncryptionKey) GetInfo() *EncryptionInfo {
+	if len(k.Salt) == 0 {
+		return &EncryptionInfo{Algorithm: EncryptionAlgorithm, KeyID: k.ID, KeySource: "key-file"}
+	}
+	return &EncryptionInfo{Algorithm: EncryptionAlgorithm, KeyID: k.ID, KeySource: "passphrase",
+		KDF: fmt.Sprintf("scrypt N=%d r=%d p=%d", scryptN, scryptR, scryptP), Salt: k.Salt}
+}
+
+// ReadKeyFile reads a key file with 32 bytes, either raw or as 64 hex
+// characters.
+func ReadKeyFile(fileName string) ([]byte, error) {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) == encryptKeySize {
+		return content, nil
+	}
+	key, err := hex.DecodeString(string(bytes.TrimSpace(content)))
+	if err != nil || len(key) != encryptKeySize {
+		return nil, fmt.Errorf("the key file %s must have %d bytes or %d hex characters",
+			fileName, encryptKeySize, encryptKeySize*2)
+	}
+	return key, nil
+}
+
+// DerivePassphraseKey derives a key from a passphrase with scrypt.
+func DerivePassphraseKey(passphrase string, salt []byte) (*EncryptionKey, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, encryptKeySize)
+	if err != nil {
+		return nil, err
+	}
+	return newEncryptionKey(key, salt), nil
+}
+
+// EncryptionOptions are the options to get the key of a dump. Only one of
+// KeyFile and Passphrase should be set.
+type EncryptionOptions struct {
+	KeyFile    string
+	Passphrase string
+
+	keys      map[string]*EncryptionKey
+	keysMutex sync.Mutex
+}
+
+// NewKey return the key to encrypt a new dump. With a passphrase the key is
+// derived with a new random salt.
+func (o *EncryptionOptions) NewKey() (*EncryptionKey, error) {
+	if (o.KeyFile == "") == (o.Passphrase == "") {
+		return nil, errors.New("the encryption needs either a key file or a passphrase")
+	}
+	if o.KeyFile != "" {
+		key, err := ReadKeyFile(o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return newEncryptionKey(key, nil), nil
+	}
+	salt := make([]byte, encryptSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return DerivePassphraseKey(o.Passphrase, salt)
+}
+
+// GetKey return the key with the ID to decrypt a file. The keys derived from
+// the passphrase are cached, so scrypt runs once per dump.
+func (o *EncryptionOptions) GetKey(id string, salt []byte) (*EncryptionKey, error) {
+	o.keysMutex.Lock()
+	defer o.keysMutex.Unlock()
+
+	cacheKey := hex.EncodeToString(salt)
+	key, ok := o.keys[cacheKey]
+	if !ok {
+		var err error
+		switch {
+		case len(salt) > 0 && o.Passphrase != "":
+			key, err = DerivePassphraseKey(o.Passphrase, salt)
+		case len(salt) == 0 && o.KeyFile != "":
+			var content []byte
+			if content, err = ReadKeyFile(o.KeyFile); err == nil {
+				key = newEncryptionKey(content, nil)
+			}
+		case len(salt) > 0:
+			err = errors.New("the file was encrypted with a passphrase and there is no passphrase")
+		default:
+			err = errors.New("the file was encrypted with a key file and there is no key file")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if o.keys == nil {
+			o.keys = make(map[string]*EncryptionKey)
+		}
+		o.keys[cacheKey] = key
+	}
+	if key.ID != id {
+		return nil, fmt.Errorf("the file was encrypted with the key %s and the key given is %s", id, key.ID)
+	}
+	return key, nil
+}
+
+// getSegmentCipher return the cipher of a file. Each file has its own key,
+// derived from the key of the dump and a random salt, so the nonces can be
+// the number of the segment.
+func getSegmentCipher(key []byte, fileSalt []byte) (cipher.AEAD, error) {
+	fileKey := make([]byte, encryptKeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, fileSalt, []byte("go-dump file key")), fileKey); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(fileKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// getSegmentNonce return the nonce of a segment. The last byte marks the
+// last segment, so a file truncated on a segment boundary is detected.
+func getSegmentNonce(nonce []byte, counter uint32, last bool) []byte {
+	binary.BigEndian.PutUint32(nonce[7:11], counter)
+	nonce[11] = 0
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// encryptWriter encrypts the data in segments of encryptSegmentSize bytes.
+// The file starts with a header with the key ID, the salt of the
+// passphrase and the salt of the file.
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	buffer  []byte
+	sealed  []byte
+	nonce   []byte
+	counter uint32
+}
+
+func newEncryptWriter(w io.Writer, key *EncryptionKey) (*encryptWriter, error) {
+	fileSalt := make([]byte, encryptSaltSize)
+	if _, err := rand.Read(fileSalt); err != nil {
+		return nil, err
+	}
+	aead, err := getSegmentCipher(key.Key, fileSalt)
+	if err != nil {
+		return nil, err
+	}
+
+	header := []byte(encryptMagic)
+	header = append(header, byte(len(key.ID)))
+	header = append(header, key.ID...)
+	header = append(header, byte(len(key.Salt)))
+	header = append(header, key.Salt...)
+	header = append(header, fileSalt...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, buffer: make([]byte, 0, encryptSegmentSize),
+		nonce: make([]byte, aead.NonceSize())}, nil
+}
+
+// Write keeps a full segment until more data arrives, because the last
+// segment is sealed in a different way.
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if len(e.buffer) == encryptSegmentSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buffer[len(e.buffer):encryptSegmentSize], p)
+		e.buffer = e.buffer[:len(e.buffer)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (e *encryptWriter) seal(last bool) error {
+	if e.counter == ^uint32(0) {
+		return errors.New("the file is too big to be encrypted")
+	}
+	e.sealed = e.aead.Seal(e.sealed[:0], getSegmentNonce(e.nonce, e.counter, last), e.buffer, nil)
+	e.counter++
+	e.buffer = e.buffer[:0]
+	_, err := e.w.Write(e.sealed)
+	return err
+}
+
+// Close seals the last segment. It doesn't close the underlying writer.
+func (e *encryptWriter) Close() error {
+	return e.seal(true)
+}
+
+// decryptReader reads a file written by encryptWriter.
+type decryptReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	segment []byte
+	plain   []byte
+	nonce   []byte
+	counter uint32
+	done    bool
+}
+
+func newDecryptReader(r io.Reader, options *EncryptionOptions) (*decryptReader, error) {
+	reader := bufio.NewReader(r)
+	magic := make([]byte, len(encryptMagic))
+	if _, err := io.ReadFull(reader, magic); err != nil || string(magic) != encryptMagic {
+		return nil, errors.New("the file is not encrypted by go-dump")
+	}
+	readField := func() ([]byte, error) {
+		length, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		field := make([]byte, length)
+		_, err = io.ReadFull(reader, field)
+		return field, err
+	}
+	id, err := readField()
+	if err != nil {
+		return nil, fmt.Errorf("error reading the encryption header: %v", err)
+	}
+	salt, err := readField()
+	if err != nil {
+		return nil, fmt.Errorf("error reading the encryption header: %v", err)
+	}
+	fileSalt := make([]byte, encryptSaltSize)
+	if _, err := io.ReadFull(reader, fileSalt); err != nil {
+		return nil, fmt.Errorf("error reading the encryption header: %v", err)
+	}
+
+	key, err := options.GetKey(string(id), salt)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := getSegmentCipher(key.Key, fileSalt)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{r: reader, aead: aead,
+		segment: make([]byte, encryptSegmentSize+aead.Overhead()),
+		nonce:   make([]byte, aead.NonceSize())}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// open reads and decrypts the next segment. A segment is the last one when
+// there is no more data after it.
+func (d *decryptReader) open() error {
+	n, err := io.ReadFull(d.r, d.segment)
+	last := false
+	switch err {
+	case nil:
+		if _, err := d.r.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	case io.EOF, io.ErrUnexpectedEOF:
+		last = true
+	default:
+		return err
+	}
+
+	d.plain, err = d.aead.Open(d.segment[:0], getSegmentNonce(d.nonce, d.counter, last), d.segment[:n], nil)
+	if err != nil {
+		return errors.New("error decrypting the file, it's corrupted or truncated")
+	}
+	d.counter++
+	d.done = last
+	return nil
+}
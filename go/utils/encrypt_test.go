@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeEncryptedFile(t *testing.T, dir string, name string, data []byte, compressAlgorithm string, key *EncryptionKey) string {
+	buffer, err := NewSinkBuffer(NewFileSink(dir), name, compressAlgorithm, 1, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buffer.Write(data)
+	if err := buffer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, buffer.FileName)
+}
+
+func readEncryptedFile(fileName string, options *EncryptionOptions) ([]byte, error) {
+	reader, err := NewFileReader(fileName, options)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+func TestEncryption(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "dump.key")
+	rawKey := make([]byte, encryptKeySize)
+	rand.Read(rawKey)
+	os.WriteFile(keyFile, []byte(hex.EncodeToString(rawKey)+"\n"), 0600)
+
+	keyFileOptions := &EncryptionOptions{KeyFile: keyFile}
+	passphraseOptions := &EncryptionOptions{Passphrase: "correct horse battery staple"}
+	for _, options := range []*EncryptionOptions{keyFileOptions, passphraseOptions} {
+		key, err := options.NewKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The reader gets its own options, as in a restore.
+		readOptions := &EncryptionOptions{KeyFile: options.KeyFile, Passphrase: options.Passphrase}
+
+		for _, size := range []int{0, 1, encryptSegmentSize, encryptSegmentSize + 1, 3*encryptSegmentSize + 7} {
+			data := make([]byte, size)
+			rand.Read(data)
+			for _, algorithm := range []string{"", CompressAlgorithmZstd} {
+				fileName := writeEncryptedFile(t, dir, "sakila.city-thread0.sql", data, algorithm, key)
+				if !strings.HasSuffix(fileName, EncryptExtension) {
+					t.Fatalf("Unexpected file name %s", fileName)
+				}
+				got, err := readEncryptedFile(fileName, readOptions)
+				if err != nil {
+					t.Fatalf("Error reading %s with %d bytes: %v", fileName, size, err)
+				}
+				if !bytes.Equal(got, data) {
+					t.Fatalf("The file %s with %d bytes doesn't have the same data", fileName, size)
+				}
+			}
+		}
+	}
+
+	key, _ := keyFileOptions.NewKey()
+	fileName := writeEncryptedFile(t, dir, "sakila.actor-thread0.sql", bytes.Repeat([]byte("x"), 2*encryptSegmentSize+10), "", key)
+	if _, err := readEncryptedFile(fileName, nil); err == nil {
+		t.Error("Expected an error reading without key")
+	}
+	if _, err := readEncryptedFile(fileName, passphraseOptions); err == nil {
+		t.Error("Expected an error reading with a passphrase")
+	}
+	otherKey := filepath.Join(dir, "other.key")
+	os.WriteFile(otherKey, bytes.Repeat([]byte("k"), encryptKeySize), 0600)
+	if _, err := readEncryptedFile(fileName, &EncryptionOptions{KeyFile: otherKey}); err == nil {
+		t.Error("Expected an error reading with another key")
+	}
+
+	content, _ := os.ReadFile(fileName)
+	header := len(encryptMagic) + 1 + len(key.ID) + 1 + encryptSaltSize
+	segment := encryptSegmentSize + 16
+
+	corrupted := append([]byte(nil), content...)
+	corrupted[header+10] ^= 1
+	os.WriteFile(fileName, corrupted, 0644)
+	if _, err := readEncryptedFile(fileName, keyFileOptions); err == nil {
+		t.Error("Expected an error reading a corrupted file")
+	}
+
+	// Truncated on a segment boundary.
+	os.WriteFile(fileName, content[:header+2*segment], 0644)
+	if _, err := readEncryptedFile(fileName, keyFileOptions); err == nil {
+		t.Error("Expected an error reading a truncated file")
+	}
+}
@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The kinds of errors of a dump. They can be checked with errors.Is, for
+// example errors.Is(err, ErrConnection), to choose the exit code.
+var (
+	ErrConnection  = errors.New("connection error")
+	ErrSchema      = errors.New("schema error")
+	ErrData        = errors.New("data error")
+	ErrIO          = errors.New("i/o error")
+	ErrInterrupted = errors.New("interrupted") // the context of the dump is canceled
+)
+
+// DumpError is an error with the kind of the failure.
+type DumpError struct {
+	Kind error
+	Err  error
+}
+
+func (e *DumpError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap return the kind and the cause, so errors.Is and errors.As work with
+// both.
+func (e *DumpError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// newError creates an error of the kind with a formatted message. The
+// message can wrap the cause with %w.
+func newError(kind error, format string, args ...interface{}) error {
+	return &DumpError{Kind: kind, Err: fmt.Errorf(format, args...)}
+}
+
+// ErrorKind return the kind of the outermost DumpError, or nil if the error
+// doesn't have one.
+func ErrorKind(err error) error {
+	var dumpErr *DumpError
+	if errors.As(err, &dumpErr) {
+		return dumpErr.Kind
+	}
+	return nil
+}
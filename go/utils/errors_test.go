@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorKind(t *testing.T) {
+	err := newError(ErrData, "error dumping the chunk 2 of `sakila`.`city`: %w", sql.ErrConnDone)
+	if !errors.Is(err, ErrData) || !errors.Is(err, sql.ErrConnDone) || errors.Is(err, ErrIO) {
+		t.Fatalf("Unexpected kind of %v", err)
+	}
+	if err.Error() != "error dumping the chunk 2 of `sakila`.`city`: "+sql.ErrConnDone.Error() {
+		t.Fatalf("Unexpected message %q", err.Error())
+	}
+
+	// The outermost kind is the kind of the error.
+	wrapped := newError(ErrIO, "error closing the file: %w", newError(ErrConnection, "lost"))
+	if kind := ErrorKind(fmt.Errorf("the dump failed: %w", wrapped)); kind != ErrIO {
+		t.Fatalf("Unexpected kind %v", kind)
+	}
+	if kind := ErrorKind(errors.New("no kind")); kind != nil {
+		t.Fatalf("Unexpected kind %v", kind)
+	}
+}
+
+func TestGetMySQLConnectionError(t *testing.T) {
+	host := getMySQLHost()
+	host.Port = 1
+	if _, err := GetMySQLConnection(host, getMySQLCredentials()); !errors.Is(err, ErrConnection) {
+		t.Fatalf("Expected a connection error and got %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package utils
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// namePattern is a glob, like "shop.order_*", or a regular expression
+// between slashes, like "/_(gho|ghc|del)$/".
+type namePattern struct {
+	glob   string
+	regexp *regexp.Regexp
+}
+
+func newNamePattern(pattern string) (*namePattern, error) {
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %s: %v", pattern, err)
+		}
+		return &namePattern{regexp: re}, nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %s: %v", pattern, err)
+	}
+	return &namePattern{glob: pattern}, nil
+}
+
+// match return true if the glob matches the whole name or the regular
+// expression matches any part of it.
+func (p *namePattern) match(name string) bool {
+	if p.regexp != nil {
+		return p.regexp.MatchString(name)
+	}
+	matched, _ := path.Match(p.glob, name)
+	return matched
+}
+
+// splitPatterns splits a comma separated list of patterns. The commas of a
+// regular expression, like "/^log_[0-9]{1,3}$/", don't split it.
+func splitPatterns(patterns string) []string {
+	var split []string
+	start, inRegexp := 0, false
+	for i := 0; i < len(patterns); i++ {
+		switch patterns[i] {
+		case '\\':
+			if inRegexp {
+				i++
+			}
+		case '/':
+			if inRegexp {
+				inRegexp = false
+			} else if strings.TrimSpace(patterns[start:i]) == "" {
+				inRegexp = true
+			}
+		case ',':
+			if !inRegexp {
+				split = append(split, patterns[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(split, patterns[start:])
+}
+
+func parseNamePatterns(patterns string) ([]*namePattern, error) {
+	var parsed []*namePattern
+	for _, pattern := range splitPatterns(patterns) {
+		if pattern = strings.TrimSpace(pattern); pattern == "" {
+			continue
+		}
+		p, err := newNamePattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, p)
+	}
+	return parsed, nil
+}
+
+func matchAny(patterns []*namePattern, name string) bool {
+	for _, p := range patterns {
+		if p.match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// TableFilter selects the tables to dump by their name, schema.table, after
+// they are listed from --databases, --tables or --all-databases. A pattern
+// without a dot matches the name of the table in any schema.
+type TableFilter struct {
+	include          []*namePattern
+	exclude          []*namePattern
+	excludeDatabases []*namePattern
+}
+
+// NewTableFilter creates a filter from comma separated lists of patterns.
+// All the tables are included if include is empty.
+func NewTableFilter(include string, exclude string, excludeDatabases string) (*TableFilter, error) {
+	var f TableFilter
+	var err error
+	if f.include, err = parseNamePatterns(include); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = parseNamePatterns(exclude); err != nil {
+		return nil, err
+	}
+	if f.excludeDatabases, err = parseNamePatterns(excludeDatabases); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// MatchSchema return false if the schema is excluded.
+func (f *TableFilter) MatchSchema(schema string) bool {
+	return f == nil || !matchAny(f.excludeDatabases, schema)
+}
+
+// Match return true if the table of the schema should be dumped.
+func (f *TableFilter) Match(schema string, table string) bool {
+	if f == nil {
+		return true
+	}
+	if !f.MatchSchema(schema) {
+		return false
+	}
+	matchTable := func(patterns []*namePattern) bool {
+		for _, p := range patterns {
+			if p.glob != "" && !strings.Contains(p.glob, ".") {
+				if p.match(table) {
+					return true
+				}
+			} else if p.match(schema + "." + table) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(f.include) > 0 && !matchTable(f.include) {
+		return false
+	}
+	return !matchTable(f.exclude)
+}
+
+// FilterTables return the tables, named schema.table, that match the
+// filter, and the names of the tables excluded sorted.
+func (f *TableFilter) FilterTables(tables map[string]bool) (map[string]bool, []string) {
+	filtered := make(map[string]bool)
+	var excluded []string
+	for table := range tables {
+		parts := strings.SplitN(table, ".", 2)
+		if len(parts) == 2 && !f.Match(parts[0], parts[1]) {
+			excluded = append(excluded, table)
+			continue
+		}
+		filtered[table] = true
+	}
+	sort.Strings(excluded)
+	return filtered, excluded
+}
@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTableFilter(t *testing.T) {
+	filter, err := NewTableFilter("shop.*, sakila.film*", "*.archive_*,/_(gho|ghc|del)$/, tmp_*", "legacy_*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]bool{
+		"shop.orders":           true,
+		"shop.archive_2019":     false,
+		"shop._orders_gho":      false,
+		"shop._orders_del":      false,
+		"shop.tmp_import":       false,
+		"shop.orders_ghost":     true,
+		"sakila.film":           true,
+		"sakila.film_text":      true,
+		"sakila.actor":          false,
+		"legacy_shop.orders":    false,
+		"other.tmp_import":      false,
+		"shopping.orders":       false,
+		"sakila.archive_rental": false,
+	} {
+		parts := strings.SplitN(name, ".", 2)
+		if got := filter.Match(parts[0], parts[1]); got != expected {
+			t.Errorf("The table %s should be included: %v", name, expected)
+		}
+	}
+	if filter.MatchSchema("legacy_shop") || !filter.MatchSchema("shop") {
+		t.Error("Unexpected match of the schemas")
+	}
+
+	tables, excluded := filter.FilterTables(map[string]bool{"shop.orders": true, "shop.tmp_a": true, "sakila.actor": true})
+	if len(tables) != 1 || !tables["shop.orders"] || strings.Join(excluded, ",") != "sakila.actor,shop.tmp_a" {
+		t.Fatalf("Unexpected tables %v excluding %v", tables, excluded)
+	}
+
+	// Without filter all the tables are dumped.
+	var none *TableFilter
+	if !none.Match("sakila", "actor") || !none.MatchSchema("sakila") {
+		t.Fatal("A nil filter should match all the tables")
+	}
+
+	// The commas of the regular expressions don't split the list.
+	filter, err = NewTableFilter(`/\.log_[0-9]{1,3}$/, /^logs\.a\/b,c$/,shop.*`, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]bool{
+		"logs.log_1":    true,
+		"logs.log_123":  true,
+		"logs.log_1234": false,
+		"logs.a/b,c":    true,
+		"shop.orders":   true,
+		"sakila.actor":  false,
+	} {
+		parts := strings.SplitN(name, ".", 2)
+		if got := filter.Match(parts[0], parts[1]); got != expected {
+			t.Errorf("The table %s should be included: %v", name, expected)
+		}
+	}
+
+	for _, pattern := range []string{"/order_(/", "shop.[order"} {
+		if _, err := NewTableFilter(pattern, "", ""); err == nil {
+			t.Fatalf("Expected an error with the pattern %s", pattern)
+		}
+	}
+}
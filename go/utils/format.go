@@ -0,0 +1,394 @@
+package utils
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	OutputFormatSQL     = "sql"
+	OutputFormatCSV     = "csv"
+	OutputFormatTSV     = "tsv"
+	OutputFormatJSONL   = "jsonl"
+	OutputFormatParquet = "parquet"
+)
+
+// DefaultCSVNull is the value written for NULL in the CSV and TSV formats.
+// It is the same value used by SELECT ... INTO OUTFILE.
+const DefaultCSVNull = `\N`
+
+// CSVNullWord is the other value for NULL in the CSV and TSV formats. LOAD
+// DATA reads it as NULL only without quotes, so it needs a quote character.
+const CSVNullWord = "NULL"
+
+// csvEscape is the escape character of the delimited formats, so LOAD DATA
+// reads \N as NULL.
+const csvEscape = `\`
+
+// IsValidOutputFormat return true if the format is supported.
+func IsValidOutputFormat(format string) bool {
+	switch format {
+	case OutputFormatSQL, OutputFormatCSV, OutputFormatTSV, OutputFormatJSONL, OutputFormatParquet:
+		return true
+	}
+	return false
+}
+
+// IsDelimitedFormat return true for the formats that are loaded with
+// LOAD DATA INFILE.
+func IsDelimitedFormat(format string) bool {
+	return format == OutputFormatCSV || format == OutputFormatTSV
+}
+
+// GetOutputFormatExtension return the extension of the data files for a
+// format.
+func GetOutputFormatExtension(format string) string {
+	switch format {
+	case OutputFormatCSV, OutputFormatTSV, OutputFormatJSONL, OutputFormatParquet:
+		return format
+	}
+	return "sql"
+}
+
+// CSVOptions contains the options of the CSV and TSV formats.
+type CSVOptions struct {
+	Delimiter string
+	Quote     string
+	Null      string
+}
+
+// ParseDelimiter return the delimiter for a value of --csv-delimiter. The
+// value "\t" is accepted for a tab because it is hard to type in a shell.
+func ParseDelimiter(value string) string {
+	if value == `\t` {
+		return "\t"
+	}
+	return value
+}
+
+// GetCSVOptions return the options for the delimited output formats, using
+// a tab as delimiter for TSV unless a delimiter was set.
+func (do *DumpOptions) GetCSVOptions() *CSVOptions {
+	options := &CSVOptions{
+		Delimiter: do.CSVDelimiter,
+		Quote:     do.CSVQuote,
+		Null:      do.CSVNull,
+	}
+	if options.Delimiter == "" {
+		if do.OutputFormat == OutputFormatTSV {
+			options.Delimiter = "\t"
+		} else {
+			options.Delimiter = ","
+		}
+	}
+	return options
+}
+
+// isBinaryType return true for the data types that hold bytes instead of
+// characters, including the spatial types. The values of these columns are
+// written in hex in the delimited formats.
+func isBinaryType(dataType string) bool {
+	switch strings.ToLower(dataType) {
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob", "bit",
+		"geometry", "point", "linestring", "polygon", "multipoint", "multilinestring",
+		"multipolygon", "geometrycollection", "geomcollection":
+		return true
+	}
+	return false
+}
+
+// RowWriter writes the rows of a chunk in one of the output formats.
+type RowWriter interface {
+	WriteRow(values []interface{}) error
+	Close() error
+}
+
+// NewRowWriter return the RowWriter for the output format of the dump.
+func (dc *DataChunk) NewRowWriter(buffer *Buffer, columns []*sql.ColumnType) (RowWriter, error) {
+	dumpOptions := dc.Task.TaskManager.DumpOptions
+	switch dumpOptions.OutputFormat {
+	case OutputFormatCSV, OutputFormatTSV:
+		return newCSVRowWriter(buffer, columns, dumpOptions.GetCSVOptions()), nil
+	case OutputFormatJSONL:
+		return newJSONRowWriter(buffer, columns), nil
+	case OutputFormatParquet:
+		return newParquetRowWriter(buffer, dc.Task.Table)
+	}
+	return &sqlRowWriter{
+		insert: newInsertWriter(buffer,
+			fmt.Sprintf("INSERT INTO %s VALUES \n", dc.Task.Table.GetName()),
+			dc.Task.OutputChunkSize,
+			dumpOptions.MaxStatementBytes)}, nil
+}
+
+// sqlRowWriter writes the rows as INSERT statements.
+type sqlRowWriter struct {
+	insert *insertWriter
+	row    bytes.Buffer
+}
+
+func (sw *sqlRowWriter) WriteRow(values []interface{}) error {
+	sw.row.Reset()
+	sw.row.WriteByte('(')
+	for i, d := range values {
+		switch v := d.(type) {
+		case []byte:
+			sw.row.WriteByte('\'')
+			sw.row.Write(ParseString(v))
+			sw.row.WriteByte('\'')
+		case int64:
+			fmt.Fprintf(&sw.row, "%d", v)
+		case uint64:
+			fmt.Fprintf(&sw.row, "%d", v)
+		case nil:
+			sw.row.WriteString("NULL")
+		case time.Time:
+			fmt.Fprintf(&sw.row, "'%s'", v.Format("2006-01-02 15:04:05.999999"))
+		case float32:
+			sw.row.WriteString(strconv.FormatFloat(float64(v), 'g', -1, 32))
+		case float64:
+			sw.row.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+		default:
+			fmt.Fprintf(&sw.row, "'%v'", v)
+		}
+		if i != len(values)-1 {
+			sw.row.WriteByte(',')
+		}
+	}
+	sw.row.WriteByte(')')
+	return sw.insert.WriteRow(sw.row.Bytes())
+}
+
+func (sw *sqlRowWriter) Close() error {
+	return sw.insert.Close()
+}
+
+// csvRowWriter writes the rows following RFC 4180, with the escapes of
+// SELECT ... INTO OUTFILE. The fields are quoted only when needed and the
+// quotes inside a field are doubled. The backslashes are escaped, so NULL is
+// written as \N without quotes and can't be mistaken for a string. Without a
+// quote character the delimiter and the line breaks are escaped too. The
+// strings equal to the word NULL are always quoted, LOAD DATA reads them as
+// NULL otherwise.
+type csvRowWriter struct {
+	w       io.Writer
+	options *CSVOptions
+	binary  []bool
+	row     bytes.Buffer
+}
+
+func newCSVRowWriter(w io.Writer, columns []*sql.ColumnType, options *CSVOptions) *csvRowWriter {
+	binary := make([]bool, len(columns))
+	for i, column := range columns {
+		binary[i] = isBinaryType(column.DatabaseTypeName())
+	}
+	return &csvRowWriter{w: w, options: options, binary: binary}
+}
+
+// needsQuotes return true if the field can not be written as it is.
+func (cw *csvRowWriter) needsQuotes(field []byte) bool {
+	return strings.EqualFold(string(field), CSVNullWord) ||
+		bytes.Contains(field, []byte(cw.options.Delimiter)) ||
+		bytes.Contains(field, []byte(cw.options.Quote)) ||
+		bytes.ContainsAny(field, "\r\n")
+}
+
+// escape return the field with the backslashes escaped and, without a quote
+// character, the delimiter and the line breaks.
+func (cw *csvRowWriter) escape(field []byte) []byte {
+	field = bytes.ReplaceAll(field, []byte(csvEscape), []byte(csvEscape+csvEscape))
+	if cw.options.Quote != "" {
+		return field
+	}
+	delimiter := csvEscape + cw.options.Delimiter
+	if cw.options.Delimiter == "\t" {
+		delimiter = csvEscape + "t"
+	}
+	field = bytes.ReplaceAll(field, []byte(cw.options.Delimiter), []byte(delimiter))
+	field = bytes.ReplaceAll(field, []byte("\n"), []byte(csvEscape+"n"))
+	return bytes.ReplaceAll(field, []byte("\r"), []byte(csvEscape+"r"))
+}
+
+func (cw *csvRowWriter) writeField(field []byte) {
+	field = cw.escape(field)
+	if cw.options.Quote == "" || !cw.needsQuotes(field) {
+		cw.row.Write(field)
+		return
+	}
+	quote := []byte(cw.options.Quote)
+	cw.row.Write(quote)
+	cw.row.Write(bytes.ReplaceAll(field, quote, append(quote, quote...)))
+	cw.row.Write(quote)
+}
+
+func (cw *csvRowWriter) WriteRow(values []interface{}) error {
+	cw.row.Reset()
+	for i, d := range values {
+		if i > 0 {
+			cw.row.WriteString(cw.options.Delimiter)
+		}
+		switch v := d.(type) {
+		case nil:
+			cw.row.WriteString(cw.options.Null)
+		case []byte:
+			if i < len(cw.binary) && cw.binary[i] {
+				cw.row.WriteString(strings.ToUpper(hex.EncodeToString(v)))
+			} else {
+				cw.writeField(v)
+			}
+		case int64:
+			cw.row.WriteString(strconv.FormatInt(v, 10))
+		case uint64:
+			cw.row.WriteString(strconv.FormatUint(v, 10))
+		case float32:
+			cw.row.WriteString(strconv.FormatFloat(float64(v), 'g', -1, 32))
+		case float64:
+			cw.row.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+		case time.Time:
+			cw.row.WriteString(v.Format("2006-01-02 15:04:05.999999"))
+		default:
+			cw.writeField([]byte(fmt.Sprintf("%v", v)))
+		}
+	}
+	cw.row.WriteByte('\n')
+	_, err := cw.w.Write(cw.row.Bytes())
+	return err
+}
+
+func (cw *csvRowWriter) Close() error {
+	return nil
+}
+
+// jsonRowWriter writes one JSON object per line with the columns in the
+// order of the table. Decimals are written as strings to keep the precision,
+// binary values are encoded in base64 and the values of JSON columns are
+// embedded as JSON.
+type jsonRowWriter struct {
+	w     io.Writer
+	names []string
+	types []string
+	row   bytes.Buffer
+}
+
+func newJSONRowWriter(w io.Writer, columns []*sql.ColumnType) *jsonRowWriter {
+	jw := &jsonRowWriter{w: w}
+	for _, column := range columns {
+		jw.names = append(jw.names, column.Name())
+		jw.types = append(jw.types, strings.ToLower(column.DatabaseTypeName()))
+	}
+	return jw
+}
+
+// writeString writes a JSON string without escaping the HTML characters.
+func (jw *jsonRowWriter) writeString(value string) error {
+	encoder := json.NewEncoder(&jw.row)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return err
+	}
+	// Encode adds a new line after the value.
+	jw.row.Truncate(jw.row.Len() - 1)
+	return nil
+}
+
+func (jw *jsonRowWriter) writeValue(dataType string, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		jw.row.WriteString("null")
+	case []byte:
+		switch {
+		case dataType == "json" && json.Valid(v):
+			return json.Compact(&jw.row, v)
+		case isBinaryType(dataType):
+			return jw.writeString(base64.StdEncoding.EncodeToString(v))
+		default:
+			return jw.writeString(string(v))
+		}
+	case int64:
+		jw.row.WriteString(strconv.FormatInt(v, 10))
+	case uint64:
+		jw.row.WriteString(strconv.FormatUint(v, 10))
+	case float32:
+		jw.row.WriteString(strconv.FormatFloat(float64(v), 'g', -1, 32))
+	case float64:
+		jw.row.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+	case time.Time:
+		return jw.writeString(v.Format("2006-01-02 15:04:05.999999"))
+	default:
+		return jw.writeString(fmt.Sprintf("%v", v))
+	}
+	return nil
+}
+
+func (jw *jsonRowWriter) WriteRow(values []interface{}) error {
+	jw.row.Reset()
+	jw.row.WriteByte('{')
+	for i, value := range values {
+		if i > 0 {
+			jw.row.WriteByte(',')
+		}
+		if err := jw.writeString(jw.names[i]); err != nil {
+			return err
+		}
+		jw.row.WriteByte(':')
+		if err := jw.writeValue(jw.types[i], value); err != nil {
+			return err
+		}
+	}
+	jw.row.WriteString("}\n")
+	_, err := jw.w.Write(jw.row.Bytes())
+	return err
+}
+
+func (jw *jsonRowWriter) Close() error {
+	return nil
+}
+
+// quoteSQLString return the value as a quoted SQL string.
+func quoteSQLString(value string) string {
+	return "'" + string(ParseString([]byte(value))) + "'"
+}
+
+// GetLoadDataSQL return the LOAD DATA LOCAL INFILE statement to load a file
+// in the delimited format into the table. Generated columns are skipped and
+// binary columns are decoded from hex. The NULL values are read by LOAD
+// DATA, \N with the escape character or the word NULL without quotes.
+func GetLoadDataSQL(t *Table, fileName string, options *CSVOptions) string {
+	var columns, set []string
+	for i, column := range t.GetColumns() {
+		variable := fmt.Sprintf("@c%d", i+1)
+		name := fmt.Sprintf("`%s`", column.Name)
+		switch {
+		case column.IsGenerated:
+			columns = append(columns, "@dummy")
+		case isBinaryType(column.DataType):
+			columns = append(columns, variable)
+			set = append(set, fmt.Sprintf("%s = UNHEX(%s)", name, variable))
+		default:
+			columns = append(columns, name)
+		}
+	}
+
+	var query strings.Builder
+	fmt.Fprintf(&query, "LOAD DATA LOCAL INFILE %s INTO TABLE %s\n", quoteSQLString(fileName), t.GetName())
+	fmt.Fprintf(&query, "  CHARACTER SET utf8mb4\n")
+	fmt.Fprintf(&query, "  FIELDS TERMINATED BY %s", quoteSQLString(options.Delimiter))
+	if options.Quote != "" {
+		fmt.Fprintf(&query, " OPTIONALLY ENCLOSED BY %s", quoteSQLString(options.Quote))
+	}
+	fmt.Fprintf(&query, " ESCAPED BY %s\n", quoteSQLString(csvEscape))
+	fmt.Fprintf(&query, "  LINES TERMINATED BY '\\n'\n")
+	fmt.Fprintf(&query, "  (%s)", strings.Join(columns, ", "))
+	if len(set) > 0 {
+		fmt.Fprintf(&query, "\n  SET %s", strings.Join(set, ", "))
+	}
+	return query.String()
+}
@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCSVRowWriter(t *testing.T) {
+	tests := []struct {
+		options *CSVOptions
+		expect  string
+	}{
+		{&CSVOptions{Delimiter: ",", Quote: "\"", Null: DefaultCSVNull},
+			"1,plain,\"a,b\",\"say \"\"hi\"\"\",\"two\nlines\",\\N,\\\\N,\"NULL\",a\\\\b,00FF,2.5\n"},
+		{&CSVOptions{Delimiter: "\t", Quote: "\"", Null: DefaultCSVNull},
+			"1\tplain\ta,b\t\"say \"\"hi\"\"\"\t\"two\nlines\"\t\\N\t\\\\N\t\"NULL\"\ta\\\\b\t00FF\t2.5\n"},
+		{&CSVOptions{Delimiter: ",", Quote: "\"", Null: CSVNullWord},
+			"1,plain,\"a,b\",\"say \"\"hi\"\"\",\"two\nlines\",NULL,\\\\N,\"NULL\",a\\\\b,00FF,2.5\n"},
+		// Without quotes the delimiter and the line breaks are escaped.
+		{&CSVOptions{Delimiter: ",", Quote: "", Null: DefaultCSVNull},
+			"1,plain,a\\,b,say \"hi\",two\\nlines,\\N,\\\\N,NULL,a\\\\b,00FF,2.5\n"},
+		{&CSVOptions{Delimiter: "\t", Quote: "", Null: DefaultCSVNull},
+			"1\tplain\ta,b\tsay \"hi\"\ttwo\\nlines\t\\N\t\\\\N\tNULL\ta\\\\b\t00FF\t2.5\n"},
+	}
+
+	row := []interface{}{int64(1), []byte("plain"), []byte("a,b"), []byte("say \"hi\""),
+		[]byte("two\nlines"), nil, []byte("\\N"), []byte("NULL"), []byte("a\\b"), []byte{0x00, 0xff}, float64(2.5)}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		writer := &csvRowWriter{w: &out, options: tt.options,
+			binary: []bool{false, false, false, false, false, false, false, false, false, true, false}}
+		if err := writer.WriteRow(row); err != nil {
+			t.Fatalf("Unexpected error %s", err.Error())
+		}
+		if out.String() != tt.expect {
+			t.Errorf("Got %q and expected %q", out.String(), tt.expect)
+		}
+	}
+}
+
+func TestJSONRowWriter(t *testing.T) {
+	var out bytes.Buffer
+	writer := &jsonRowWriter{w: &out,
+		names: []string{"id", "price", "name", "hash", "attributes", "deleted", "ratio"},
+		types: []string{"bigint", "decimal", "varchar", "varbinary", "json", "datetime", "double"}}
+
+	rows := [][]interface{}{
+		{int64(1), []byte("12345678901234567890.99"), []byte("a \"<b>\"\n"), []byte{0x00, 0xff},
+			[]byte(`{"size": [1, 2], "color": "red"}`), nil, float64(0.25)},
+		{uint64(18446744073709551615), nil, []byte("b"), nil, []byte("not json"), []byte("2024-01-02 03:04:05"), nil},
+	}
+	expect := `{"id":1,"price":"12345678901234567890.99","name":"a \"<b>\"\n","hash":"AP8=","attributes":{"size":[1,2],"color":"red"},"deleted":null,"ratio":0.25}` + "\n" +
+		`{"id":18446744073709551615,"price":null,"name":"b","hash":null,"attributes":"not json","deleted":"2024-01-02 03:04:05","ratio":null}` + "\n"
+
+	for _, row := range rows {
+		if err := writer.WriteRow(row); err != nil {
+			t.Fatalf("Unexpected error %s", err.Error())
+		}
+	}
+	if out.String() != expect {
+		t.Fatalf("Got %s and expected %s", out.String(), expect)
+	}
+}
+
+func TestGetLoadDataSQL(t *testing.T) {
+	table := &Table{
+		name:   "staff",
+		schema: "sakila",
+		columns: []*Column{
+			{Name: "id", DataType: "int"},
+			{Name: "email", DataType: "varchar", IsNullable: true},
+			{Name: "picture", DataType: "blob", IsNullable: true},
+			{Name: "hash", DataType: "varbinary"},
+			{Name: "full_name", DataType: "varchar", IsNullable: true, IsGenerated: true},
+		},
+	}
+
+	expect := "LOAD DATA LOCAL INFILE 'sakila.staff-thread0.csv' INTO TABLE `staff`\n" +
+		"  CHARACTER SET utf8mb4\n" +
+		"  FIELDS TERMINATED BY ',' OPTIONALLY ENCLOSED BY '\\\"' ESCAPED BY '\\\\'\n" +
+		"  LINES TERMINATED BY '\\n'\n" +
+		"  (`id`, `email`, @c3, @c4, @dummy)\n" +
+		"  SET `picture` = UNHEX(@c3), `hash` = UNHEX(@c4)"
+
+	query := GetLoadDataSQL(table, "sakila.staff-thread0.csv",
+		&CSVOptions{Delimiter: ",", Quote: "\"", Null: DefaultCSVNull})
+	if query != expect {
+		t.Fatalf("Got \"%s\" and expected \"%s\"", query, expect)
+	}
+}
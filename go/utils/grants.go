@@ -0,0 +1,320 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ChaosHour/go-dump/go/log"
+)
+
+// GrantsFile is the file with the accounts of the server and their
+// privileges, written with --dump-grants.
+const GrantsFile = "grants.sql"
+
+// IsGrantsFile return true for the grants file, compressed or encrypted.
+func IsGrantsFile(fileName string) bool {
+	return TrimCompressExtension(strings.TrimSuffix(fileName, EncryptExtension)) == GrantsFile
+}
+
+// systemAccounts are the accounts created by the server, they are never
+// dumped.
+var systemAccounts = map[string]bool{
+	"mysql.sys":        true,
+	"mysql.session":    true,
+	"mysql.infoschema": true,
+	"mariadb.sys":      true,
+}
+
+// ServerVersion is the version of a MySQL or MariaDB server.
+type ServerVersion struct {
+	MariaDB bool
+	Major   int
+	Minor   int
+	Patch   int
+}
+
+var serverVersionRegexp = regexp.MustCompile(`^([0-9]+)\.([0-9]+)\.([0-9]+)`)
+
+// ParseServerVersion parses the result of SELECT VERSION(), like "8.0.31"
+// or "10.6.12-MariaDB-log".
+func ParseServerVersion(version string) ServerVersion {
+	v := ServerVersion{MariaDB: strings.Contains(strings.ToLower(version), "mariadb")}
+	// Old clients see the MariaDB versions with a 5.5.5- prefix.
+	version = strings.TrimPrefix(version, "5.5.5-")
+	if match := serverVersionRegexp.FindStringSubmatch(version); match != nil {
+		v.Major, _ = strconv.Atoi(match[1])
+		v.Minor, _ = strconv.Atoi(match[2])
+		v.Patch, _ = strconv.Atoi(match[3])
+	}
+	return v
+}
+
+// AtLeast return true if the version is the same or newer than the one given.
+func (v ServerVersion) AtLeast(major int, minor int, patch int) bool {
+	if v.Major != major {
+		return v.Major > major
+	}
+	if v.Minor != minor {
+		return v.Minor > minor
+	}
+	return v.Patch >= patch
+}
+
+// hasShowCreateUser return true if the server supports SHOW CREATE USER.
+func (v ServerVersion) hasShowCreateUser() bool {
+	if v.MariaDB {
+		return v.AtLeast(10, 2, 0)
+	}
+	return v.AtLeast(5, 7, 6)
+}
+
+// Account is a user or a role with the statements to create it and grant
+// its privileges.
+type Account struct {
+	User         string
+	Host         string
+	IsRole       bool // a MariaDB role, MySQL roles are locked users
+	CreateSQL    string
+	Grants       []string
+	DefaultRoles []string // default roles of MySQL, like 'r'@'%'
+}
+
+// GetName return the account quoted like in the SQL statements. The
+// MariaDB roles don't have a host.
+func (a *Account) GetName() string {
+	if a.IsRole {
+		return quoteSQLString(a.User)
+	}
+	return quoteSQLString(a.User) + "@" + quoteSQLString(a.Host)
+}
+
+// defaultRoleRegexp matches the DEFAULT ROLE clause of SHOW CREATE USER in
+// MySQL 8.0, which is always followed by the REQUIRE clause.
+var defaultRoleRegexp = regexp.MustCompile(`(?is)\s+DEFAULT\s+ROLE\s+.*?(\s+REQUIRE\s)`)
+
+var createUserRegexp = regexp.MustCompile(`(?i)^\s*CREATE\s+USER\s+(?:IF\s+NOT\s+EXISTS\s+)?`)
+
+// normalizeCreateUser adds IF NOT EXISTS to a statement of SHOW CREATE
+// USER, so the existing accounts are kept, and removes the default roles,
+// which are set after the roles are created and granted.
+func normalizeCreateUser(statement string) string {
+	statement = defaultRoleRegexp.ReplaceAllString(statement, "$1")
+	return createUserRegexp.ReplaceAllString(statement, "CREATE USER IF NOT EXISTS ")
+}
+
+// buildCreateUser return the statement to create an account from its row of
+// mysql.user, for the servers without SHOW CREATE USER.
+func buildCreateUser(version ServerVersion, account *Account, user map[string]string) string {
+	statement := "CREATE USER IF NOT EXISTS " + account.GetName()
+	plugin := user["plugin"]
+	hash := user["authentication_string"]
+	if hash == "" {
+		hash = user["password"]
+	}
+	switch {
+	case version.MariaDB && (plugin == "" || plugin == "mysql_native_password" || plugin == "mysql_old_password"):
+		if user["password"] != "" {
+			statement += " IDENTIFIED BY PASSWORD " + quoteSQLString(user["password"])
+		}
+	case version.MariaDB:
+		statement += " IDENTIFIED VIA " + plugin
+		if hash != "" {
+			statement += " USING " + quoteSQLString(hash)
+		}
+	case plugin != "":
+		statement += " IDENTIFIED WITH " + quoteSQLString(plugin) + " AS " + quoteSQLString(hash)
+	}
+	return statement
+}
+
+// getAccountsSQL return the query of the accounts to dump. The patterns are
+// LIKE patterns of user@host.
+func getAccountsSQL(version ServerVersion, patterns []string) (string, []interface{}) {
+	query := "SELECT User, Host FROM mysql.user"
+	if version.MariaDB {
+		query = "SELECT User, Host, is_role FROM mysql.user"
+	}
+	var conditions []string
+	var args []interface{}
+	for _, pattern := range patterns {
+		conditions = append(conditions, "CONCAT(User, '@', Host) LIKE ?")
+		args = append(args, pattern)
+	}
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " OR ")
+	}
+	return query + " ORDER BY User, Host", args
+}
+
+// ParseGrantsUsers return the patterns of a comma separated list.
+func ParseGrantsUsers(users string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(users, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// queryColumn runs a query and return the first column of all the rows.
+func queryColumn(conn *sql.Conn, query string, args ...interface{}) ([]string, error) {
+	rows, err := conn.QueryContext(context.Background(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		result = append(result, values[0].String)
+	}
+	return result, rows.Err()
+}
+
+// GetAccounts reads the accounts of the server that match the patterns,
+// all of them if there are no patterns, with their privileges.
+func GetAccounts(db *sql.DB, patterns []string) ([]*Account, error) {
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var serverVersion string
+	if err := conn.QueryRowContext(context.Background(), "SELECT VERSION()").Scan(&serverVersion); err != nil {
+		return nil, err
+	}
+	version := ParseServerVersion(serverVersion)
+	if !version.MariaDB && version.AtLeast(8, 0, 17) {
+		// The hashes of caching_sha2_password are binary, so they are
+		// written in hex.
+		if _, err := conn.ExecContext(context.Background(), "SET SESSION print_identified_with_as_hex = ON"); err != nil {
+			log.Warningf("Error setting print_identified_with_as_hex: %s", err.Error())
+		}
+	}
+
+	query, args := getAccountsSQL(version, patterns)
+	rows, err := queryStrings(conn, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	var accounts []*Account
+	for _, row := range rows {
+		if systemAccounts[row["user"]] {
+			continue
+		}
+		account := &Account{User: row["user"], Host: row["host"], IsRole: row["is_role"] == "Y"}
+		if err := getAccount(conn, version, account); err != nil {
+			return nil, fmt.Errorf("error reading the account %s: %v", account.GetName(), err)
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, nil
+}
+
+// getAccount reads the statements to create the account, its privileges and
+// its default roles.
+func getAccount(conn *sql.Conn, version ServerVersion, account *Account) error {
+	switch {
+	case account.IsRole:
+		account.CreateSQL = "CREATE ROLE IF NOT EXISTS " + account.GetName()
+	case version.hasShowCreateUser():
+		statements, err := queryColumn(conn, "SHOW CREATE USER "+account.GetName())
+		if err != nil {
+			return err
+		}
+		if len(statements) == 0 {
+			return fmt.Errorf("the account doesn't exist")
+		}
+		account.CreateSQL = normalizeCreateUser(statements[0])
+	default:
+		users, err := queryStrings(conn, "SELECT * FROM mysql.user WHERE User = ? AND Host = ?", account.User, account.Host)
+		if err != nil {
+			return err
+		}
+		if len(users) == 0 {
+			return fmt.Errorf("the account doesn't exist")
+		}
+		account.CreateSQL = buildCreateUser(version, account, users[0])
+	}
+
+	grants, err := queryColumn(conn, "SHOW GRANTS FOR "+account.GetName())
+	if err != nil {
+		return err
+	}
+	account.Grants = grants
+
+	if !version.MariaDB && version.AtLeast(8, 0, 0) {
+		rows, err := queryStrings(conn, "SELECT DEFAULT_ROLE_USER, DEFAULT_ROLE_HOST FROM mysql.default_roles "+
+			"WHERE USER = ? AND HOST = ? ORDER BY DEFAULT_ROLE_USER, DEFAULT_ROLE_HOST", account.User, account.Host)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			account.DefaultRoles = append(account.DefaultRoles,
+				quoteSQLString(row["default_role_user"])+"@"+quoteSQLString(row["default_role_host"]))
+		}
+	}
+	return nil
+}
+
+// writeGrants writes the statements to create the accounts and grant their
+// privileges. All the accounts are created first, so the roles exist when
+// they are granted, and the default roles are set at the end.
+func writeGrants(w io.Writer, accounts []*Account) {
+	for _, account := range accounts {
+		fmt.Fprintf(w, "%s;\n", account.CreateSQL)
+	}
+	for _, account := range accounts {
+		fmt.Fprintf(w, "\n-- Grants for %s\n", account.GetName())
+		for _, grant := range account.Grants {
+			fmt.Fprintf(w, "%s;\n", grant)
+		}
+	}
+	for _, account := range accounts {
+		if len(account.DefaultRoles) > 0 {
+			fmt.Fprintf(w, "SET DEFAULT ROLE %s TO %s;\n", strings.Join(account.DefaultRoles, ", "), account.GetName())
+		}
+	}
+}
+
+// WriteGrantsSQL writes the accounts in the grants file.
+func (tm *TaskManager) WriteGrantsSQL() error {
+	accounts, err := GetAccounts(tm.DB, ParseGrantsUsers(tm.DumpOptions.GrantsUsers))
+	if err != nil {
+		return newError(ErrSchema, "error getting the accounts: %w", err)
+	}
+	if len(accounts) == 0 {
+		log.Warning("There are no accounts to dump")
+		return nil
+	}
+	buffer, err := NewGrantsBuffer(tm)
+	if err != nil {
+		return newError(ErrIO, "error creating the grants file: %w", err)
+	}
+	writeGrants(buffer, accounts)
+	if err := buffer.Close(); err != nil {
+		return newError(ErrIO, "error writing the grants file: %w", err)
+	}
+	tm.addFile(DumpFileGrants, buffer)
+	log.Infof("Written %d accounts", len(accounts))
+	return nil
+}
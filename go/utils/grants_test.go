@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseServerVersion(t *testing.T) {
+	for version, expected := range map[string]ServerVersion{
+		"8.0.31":                    {Major: 8, Minor: 0, Patch: 31},
+		"5.7.44-log":                {Major: 5, Minor: 7, Patch: 44},
+		"10.6.12-MariaDB-1:10.6.12": {MariaDB: true, Major: 10, Minor: 6, Patch: 12},
+		"5.5.5-10.1.48-MariaDB":     {MariaDB: true, Major: 10, Minor: 1, Patch: 48},
+	} {
+		if got := ParseServerVersion(version); got != expected {
+			t.Fatalf("Unexpected version of %s: %+v", version, got)
+		}
+	}
+
+	if !ParseServerVersion("5.7.6").hasShowCreateUser() || ParseServerVersion("5.7.5").hasShowCreateUser() ||
+		!ParseServerVersion("10.2.0-MariaDB").hasShowCreateUser() || ParseServerVersion("10.1.48-MariaDB").hasShowCreateUser() {
+		t.Fatal("Unexpected support of SHOW CREATE USER")
+	}
+}
+
+func TestNormalizeCreateUser(t *testing.T) {
+	for statement, expected := range map[string]string{
+		"CREATE USER `app`@`%` IDENTIFIED WITH 'caching_sha2_password' AS 0x2441 DEFAULT ROLE `reader`@`%`,`writer`@`%` REQUIRE NONE PASSWORD EXPIRE DEFAULT ACCOUNT UNLOCK": "CREATE USER IF NOT EXISTS `app`@`%` IDENTIFIED WITH 'caching_sha2_password' AS 0x2441 REQUIRE NONE PASSWORD EXPIRE DEFAULT ACCOUNT UNLOCK",
+		"CREATE USER 'app'@'%' IDENTIFIED WITH 'mysql_native_password' AS '*6BB4837EB74329105EE4568DDA7DC67ED2CA2AD9' REQUIRE NONE":                                          "CREATE USER IF NOT EXISTS 'app'@'%' IDENTIFIED WITH 'mysql_native_password' AS '*6BB4837EB74329105EE4568DDA7DC67ED2CA2AD9' REQUIRE NONE",
+		"CREATE USER `app`@`localhost` IDENTIFIED BY PASSWORD '*6BB4837EB74329105EE4568DDA7DC67ED2CA2AD9'":                                                                   "CREATE USER IF NOT EXISTS `app`@`localhost` IDENTIFIED BY PASSWORD '*6BB4837EB74329105EE4568DDA7DC67ED2CA2AD9'",
+	} {
+		if got := normalizeCreateUser(statement); got != expected {
+			t.Fatalf("Unexpected statement %q", got)
+		}
+	}
+}
+
+func TestBuildCreateUser(t *testing.T) {
+	account := &Account{User: "o'neil", Host: "%"}
+	mysql := buildCreateUser(ServerVersion{Major: 5, Minor: 7, Patch: 5}, account,
+		map[string]string{"plugin": "mysql_native_password", "authentication_string": "*6BB4"})
+	if mysql != `CREATE USER IF NOT EXISTS 'o\'neil'@'%' IDENTIFIED WITH 'mysql_native_password' AS '*6BB4'` {
+		t.Fatalf("Unexpected statement %q", mysql)
+	}
+	mariadb := buildCreateUser(ServerVersion{MariaDB: true, Major: 10, Minor: 1}, account,
+		map[string]string{"plugin": "", "password": "*6BB4"})
+	if mariadb != `CREATE USER IF NOT EXISTS 'o\'neil'@'%' IDENTIFIED BY PASSWORD '*6BB4'` {
+		t.Fatalf("Unexpected statement %q", mariadb)
+	}
+	socket := buildCreateUser(ServerVersion{MariaDB: true, Major: 10, Minor: 1}, account,
+		map[string]string{"plugin": "unix_socket"})
+	if socket != `CREATE USER IF NOT EXISTS 'o\'neil'@'%' IDENTIFIED VIA unix_socket` {
+		t.Fatalf("Unexpected statement %q", socket)
+	}
+}
+
+func TestGetAccountsSQL(t *testing.T) {
+	query, args := getAccountsSQL(ServerVersion{MariaDB: true}, ParseGrantsUsers("app%@%, backup@localhost,"))
+	if query != "SELECT User, Host, is_role FROM mysql.user WHERE CONCAT(User, '@', Host) LIKE ? OR "+
+		"CONCAT(User, '@', Host) LIKE ? ORDER BY User, Host" || len(args) != 2 || args[1] != "backup@localhost" {
+		t.Fatalf("Unexpected query %s %v", query, args)
+	}
+	if query, _ := getAccountsSQL(ServerVersion{Major: 8}, nil); query != "SELECT User, Host FROM mysql.user ORDER BY User, Host" {
+		t.Fatalf("Unexpected query %s", query)
+	}
+}
+
+func TestWriteGrants(t *testing.T) {
+	accounts := []*Account{
+		{User: "app", Host: "%", CreateSQL: "CREATE USER IF NOT EXISTS `app`@`%` REQUIRE NONE",
+			Grants:       []string{"GRANT USAGE ON *.* TO `app`@`%`", "GRANT `reader`@`%` TO `app`@`%`"},
+			DefaultRoles: []string{"'reader'@'%'"}},
+		{User: "reader", Host: "%", CreateSQL: "CREATE USER IF NOT EXISTS `reader`@`%` ACCOUNT LOCK",
+			Grants: []string{"GRANT SELECT ON `sakila`.* TO `reader`@`%`"}},
+		{User: "admin", IsRole: true, CreateSQL: "CREATE ROLE IF NOT EXISTS 'admin'",
+			Grants: []string{"GRANT ALL PRIVILEGES ON *.* TO `admin`"}},
+	}
+
+	var buffer bytes.Buffer
+	writeGrants(&buffer, accounts)
+	content := buffer.String()
+
+	// All the accounts are created before the grants, and the default
+	// roles are set at the end.
+	var last int
+	for _, statement := range []string{
+		"CREATE USER IF NOT EXISTS `app`@`%` REQUIRE NONE;\n",
+		"CREATE USER IF NOT EXISTS `reader`@`%` ACCOUNT LOCK;\n",
+		"CREATE ROLE IF NOT EXISTS 'admin';\n",
+		"-- Grants for 'app'@'%'\n",
+		"GRANT `reader`@`%` TO `app`@`%`;\n",
+		"GRANT SELECT ON `sakila`.* TO `reader`@`%`;\n",
+		"-- Grants for 'admin'\n",
+		"SET DEFAULT ROLE 'reader'@'%' TO 'app'@'%';\n",
+	} {
+		index := strings.Index(content, statement)
+		if index < last {
+			t.Fatalf("The statement %q is missing or out of order:\n%s", statement, content)
+		}
+		last = index
+	}
+
+	statements := NewStatementReader(strings.NewReader(content))
+	count := 0
+	for {
+		if _, err := statements.Next(); err != nil {
+			break
+		}
+		count++
+	}
+	if count != 8 {
+		t.Fatalf("Unexpected number of statements %d", count)
+	}
+}
@@ -0,0 +1,354 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ChaosHour/go-dump/go/log"
+)
+
+// ManifestFile is the name of the file with the metadata of the dump.
+const ManifestFile = "manifest.json"
+
+// Types of the files that don't belong to a table. The files of the tables
+// use the types of the restore, like RestoreFileData.
+const (
+	DumpFileMasterData = "master-data"
+	DumpFileSlaveData  = "slave-data"
+	DumpFileGrants     = "grants"
+)
+
+// Manifest is the metadata of a dump, written in manifest.json at the end
+// of the dump so other tools don't need to parse the file names.
+type Manifest struct {
+	Version       string           `json:"version"`
+	ServerVersion string           `json:"server_version"`
+	StartTime     time.Time        `json:"start_time"`
+	EndTime       time.Time        `json:"end_time"`
+	Options       *ManifestOptions `json:"options"`
+	MasterStatus  *MasterStatus    `json:"master_status,omitempty"`
+	SlaveStatus   []*SlaveStatus   `json:"slave_status,omitempty"`
+	Encryption    *EncryptionInfo  `json:"encryption,omitempty"`
+	Files         []*DumpFile      `json:"files"`
+	Tables        []*ManifestTable `json:"tables"`
+	// Consistent is true if all the tables were dumped from the same point
+	// in time, which a resumed dump can't always guarantee.
+	Consistent      bool       `json:"consistent"`
+	InterruptedRuns []*DumpRun `json:"interrupted_runs,omitempty"`
+}
+
+// ManifestOptions are the options used in the dump. The passwords and the
+// keys are not included.
+type ManifestOptions struct {
+	MySQLHost              string            `json:"mysql_host"`
+	MySQLPort              int               `json:"mysql_port"`
+	MySQLSocket            string            `json:"mysql_socket,omitempty"`
+	MySQLUser              string            `json:"mysql_user"`
+	Destination            string            `json:"destination"`
+	Databases              string            `json:"databases,omitempty"`
+	Tables                 string            `json:"tables,omitempty"`
+	AllDatabases           bool              `json:"all_databases"`
+	Threads                int               `json:"threads"`
+	ChunkSize              uint64            `json:"chunk_size"`
+	OutputChunkSize        uint64            `json:"output_chunk_size"`
+	MaxStatementBytes      uint64            `json:"max_statement_bytes"`
+	LockTables             bool              `json:"lock_tables"`
+	Consistent             bool              `json:"consistent"`
+	IsolationLevel         string            `json:"isolation_level"`
+	TablesWithoutUniqueKey string            `json:"tables_without_uniquekey"`
+	AddDropTable           bool              `json:"add_drop_table"`
+	SkipUseDatabase        bool              `json:"skip_use_database"`
+	GetMasterStatus        bool              `json:"get_master_status"`
+	GetSlaveStatus         bool              `json:"get_slave_status"`
+	OutputFormat           string            `json:"output_format"`
+	CSVDelimiter           string            `json:"csv_delimiter,omitempty"`
+	CSVQuote               string            `json:"csv_quote,omitempty"`
+	CSVNull                string            `json:"csv_null,omitempty"`
+	ChunksPerFile          uint64            `json:"chunks_per_file,omitempty"`
+	Views                  bool              `json:"views"`
+	Triggers               bool              `json:"triggers"`
+	Routines               bool              `json:"routines"`
+	Events                 bool              `json:"events"`
+	DumpGrants             bool              `json:"dump_grants"`
+	Include                string            `json:"include,omitempty"`
+	Exclude                string            `json:"exclude,omitempty"`
+	ExcludeDatabases       string            `json:"exclude_databases,omitempty"`
+	NoData                 bool              `json:"no_data"`
+	NoCreateInfo           bool              `json:"no_create_info"`
+	GrantsUsers            string            `json:"grants_users,omitempty"`
+	Compress               bool              `json:"compress"`
+	CompressAlgorithm      string            `json:"compress_algorithm,omitempty"`
+	CompressLevel          int               `json:"compress_level,omitempty"`
+	Encrypt                bool              `json:"encrypt"`
+	RowChecksum            bool              `json:"row_checksum"`
+	Where                  string            `json:"where,omitempty"`
+	WhereConditions        map[string]string `json:"where_conditions,omitempty"`
+}
+
+// MasterStatus is the binary log position of the server when the dump
+// started.
+type MasterStatus struct {
+	File            string `json:"file"`
+	Position        uint64 `json:"position"`
+	BinlogDoDB      string `json:"binlog_do_db,omitempty"`
+	BinlogIgnoreDB  string `json:"binlog_ignore_db,omitempty"`
+	ExecutedGTIDSet string `json:"executed_gtid_set,omitempty"`
+}
+
+// SlaveStatus is the position of a replication channel when the dump started.
+type SlaveStatus struct {
+	ConnectionName     string `json:"connection_name,omitempty"`
+	MasterHost         string `json:"master_host"`
+	MasterPort         uint64 `json:"master_port"`
+	RelayMasterLogFile string `json:"relay_master_log_file"`
+	ExecMasterLogPos   uint64 `json:"exec_master_log_pos"`
+	ExecutedGTIDSet    string `json:"executed_gtid_set,omitempty"`
+	GTIDSlavePos       string `json:"gtid_slave_pos,omitempty"`
+}
+
+// DumpFile is a file written in the dump. SHA256 is the checksum of the
+// file as it is stored, DataSHA256 the checksum of the data before the
+// compression and the encryption.
+type DumpFile struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Bytes      uint64 `json:"bytes"`
+	SHA256     string `json:"sha256"`
+	DataSHA256 string `json:"data_sha256"`
+}
+
+func newDumpFile(fileType string, buffer *Buffer) *DumpFile {
+	return &DumpFile{Name: buffer.FileName, Type: fileType, Bytes: buffer.Size(),
+		SHA256: buffer.Checksum(), DataSHA256: buffer.DataChecksum()}
+}
+
+// ManifestTable is the information of a table of the dump.
+type ManifestTable struct {
+	Schema      string        `json:"schema"`
+	Name        string        `json:"name"`
+	Engine      string        `json:"engine"`
+	Collation   string        `json:"collation"`
+	Chunks      uint64        `json:"chunks"`
+	Rows        uint64        `json:"rows"`
+	Bytes       uint64        `json:"bytes"`
+	RowChecksum string        `json:"row_checksum,omitempty"`
+	Key         []string      `json:"key,omitempty"`
+	ChunkRanges []*ChunkRange `json:"chunk_ranges"`
+	Files       []*DumpFile   `json:"files"`
+}
+
+// ChunkRange is the key range of a chunk and the rows dumped from it. The
+// range starts on Min, included, and ends on Max, excluded. The last chunk
+// doesn't have Max and a single chunk has the whole table.
+type ChunkRange struct {
+	Sequence    uint64   `json:"sequence"`
+	Min         ChunkKey `json:"min,omitempty"`
+	Max         ChunkKey `json:"max,omitempty"`
+	Single      bool     `json:"single,omitempty"`
+	Last        bool     `json:"last,omitempty"`
+	Rows        uint64   `json:"rows"`
+	RowChecksum string   `json:"row_checksum,omitempty"`
+}
+
+// ChunkKey is the value of the key where a chunk starts or ends, as returned
+// by the driver. In JSON the values are numbers or strings, and the values
+// that are not valid UTF-8 are objects with the value in hex.
+type ChunkKey []interface{}
+
+type chunkKeyHex struct {
+	Hex string `json:"hex"`
+}
+
+// MarshalJSON encodes the key values.
+func (k ChunkKey) MarshalJSON() ([]byte, error) {
+	values := make([]interface{}, len(k))
+	for i, value := range k {
+		switch v := value.(type) {
+		case []byte:
+			if utf8.Valid(v) {
+				values[i] = string(v)
+			} else {
+				values[i] = chunkKeyHex{Hex: hex.EncodeToString(v)}
+			}
+		default:
+			values[i] = v
+		}
+	}
+	return json.Marshal(values)
+}
+
+// UnmarshalJSON decodes the key values. The strings are decoded as []byte,
+// like the driver returns them, and the numbers are kept as strings, the
+// server converts them when they are compared with the key.
+func (k *ChunkKey) UnmarshalJSON(data []byte) error {
+	var values []json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	key := make(ChunkKey, len(values))
+	for i, raw := range values {
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.UseNumber()
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil {
+			return err
+		}
+		switch v := value.(type) {
+		case json.Number:
+			key[i] = v.String()
+		case string:
+			key[i] = []byte(v)
+		case map[string]interface{}:
+			text, _ := v["hex"].(string)
+			binary, err := hex.DecodeString(text)
+			if err != nil {
+				return fmt.Errorf("invalid key value %s", raw)
+			}
+			key[i] = binary
+		default:
+			key[i] = v
+		}
+	}
+	*k = key
+	return nil
+}
+
+func newManifestOptions(do *DumpOptions) *ManifestOptions {
+	options := &ManifestOptions{
+		MySQLHost:              do.MySQLHost.HostName,
+		MySQLPort:              do.MySQLHost.Port,
+		MySQLSocket:            do.MySQLHost.SocketFile,
+		MySQLUser:              do.MySQLCredentials.User,
+		Destination:            do.DestinationDir,
+		Databases:              do.TemporalOptions.Databases,
+		Tables:                 do.TemporalOptions.Tables,
+		AllDatabases:           do.TemporalOptions.AllDatabases,
+		Threads:                do.Threads,
+		ChunkSize:              do.ChunkSize,
+		OutputChunkSize:        do.OutputChunkSize,
+		MaxStatementBytes:      do.MaxStatementBytes,
+		LockTables:             do.LockTables,
+		Consistent:             do.Consistent,
+		IsolationLevel:         do.TemporalOptions.IsolationLevel,
+		TablesWithoutUniqueKey: do.TablesWithoutUKOption,
+		AddDropTable:           do.AddDropTable,
+		SkipUseDatabase:        do.SkipUseDatabase,
+		GetMasterStatus:        do.GetMasterStatus,
+		GetSlaveStatus:         do.GetSlaveStatus,
+		OutputFormat:           do.OutputFormat,
+		Compress:               do.Compress,
+		Encrypt:                do.EncryptionKey != nil,
+		RowChecksum:            do.RowChecksum,
+		ChunksPerFile:          do.ChunksPerFile,
+		Views:                  do.Views,
+		Triggers:               do.Triggers,
+		Routines:               do.Routines,
+		Events:                 do.Events,
+		DumpGrants:             do.DumpGrants,
+		Include:                do.Include,
+		Exclude:                do.Exclude,
+		ExcludeDatabases:       do.ExcludeDatabases,
+		NoData:                 do.NoData,
+		NoCreateInfo:           do.NoCreateInfo,
+		GrantsUsers:            do.GrantsUsers,
+		Where:                  do.GlobalWhereCondition,
+		WhereConditions:        do.WhereConditions,
+	}
+	if IsDelimitedFormat(do.OutputFormat) {
+		options.CSVDelimiter = do.CSVDelimiter
+		options.CSVQuote = do.CSVQuote
+		options.CSVNull = do.CSVNull
+	}
+	if do.Compress {
+		options.CompressAlgorithm = do.CompressAlgorithm
+		options.CompressLevel = do.CompressLevel
+	}
+	return options
+}
+
+// GetManifest return the manifest of the dump with the information collected
+// by the tasks.
+func (tm *TaskManager) GetManifest(version string, serverVersion string, startTime time.Time) *Manifest {
+	manifest := &Manifest{
+		Version:         version,
+		ServerVersion:   serverVersion,
+		StartTime:       startTime,
+		EndTime:         time.Now(),
+		Options:         newManifestOptions(tm.DumpOptions),
+		MasterStatus:    tm.masterStatus,
+		SlaveStatus:     tm.slaveStatus,
+		Files:           tm.GetFiles(),
+		Tables:          []*ManifestTable{},
+		Consistent:      tm.IsConsistent(),
+		InterruptedRuns: tm.interruptedRuns,
+	}
+	if tm.EncryptionKey != nil {
+		manifest.Encryption = tm.EncryptionKey.GetInfo()
+	}
+
+	for _, task := range tm.tasksPool {
+		table := &ManifestTable{
+			Schema:    task.Table.GetUnescapedSchema(),
+			Name:      task.Table.GetUnescapedName(),
+			Engine:    task.Table.Engine,
+			Collation: task.Table.Collation,
+			Chunks:    task.TotalChunks,
+			Rows:      task.GetRows(),
+			Files:     task.GetFiles(),
+		}
+		if tm.DumpOptions.RowChecksum {
+			table.RowChecksum = FormatRowChecksum(task.GetRowChecksum())
+		}
+		table.Key = task.Table.GetKeyForChunks()
+		table.ChunkRanges = task.GetChunkRanges()
+		for _, file := range table.Files {
+			table.Bytes += file.Bytes
+		}
+		manifest.Tables = append(manifest.Tables, table)
+	}
+	return manifest
+}
+
+// ReadManifest reads the manifest of the dump in a directory.
+func ReadManifest(dir string) (*Manifest, error) {
+	content, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	manifest := new(Manifest)
+	if err := json.Unmarshal(content, manifest); err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", ManifestFile, err)
+	}
+	return manifest, nil
+}
+
+// WriteManifest writes manifest.json and return the manifest written. It's
+// not encrypted, so it can be read without the key.
+func (tm *TaskManager) WriteManifest(version string, startTime time.Time) (*Manifest, error) {
+	var serverVersion string
+	if err := tm.DB.QueryRow("SELECT VERSION()").Scan(&serverVersion); err != nil {
+		log.Warningf("Error getting the server version: %s", err.Error())
+	}
+
+	buffer, err := NewSinkBuffer(tm.Sink, ManifestFile, "", 0, nil)
+	if err != nil {
+		return nil, newError(ErrIO, "error creating %s: %w", ManifestFile, err)
+	}
+	manifest := tm.GetManifest(version, serverVersion, startTime)
+	encoder := json.NewEncoder(buffer)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		buffer.Abort()
+		return nil, newError(ErrIO, "error writing %s: %w", ManifestFile, err)
+	}
+	if err := buffer.Close(); err != nil {
+		return nil, newError(ErrIO, "error writing %s: %w", ManifestFile, err)
+	}
+	return manifest, nil
+}
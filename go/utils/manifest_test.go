@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetManifest(t *testing.T) {
+	sink := &memorySink{objects: map[string]*bytes.Buffer{}, closed: map[string]bool{}}
+	options := getDumpOptions()
+	options.MySQLCredentials = &MySQLCredentials{User: "dump", Password: "s3cr3t"}
+	options.EncryptionOptions = &EncryptionOptions{Passphrase: "correct horse battery staple"}
+	key, err := options.EncryptionOptions.NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	options.EncryptionKey = key
+	options.Sink = sink
+
+	tm := &TaskManager{DumpOptions: options, Sink: sink, EncryptionKey: key}
+	task := &Task{Table: &Table{schema: "sakila", name: "city", Engine: "InnoDB", Collation: "utf8mb4_general_ci"},
+		TaskManager: tm, TotalChunks: 2}
+	tm.AddTask(task)
+
+	for i, name := range []string{"sakila.city-thread1.sql", "sakila.city-thread0.sql"} {
+		buffer, err := NewSinkBuffer(sink, name, "", 0, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buffer.Write(bytes.Repeat([]byte("x"), 10*(i+1)))
+		buffer.Close()
+		task.AddFile(RestoreFileData, buffer)
+	}
+	task.AddRows(600)
+	task.AddRows(0)
+	tm.masterStatus = &MasterStatus{File: "binlog.000002", Position: 157}
+
+	start := time.Now()
+	manifest := tm.GetManifest("0.01", "8.0.36", start)
+
+	if manifest.Encryption == nil || manifest.Encryption.KeyID != key.ID {
+		t.Fatalf("Unexpected encryption info %+v", manifest.Encryption)
+	}
+	if len(manifest.Tables) != 1 {
+		t.Fatalf("Unexpected tables %+v", manifest.Tables)
+	}
+	table := manifest.Tables[0]
+	if table.Schema != "sakila" || table.Name != "city" || table.Engine != "InnoDB" ||
+		table.Chunks != 2 || table.Rows != 600 || table.Bytes != 30 {
+		t.Fatalf("Unexpected table %+v", table)
+	}
+	if len(table.Files) != 2 || table.Files[0].Name != "sakila.city-thread0.sql" || table.Files[0].Bytes != 20 {
+		t.Fatalf("Unexpected files %+v %+v", table.Files[0], table.Files[1])
+	}
+	data := sha256.Sum256(bytes.Repeat([]byte("x"), 20))
+	if table.Files[0].SHA256 != hex.EncodeToString(data[:]) || table.Files[0].DataSHA256 != table.Files[0].SHA256 {
+		t.Fatalf("Unexpected checksums %+v", table.Files[0])
+	}
+	if table.RowChecksum != "" {
+		t.Fatalf("Unexpected row checksum %s", table.RowChecksum)
+	}
+
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"s3cr3t", "correct horse", string(key.Key)} {
+		if strings.Contains(string(content), secret) {
+			t.Fatalf("The manifest contains the secret %q", secret)
+		}
+	}
+	if !strings.Contains(string(content), `"file":"binlog.000002","position":157`) {
+		t.Fatalf("The manifest doesn't have the master status: %s", content)
+	}
+
+	// A resumed dump is consistent only if the binary log didn't change.
+	tm.DumpOptions.Consistent = true
+	tm.interruptedRuns = []*DumpRun{{MasterStatus: &MasterStatus{File: "binlog.000002", Position: 157}}}
+	if manifest := tm.GetManifest("0.01", "8.0.36", start); !manifest.Consistent {
+		t.Fatal("The dump should be consistent")
+	}
+	tm.interruptedRuns = append(tm.interruptedRuns, &DumpRun{})
+	if manifest := tm.GetManifest("0.01", "8.0.36", start); manifest.Consistent || len(manifest.InterruptedRuns) != 2 {
+		t.Fatalf("Unexpected manifest %+v", manifest)
+	}
+}
@@ -0,0 +1,154 @@
+package utils
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metricsNamespace is the prefix of the names of the metrics.
+const metricsNamespace = "godump"
+
+// Metrics are the Prometheus metrics of the dumps. The counters add up the
+// dumps that use the same Metrics. The methods do nothing on a nil Metrics,
+// so the dumps without metrics don't need to check it.
+type Metrics struct {
+	chunksQueued  *prometheus.CounterVec
+	chunksDone    *prometheus.CounterVec
+	chunksInQueue prometheus.Gauge
+	rows          *prometheus.CounterVec
+	dataBytes     *prometheus.CounterVec
+	fileBytes     *prometheus.CounterVec
+	workerBusy    *prometheus.CounterVec
+	queryDuration prometheus.Histogram
+	lockHold      prometheus.Gauge
+	errors        *prometheus.CounterVec
+}
+
+// NewMetrics creates the metrics of the dumps and registers them.
+func NewMetrics(registerer prometheus.Registerer) (*Metrics, error) {
+	tableLabels := []string{"schema", "table"}
+	m := &Metrics{
+		chunksQueued: prometheus.NewCounterVec(prometheus.CounterOpts{Namespace: metricsNamespace,
+			Name: "chunks_queued_total", Help: "Chunks queued for the workers."}, tableLabels),
+		chunksDone: prometheus.NewCounterVec(prometheus.CounterOpts{Namespace: metricsNamespace,
+			Name: "chunks_done_total", Help: "Chunks dumped by the workers."}, tableLabels),
+		chunksInQueue: prometheus.NewGauge(prometheus.GaugeOpts{Namespace: metricsNamespace,
+			Name: "chunks_in_queue", Help: "Chunks waiting for a worker."}),
+		rows: prometheus.NewCounterVec(prometheus.CounterOpts{Namespace: metricsNamespace,
+			Name: "rows_total", Help: "Rows written."}, tableLabels),
+		dataBytes: prometheus.NewCounterVec(prometheus.CounterOpts{Namespace: metricsNamespace,
+			Name: "data_bytes_total", Help: "Bytes of data written, before the compression and the encryption."},
+			tableLabels),
+		fileBytes: prometheus.NewCounterVec(prometheus.CounterOpts{Namespace: metricsNamespace,
+			Name: "file_bytes_total", Help: "Bytes of the data files written to the destination, when they are closed."},
+			tableLabels),
+		workerBusy: prometheus.NewCounterVec(prometheus.CounterOpts{Namespace: metricsNamespace,
+			Name: "worker_busy_seconds_total", Help: "Time the workers spent dumping chunks."}, []string{"worker"}),
+		queryDuration: prometheus.NewHistogram(prometheus.HistogramOpts{Namespace: metricsNamespace,
+			Name: "chunk_query_duration_seconds", Help: "Latency of the queries of the chunks, until the first row.",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 14)}),
+		lockHold: prometheus.NewGauge(prometheus.GaugeOpts{Namespace: metricsNamespace,
+			Name: "lock_hold_seconds", Help: "Time the tables were locked to start the transactions of the last dump."}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{Namespace: metricsNamespace,
+			Name: "errors_total", Help: "Errors that stopped a dump, by kind."}, []string{"kind"}),
+	}
+	for _, collector := range []prometheus.Collector{m.chunksQueued, m.chunksDone, m.chunksInQueue, m.rows,
+		m.dataBytes, m.fileBytes, m.workerBusy, m.queryDuration, m.lockHold, m.errors} {
+		if err := registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// chunkQueued counts a chunk of the table queued for the workers.
+func (m *Metrics) chunkQueued(table *Table) {
+	if m == nil {
+		return
+	}
+	m.chunksQueued.WithLabelValues(table.GetUnescapedSchema(), table.GetUnescapedName()).Inc()
+}
+
+// addToQueue changes the number of chunks waiting for a worker.
+func (m *Metrics) addToQueue(chunks int) {
+	if m == nil {
+		return
+	}
+	m.chunksInQueue.Add(float64(chunks))
+}
+
+// chunkDone counts a chunk dumped by a worker, with its rows and bytes of
+// data, and the time the worker spent on it.
+func (m *Metrics) chunkDone(table *Table, workerId int, rows uint64, bytes uint64, busy time.Duration) {
+	if m == nil {
+		return
+	}
+	schema, name := table.GetUnescapedSchema(), table.GetUnescapedName()
+	m.chunksDone.WithLabelValues(schema, name).Inc()
+	m.rows.WithLabelValues(schema, name).Add(float64(rows))
+	m.dataBytes.WithLabelValues(schema, name).Add(float64(bytes))
+	m.workerBusy.WithLabelValues(strconv.Itoa(workerId)).Add(busy.Seconds())
+}
+
+// fileClosed counts the bytes of a data file of the table once it's closed:
+// the data left in the buffers and the size of the file.
+func (m *Metrics) fileClosed(table *Table, dataBytes uint64, fileBytes uint64) {
+	if m == nil {
+		return
+	}
+	schema, name := table.GetUnescapedSchema(), table.GetUnescapedName()
+	m.dataBytes.WithLabelValues(schema, name).Add(float64(dataBytes))
+	m.fileBytes.WithLabelValues(schema, name).Add(float64(fileBytes))
+}
+
+// observeQuery records the latency of the query of a chunk.
+func (m *Metrics) observeQuery(latency time.Duration) {
+	if m == nil {
+		return
+	}
+	m.queryDuration.Observe(latency.Seconds())
+}
+
+// setLockHold records the time the tables were locked.
+func (m *Metrics) setLockHold(locked time.Duration) {
+	if m == nil {
+		return
+	}
+	m.lockHold.Set(locked.Seconds())
+}
+
+// failed counts the error that stopped a dump.
+func (m *Metrics) failed(err error) {
+	if m == nil {
+		return
+	}
+	m.errors.WithLabelValues(errorKindLabel(err)).Inc()
+}
+
+// errorKindLabel return the name of the kind of the error for the labels.
+func errorKindLabel(err error) string {
+	switch kind := ErrorKind(err); {
+	case errors.Is(kind, ErrConnection):
+		return "connection"
+	case errors.Is(kind, ErrSchema):
+		return "schema"
+	case errors.Is(kind, ErrData):
+		return "data"
+	case errors.Is(kind, ErrIO):
+		return "io"
+	case errors.Is(kind, ErrInterrupted):
+		return "interrupted"
+	}
+	return "other"
+}
+
+// metrics return the metrics of the dump, nil without them.
+func (tm *TaskManager) metrics() *Metrics {
+	if tm.DumpOptions == nil {
+		return nil
+	}
+	return tm.DumpOptions.Metrics
+}
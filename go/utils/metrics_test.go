@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics, err := NewMetrics(registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewMetrics(registry); err == nil {
+		t.Error("Expected an error registering the metrics twice")
+	}
+
+	table := &Table{schema: "sakila", name: "city"}
+	metrics.addToQueue(2)
+	metrics.chunkQueued(table)
+	metrics.chunkQueued(table)
+	metrics.addToQueue(-1)
+	metrics.chunkDone(table, 1, 100, 2048, 2*time.Second)
+	metrics.fileClosed(table, 10, 512)
+	metrics.observeQuery(20 * time.Millisecond)
+	metrics.setLockHold(time.Second)
+	metrics.failed(newError(ErrData, "error dumping the chunk"))
+	metrics.failed(errors.New("unknown"))
+
+	for _, test := range []struct {
+		name      string
+		collector prometheus.Collector
+		expect    float64
+	}{
+		{"chunks queued", metrics.chunksQueued.WithLabelValues("sakila", "city"), 2},
+		{"chunks done", metrics.chunksDone.WithLabelValues("sakila", "city"), 1},
+		{"chunks in queue", metrics.chunksInQueue, 1},
+		{"rows", metrics.rows.WithLabelValues("sakila", "city"), 100},
+		{"data bytes", metrics.dataBytes.WithLabelValues("sakila", "city"), 2058},
+		{"file bytes", metrics.fileBytes.WithLabelValues("sakila", "city"), 512},
+		{"worker busy", metrics.workerBusy.WithLabelValues("1"), 2},
+		{"lock hold", metrics.lockHold, 1},
+		{"data errors", metrics.errors.WithLabelValues("data"), 1},
+		{"other errors", metrics.errors.WithLabelValues("other"), 1},
+	} {
+		if got := testutil.ToFloat64(test.collector); got != test.expect {
+			t.Errorf("Unexpected %s %f, expected %f", test.name, got, test.expect)
+		}
+	}
+	if count := testutil.CollectAndCount(metrics.queryDuration); count != 1 {
+		t.Errorf("Unexpected query latencies %d", count)
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	var metrics *Metrics
+	table := &Table{schema: "sakila", name: "city"}
+	metrics.addToQueue(1)
+	metrics.chunkQueued(table)
+	metrics.chunkDone(table, 0, 1, 1, time.Second)
+	metrics.fileClosed(table, 1, 1)
+	metrics.observeQuery(time.Second)
+	metrics.setLockHold(time.Second)
+	metrics.failed(errors.New("error"))
+	if (&TaskManager{}).metrics() != nil {
+		t.Error("A task manager without options should not have metrics")
+	}
+}
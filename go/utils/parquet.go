@@ -0,0 +1,335 @@
+package utils
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+	parquetgzip "github.com/parquet-go/parquet-go/compress/gzip"
+	parquetlz4 "github.com/parquet-go/parquet-go/compress/lz4"
+	parquetzstd "github.com/parquet-go/parquet-go/compress/zstd"
+
+	"github.com/ChaosHour/go-dump/go/log"
+)
+
+// parquetRowsBatch is the number of rows sent together to the parquet writer.
+const parquetRowsBatch = 1024
+
+// errInvalidDate is returned for the dates that parquet can't store, like
+// 0000-00-00. They are written as NULL.
+var errInvalidDate = errors.New("invalid date")
+
+// getParquetNode return the parquet type for a column. All the columns are
+// optional because invalid dates, like 0000-00-00, are written as NULL.
+func getParquetNode(c *Column) parquet.Node {
+	var node parquet.Node
+	switch c.DataType {
+	case "tinyint", "smallint", "mediumint", "int", "integer":
+		if c.IsUnsigned() {
+			node = parquet.Uint(32)
+		} else {
+			node = parquet.Int(32)
+		}
+	case "bigint":
+		if c.IsUnsigned() {
+			node = parquet.Uint(64)
+		} else {
+			node = parquet.Int(64)
+		}
+	case "year":
+		node = parquet.Int(32)
+	case "float":
+		node = parquet.Leaf(parquet.FloatType)
+	case "double", "real":
+		node = parquet.Leaf(parquet.DoubleType)
+	case "decimal", "numeric":
+		node = parquet.Decimal(c.Scale, c.Precision, getDecimalType(c.Precision))
+	case "date":
+		node = parquet.Date()
+	case "datetime", "timestamp":
+		node = parquet.TimestampAdjusted(parquet.Microsecond, false)
+	case "json":
+		node = parquet.JSON()
+	default:
+		if isBinaryType(c.DataType) {
+			node = parquet.Leaf(parquet.ByteArrayType)
+		} else {
+			node = parquet.String()
+		}
+	}
+	return parquet.Optional(node)
+}
+
+// getDecimalType return the physical type used for a decimal with the
+// precision.
+func getDecimalType(precision int) parquet.Type {
+	switch {
+	case precision <= 9:
+		return parquet.Int32Type
+	case precision <= 18:
+		return parquet.Int64Type
+	}
+	return parquet.FixedLenByteArrayType(getDecimalLength(precision))
+}
+
+// getDecimalLength return the number of bytes needed to store any unscaled
+// value of a decimal with the precision in two's complement.
+func getDecimalLength(precision int) int {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(precision)), nil)
+	return (max.BitLen() + 1 + 7) / 8
+}
+
+// GetParquetSchema return the parquet schema for the columns of a table.
+func GetParquetSchema(t *Table) (*parquet.Schema, error) {
+	if len(t.GetColumns()) == 0 {
+		return nil, fmt.Errorf("there is no column information for the table %s", t.GetFullName())
+	}
+	group := make(parquet.Group)
+	for _, column := range t.GetColumns() {
+		group[column.Name] = getParquetNode(column)
+	}
+	return parquet.NewSchema(t.GetUnescapedName(), group), nil
+}
+
+// getParquetCodec return the parquet codec for a compression algorithm. The
+// level goes from 1 to 9 as for the other formats.
+func getParquetCodec(algorithm string, level int) (compress.Codec, error) {
+	switch algorithm {
+	case CompressAlgorithmGzip:
+		return &parquetgzip.Codec{Level: level}, nil
+	case CompressAlgorithmZstd:
+		return &parquetzstd.Codec{Level: zstd.EncoderLevelFromZstd(level)}, nil
+	case CompressAlgorithmLz4:
+		if level <= 1 {
+			return &parquetlz4.Codec{Level: parquetlz4.Fastest}, nil
+		}
+		return &parquetlz4.Codec{Level: getLz4Level(level)}, nil
+	}
+	return nil, fmt.Errorf("unknown compression algorithm %s", algorithm)
+}
+
+// NewParquetBuffer creates a buffer that writes a parquet file with the
+// schema of the table to a new object of the sink. The compression is done by
+// the parquet writer for each page, so the file doesn't get the suffix of the
+// compression algorithm. An empty algorithm means no compression. The file
+// is encrypted when there is an encryption key.
+func NewParquetBuffer(sink Sink, name string, table *Table, compressAlgorithm string, compressLevel int,
+	encryptionKey *EncryptionKey) (*Buffer, error) {
+	schema, err := GetParquetSchema(table)
+	if err != nil {
+		return nil, err
+	}
+
+	options := []parquet.WriterOption{schema}
+	if compressAlgorithm != "" {
+		codec, err := getParquetCodec(compressAlgorithm, compressLevel)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, parquet.Compression(codec))
+	}
+
+	buffer, writer, err := newSinkObject(sink, name, encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	buffer.Type = BufferTypeParquetFile
+	buffer.data = newChecksumWriter(writer)
+	buffer.Buffer = bufio.NewWriter(buffer.data)
+	buffer.ParquetWriter = parquet.NewWriter(buffer.Buffer, options...)
+	return buffer, nil
+}
+
+// parquetRowWriter writes the rows of a chunk in a parquet file. The rows of
+// each chunk are written in their own row group.
+type parquetRowWriter struct {
+	writer       *parquet.Writer
+	table        *Table
+	columns      []*Column
+	indexes      []int
+	rows         []parquet.Row
+	invalidDates []uint64 // invalid dates written as NULL for each column
+}
+
+func newParquetRowWriter(buffer *Buffer, table *Table) (*parquetRowWriter, error) {
+	if buffer.ParquetWriter == nil {
+		return nil, fmt.Errorf("the buffer for %s is not a parquet file", table.GetFullName())
+	}
+	pw := &parquetRowWriter{writer: buffer.ParquetWriter, table: table, columns: table.GetColumns()}
+	pw.invalidDates = make([]uint64, len(pw.columns))
+	schema := buffer.ParquetWriter.Schema()
+	for _, column := range pw.columns {
+		leaf, ok := schema.Lookup(column.Name)
+		if !ok {
+			return nil, fmt.Errorf("column %s not found in the parquet schema", column.Name)
+		}
+		pw.indexes = append(pw.indexes, leaf.ColumnIndex)
+	}
+	return pw, nil
+}
+
+func (pw *parquetRowWriter) WriteRow(values []interface{}) error {
+	if len(values) != len(pw.columns) {
+		return fmt.Errorf("got %d values and the table has %d columns", len(values), len(pw.columns))
+	}
+	row := make(parquet.Row, len(values))
+	for i, value := range values {
+		v, err := getParquetValue(pw.columns[i], value)
+		if errors.Is(err, errInvalidDate) {
+			pw.invalidDates[i]++
+		} else if err != nil {
+			return fmt.Errorf("column %s: %v", pw.columns[i].Name, err)
+		}
+		definitionLevel := 1
+		if v.IsNull() {
+			definitionLevel = 0
+		}
+		row[pw.indexes[i]] = v.Level(0, definitionLevel, pw.indexes[i])
+	}
+	pw.rows = append(pw.rows, row)
+	if len(pw.rows) >= parquetRowsBatch {
+		return pw.writeRows()
+	}
+	return nil
+}
+
+func (pw *parquetRowWriter) writeRows() error {
+	if len(pw.rows) == 0 {
+		return nil
+	}
+	_, err := pw.writer.WriteRows(pw.rows)
+	pw.rows = pw.rows[:0]
+	return err
+}
+
+// Close writes the pending rows and ends the row group of the chunk. It
+// warns about the invalid dates written as NULL, also in the NOT NULL
+// columns.
+func (pw *parquetRowWriter) Close() error {
+	for i, count := range pw.invalidDates {
+		if count > 0 {
+			log.With("table", pw.table.GetFullName(), "column", pw.columns[i].Name, "rows", count).
+				Warningf("%d invalid dates of the column %s of %s were written as NULL in the parquet file",
+					count, pw.columns[i].Name, pw.table.GetFullName())
+		}
+	}
+	if err := pw.writeRows(); err != nil {
+		return err
+	}
+	return pw.writer.Flush()
+}
+
+// getParquetValue converts a value returned by the driver to the parquet
+// type of the column. The invalid dates return a NULL value with
+// errInvalidDate.
+func getParquetValue(c *Column, value interface{}) (parquet.Value, error) {
+	if value == nil {
+		return parquet.NullValue(), nil
+	}
+	switch c.DataType {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "year":
+		n, err := toInt64(value)
+		return parquet.Int32Value(int32(n)), err
+	case "bigint":
+		n, err := toInt64(value)
+		return parquet.Int64Value(n), err
+	case "float":
+		f, err := toFloat64(value)
+		return parquet.FloatValue(float32(f)), err
+	case "double", "real":
+		f, err := toFloat64(value)
+		return parquet.DoubleValue(f), err
+	case "decimal", "numeric":
+		return getDecimalValue(c, toString(value))
+	case "date":
+		date, err := time.Parse("2006-01-02", toString(value))
+		if err != nil {
+			return parquet.NullValue(), errInvalidDate
+		}
+		return parquet.Int32Value(int32(date.Unix() / 86400)), nil
+	case "datetime", "timestamp":
+		datetime, err := time.Parse("2006-01-02 15:04:05.999999", toString(value))
+		if err != nil {
+			return parquet.NullValue(), errInvalidDate
+		}
+		return parquet.Int64Value(datetime.UnixMicro()), nil
+	}
+	if b, ok := value.([]byte); ok {
+		return parquet.ByteArrayValue(b), nil
+	}
+	return parquet.ByteArrayValue([]byte(toString(value))), nil
+}
+
+// getDecimalValue converts a decimal returned as text to the unscaled value
+// of the parquet decimal.
+func getDecimalValue(c *Column, value string) (parquet.Value, error) {
+	digits := value
+	if i := strings.IndexByte(value, '.'); i >= 0 {
+		decimals := value[i+1:]
+		if len(decimals) < c.Scale {
+			decimals += strings.Repeat("0", c.Scale-len(decimals))
+		}
+		digits = value[:i] + decimals
+	} else {
+		digits += strings.Repeat("0", c.Scale)
+	}
+	unscaled, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		return parquet.Value{}, fmt.Errorf("invalid decimal value %s", value)
+	}
+
+	switch {
+	case c.Precision <= 9:
+		return parquet.Int32Value(int32(unscaled.Int64())), nil
+	case c.Precision <= 18:
+		return parquet.Int64Value(unscaled.Int64()), nil
+	}
+
+	// Big endian two's complement with the length of the column.
+	length := getDecimalLength(c.Precision)
+	if unscaled.Sign() < 0 {
+		unscaled.Add(unscaled, new(big.Int).Lsh(big.NewInt(1), uint(length*8)))
+	}
+	return parquet.FixedLenByteArrayValue(unscaled.FillBytes(make([]byte, length))), nil
+}
+
+func toString(value interface{}) string {
+	if b, ok := value.([]byte); ok {
+		return string(b)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+func toInt64(value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case int64:
+		return v, nil
+	case uint64:
+		return int64(v), nil
+	}
+	s := toString(value)
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	return int64(n), err
+}
+
+func toFloat64(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	}
+	return strconv.ParseFloat(toString(value), 64)
+}
@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ChaosHour/go-dump/go/log"
+)
+
+func TestGetParquetSchema(t *testing.T) {
+	table := &Table{
+		name:   "payment",
+		schema: "sakila",
+		columns: []*Column{
+			{Name: "payment_id", DataType: "smallint", ColumnType: "smallint unsigned"},
+			{Name: "amount", DataType: "decimal", ColumnType: "decimal(5,2)", Precision: 5, Scale: 2},
+			{Name: "payment_date", DataType: "datetime", ColumnType: "datetime"},
+			{Name: "day", DataType: "date", ColumnType: "date"},
+			{Name: "total", DataType: "bigint", ColumnType: "bigint"},
+			{Name: "note", DataType: "varchar", ColumnType: "varchar(10)"},
+			{Name: "hash", DataType: "varbinary", ColumnType: "varbinary(16)"},
+		},
+	}
+
+	expect := "message payment {\n" +
+		"\toptional int32 amount (DECIMAL(5,2));\n" +
+		"\toptional int32 day (DATE);\n" +
+		"\toptional binary hash;\n" +
+		"\toptional binary note (STRING);\n" +
+		"\toptional int64 payment_date (TIMESTAMP(isAdjustedToUTC=false,unit=MICROS));\n" +
+		"\toptional int32 payment_id (INT(32,false));\n" +
+		"\toptional int64 total (INT(64,true));\n" +
+		"}"
+
+	schema, err := GetParquetSchema(table)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	if schema.String() != expect {
+		t.Fatalf("Got \"%s\" and expected \"%s\"", schema.String(), expect)
+	}
+}
+
+func TestGetDecimalValue(t *testing.T) {
+	tests := []struct {
+		precision int
+		scale     int
+		value     string
+		expect    int64
+	}{
+		{5, 2, "2.99", 299},
+		{5, 2, "-0.5", -50},
+		{12, 3, "123456789.125", 123456789125},
+		{10, 0, "42", 42},
+	}
+
+	for _, tt := range tests {
+		v, err := getDecimalValue(&Column{Precision: tt.precision, Scale: tt.scale}, tt.value)
+		if err != nil {
+			t.Fatalf("Unexpected error %s", err.Error())
+		}
+		if v.Int64() != tt.expect {
+			t.Errorf("Got %d for %s and expected %d", v.Int64(), tt.value, tt.expect)
+		}
+	}
+
+	// Precision bigger than 18 is stored as big endian two's complement.
+	v, err := getDecimalValue(&Column{Precision: 20, Scale: 1}, "-1.5")
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err.Error())
+	}
+	expect := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xf1}
+	if !bytes.Equal(v.ByteArray(), expect) {
+		t.Fatalf("Got %X and expected %X", v.ByteArray(), expect)
+	}
+}
+
+func TestParquetInvalidDates(t *testing.T) {
+	table := &Table{
+		name:   "rental",
+		schema: "sakila",
+		columns: []*Column{
+			{Name: "rental_id", DataType: "int", ColumnType: "int"},
+			{Name: "rental_date", DataType: "datetime", ColumnType: "datetime"},
+			{Name: "return_date", DataType: "date", ColumnType: "date", IsNullable: true},
+		},
+	}
+	sink := &memorySink{objects: map[string]*bytes.Buffer{}, closed: map[string]bool{}}
+	buffer, err := NewParquetBuffer(sink, "sakila.rental-thread0.parquet", table, "", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, err := newParquetRowWriter(buffer, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var warnings bytes.Buffer
+	log.SetOutput(&warnings)
+	defer log.SetOutput(os.Stderr)
+	for _, row := range [][]interface{}{
+		{int64(1), []byte("0000-00-00 00:00:00"), []byte("2005-05-26")},
+		{int64(2), []byte("0000-00-00 00:00:00"), nil},
+		{int64(3), []byte("2005-05-24 22:53:30"), []byte("0000-00-00")},
+	} {
+		if err := writer.WriteRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, warning := range []string{
+		"2 invalid dates of the column rental_date of `sakila`.`rental` were written as NULL",
+		"1 invalid dates of the column return_date of `sakila`.`rental` were written as NULL",
+	} {
+		if !strings.Contains(warnings.String(), warning) {
+			t.Errorf("The log doesn't contain %q:\n%s", warning, warnings.String())
+		}
+	}
+}
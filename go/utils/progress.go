@@ -0,0 +1,300 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ChaosHour/go-dump/go/log"
+)
+
+// progressSmoothing is the weight of the last interval in the throughput,
+// so the ETA doesn't jump with each report.
+const progressSmoothing = 0.5
+
+// progressBarWidth is the number of characters of the bar of ProgressBar.
+const progressBarWidth = 30
+
+// TableProgress is the progress of the dump of a table.
+type TableProgress struct {
+	Schema         string
+	Name           string
+	Rows           uint64
+	EstimatedRows  uint64 // from the statistics of the server, or Rows once the table is done
+	Bytes          uint64 // bytes of data, before the compression and the encryption
+	EstimatedBytes uint64 // from the statistics of the server, or Bytes once the table is done
+	Chunks         uint64 // chunks created so far
+	ChunksDone     uint64
+	Done           bool // all the chunks are created and dumped
+}
+
+// FullName return the name of the table as schema.table.
+func (t *TableProgress) FullName() string {
+	return t.Schema + "." + t.Name
+}
+
+// Percent return the percentage of the estimated rows that are dumped.
+func (t *TableProgress) Percent() float64 {
+	return percent(t.Rows, t.EstimatedRows, t.Done)
+}
+
+// Progress is a report of the progress of a dump.
+type Progress struct {
+	Elapsed        time.Duration // since the reports started
+	Tables         []*TableProgress
+	TablesDone     int
+	Rows           uint64
+	EstimatedRows  uint64
+	Bytes          uint64
+	EstimatedBytes uint64
+	Chunks         uint64
+	ChunksDone     uint64
+	RowsPerSecond  float64       // throughput of the last intervals, or of the dump in the final report
+	BytesPerSecond float64       // throughput of the last intervals, or of the dump in the final report
+	ETA            time.Duration // estimated time to dump the rows left, 0 if unknown
+	Final          bool          // the last report, once the workers are finished
+}
+
+// Percent return the percentage of the estimated rows that are dumped.
+func (p *Progress) Percent() float64 {
+	return percent(p.Rows, p.EstimatedRows, p.TablesDone == len(p.Tables))
+}
+
+func percent(value, estimated uint64, done bool) float64 {
+	if done {
+		return 100
+	}
+	if estimated == 0 {
+		return 0
+	}
+	return 100 * float64(value) / float64(estimated)
+}
+
+// estimate return the expected total of a table: the estimate of the server
+// while it's bigger than the actual value and the table is not done.
+func estimate(estimated, actual uint64, done bool) uint64 {
+	if done || actual > estimated {
+		return actual
+	}
+	return estimated
+}
+
+// progress return the progress of the table.
+func (t *Task) progress() *TableProgress {
+	progress := &TableProgress{
+		Schema:     t.Table.GetUnescapedSchema(),
+		Name:       t.Table.GetUnescapedName(),
+		Rows:       t.GetRows(),
+		Bytes:      t.GetBytes(),
+		Chunks:     atomic.LoadUint64(&t.TotalChunks),
+		ChunksDone: atomic.LoadUint64(&t.chunksDone),
+	}
+	progress.Done = t.chunksPlanned.Load() && progress.ChunksDone >= progress.Chunks
+	progress.EstimatedRows = estimate(t.Table.GetEstimatedRows(), progress.Rows, progress.Done)
+	progress.EstimatedBytes = estimate(t.Table.GetEstimatedDataSize(), progress.Bytes, progress.Done)
+	return progress
+}
+
+// GetProgress return the progress of the tables of the dump. The throughput
+// and the ETA are computed by the ProgressReporter.
+func (tm *TaskManager) GetProgress() *Progress {
+	progress := new(Progress)
+	for _, task := range tm.tasksPool {
+		table := task.progress()
+		progress.Tables = append(progress.Tables, table)
+		if table.Done {
+			progress.TablesDone++
+		}
+		progress.Rows += table.Rows
+		progress.EstimatedRows += table.EstimatedRows
+		progress.Bytes += table.Bytes
+		progress.EstimatedBytes += table.EstimatedBytes
+		progress.Chunks += table.Chunks
+		progress.ChunksDone += table.ChunksDone
+	}
+	return progress
+}
+
+// ProgressReporter sends the progress of a dump to a function at regular
+// intervals, with the throughput and the ETA.
+type ProgressReporter struct {
+	taskManager *TaskManager
+	interval    time.Duration
+	report      func(*Progress)
+	start       time.Time
+	first       *Progress // at the start, with the rows of the resumed chunks
+	last        *Progress
+	lastTime    time.Time
+	rowsRate    float64
+	bytesRate   float64
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+// NewProgressReporter creates a reporter of the progress of the task
+// manager. The report function is called from the goroutine of the reporter.
+func NewProgressReporter(tm *TaskManager, interval time.Duration, report func(*Progress)) *ProgressReporter {
+	return &ProgressReporter{
+		taskManager: tm,
+		interval:    interval,
+		report:      report,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// Start sends a report every interval until Stop.
+func (r *ProgressReporter) Start() {
+	r.start = time.Now()
+	r.lastTime = r.start
+	r.first = r.taskManager.GetProgress()
+	r.last = r.first
+	go r.run()
+}
+
+func (r *ProgressReporter) run() {
+	defer close(r.done)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case now := <-ticker.C:
+			r.report(r.progress(now))
+		}
+	}
+}
+
+// Stop stops the reports and sends the final one, with the throughput of the
+// whole dump.
+func (r *ProgressReporter) Stop() {
+	close(r.stop)
+	<-r.done
+
+	progress := r.taskManager.GetProgress()
+	progress.Final = true
+	progress.Elapsed = time.Since(r.start)
+	if seconds := progress.Elapsed.Seconds(); seconds > 0 {
+		progress.RowsPerSecond = float64(progress.Rows-r.first.Rows) / seconds
+		progress.BytesPerSecond = float64(progress.Bytes-r.first.Bytes) / seconds
+	}
+	r.report(progress)
+}
+
+// progress return the progress with the throughput since the last report,
+// smoothed with the previous ones.
+func (r *ProgressReporter) progress(now time.Time) *Progress {
+	progress := r.taskManager.GetProgress()
+	progress.Elapsed = now.Sub(r.start)
+
+	if seconds := now.Sub(r.lastTime).Seconds(); seconds > 0 {
+		rowsRate := float64(progress.Rows-r.last.Rows) / seconds
+		bytesRate := float64(progress.Bytes-r.last.Bytes) / seconds
+		if r.last == r.first {
+			r.rowsRate, r.bytesRate = rowsRate, bytesRate
+		} else {
+			r.rowsRate = progressSmoothing*rowsRate + (1-progressSmoothing)*r.rowsRate
+			r.bytesRate = progressSmoothing*bytesRate + (1-progressSmoothing)*r.bytesRate
+		}
+	}
+	progress.RowsPerSecond = r.rowsRate
+	progress.BytesPerSecond = r.bytesRate
+	if r.rowsRate > 0 && progress.EstimatedRows > progress.Rows {
+		progress.ETA = time.Duration(float64(progress.EstimatedRows-progress.Rows) / r.rowsRate * float64(time.Second))
+	}
+
+	r.last = progress
+	r.lastTime = now
+	return progress
+}
+
+// FormatBytes return a size in bytes for humans, like "1.5 MiB".
+func FormatBytes(bytes uint64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := uint64(unit), 0
+	for n := bytes / unit; n >= unit && exp < 5; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+// formatETA return the ETA rounded to seconds, or "unknown".
+func formatETA(eta time.Duration) string {
+	if eta <= 0 {
+		return "unknown"
+	}
+	return eta.Round(time.Second).String()
+}
+
+// LogProgress logs a line with the progress of the dump, and one for each
+// table that is being dumped. It's the report of the dumps without another
+// one.
+func LogProgress(p *Progress) {
+	event := log.With("rows", p.Rows, "bytes", p.Bytes, "tables_done", p.TablesDone, "tables", len(p.Tables),
+		"rows_per_second", p.RowsPerSecond, "bytes_per_second", p.BytesPerSecond, "elapsed", p.Elapsed)
+	if p.Final {
+		event.Infof("Progress: dumped %d rows, %s of data, %d of %d tables in %s, %.0f rows/s, %s/s",
+			p.Rows, FormatBytes(p.Bytes), p.TablesDone, len(p.Tables), p.Elapsed.Round(time.Second),
+			p.RowsPerSecond, FormatBytes(uint64(p.BytesPerSecond)))
+		return
+	}
+	event.With("estimated_rows", p.EstimatedRows, "estimated_bytes", p.EstimatedBytes, "percent", p.Percent(),
+		"eta", p.ETA).Infof("Progress: %.1f%%, %d of ~%d rows, %s of ~%s, %d of %d tables, %.0f rows/s, %s/s, ETA %s",
+		p.Percent(), p.Rows, p.EstimatedRows, FormatBytes(p.Bytes), FormatBytes(p.EstimatedBytes),
+		p.TablesDone, len(p.Tables), p.RowsPerSecond, FormatBytes(uint64(p.BytesPerSecond)), formatETA(p.ETA))
+	for _, table := range p.Tables {
+		if table.Done || table.ChunksDone == 0 {
+			continue
+		}
+		log.With("table", table.FullName(), "rows", table.Rows, "estimated_rows", table.EstimatedRows,
+			"bytes", table.Bytes, "percent", table.Percent(), "chunks_done", table.ChunksDone, "chunks", table.Chunks).
+			Infof("Progress of %s: %.1f%%, %d of ~%d rows, %s, %d of %d chunks", table.FullName(),
+				table.Percent(), table.Rows, table.EstimatedRows, FormatBytes(table.Bytes), table.ChunksDone, table.Chunks)
+	}
+}
+
+// ProgressBar draws the progress of a dump in a single line of a terminal.
+// Its Update method is a report function for the ProgressReporter.
+type ProgressBar struct {
+	w       io.Writer
+	lastLen int
+}
+
+// NewProgressBar creates a progress bar that writes to w.
+func NewProgressBar(w io.Writer) *ProgressBar {
+	return &ProgressBar{w: w}
+}
+
+// Update draws the bar over the previous one. The final report ends the
+// line.
+func (b *ProgressBar) Update(p *Progress) {
+	filled := int(p.Percent() / 100 * progressBarWidth)
+	if filled > progressBarWidth {
+		filled = progressBarWidth
+	}
+	bar := strings.Repeat("#", filled) + strings.Repeat("-", progressBarWidth-filled)
+	line := fmt.Sprintf("[%s] %5.1f%% %d/~%d rows %s %.0f rows/s ETA %s", bar, p.Percent(),
+		p.Rows, p.EstimatedRows, FormatBytes(p.Bytes), p.RowsPerSecond, formatETA(p.ETA))
+	if p.Final {
+		line = fmt.Sprintf("[%s] %5.1f%% %d rows %s in %s", bar, p.Percent(),
+			p.Rows, FormatBytes(p.Bytes), p.Elapsed.Round(time.Second))
+	}
+
+	// The spaces clear the end of a longer line.
+	padding := ""
+	if len(line) < b.lastLen {
+		padding = strings.Repeat(" ", b.lastLen-len(line))
+	}
+	b.lastLen = len(line)
+	fmt.Fprint(b.w, "\r", line, padding)
+	if p.Final {
+		fmt.Fprintln(b.w)
+	}
+}
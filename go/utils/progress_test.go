@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newProgressTask(name string, estimatedRows uint64, rows uint64, chunks uint64, chunksDone uint64,
+	planned bool) *Task {
+	task := &Task{Table: &Table{schema: "sakila", name: name, estNumberOfRows: estimatedRows,
+		estDataSize: estimatedRows * 100}, TotalChunks: chunks, rows: rows, bytes: rows * 50, chunksDone: chunksDone}
+	task.chunksPlanned.Store(planned)
+	return task
+}
+
+func TestGetProgress(t *testing.T) {
+	tm := &TaskManager{tasksPool: []*Task{
+		newProgressTask("actor", 200, 200, 2, 2, true),
+		newProgressTask("city", 600, 300, 3, 1, false),
+		newProgressTask("film", 100, 150, 2, 1, true), // more rows than the estimate
+	}}
+	progress := tm.GetProgress()
+	if len(progress.Tables) != 3 || progress.TablesDone != 1 {
+		t.Fatalf("Unexpected tables %d, %d done", len(progress.Tables), progress.TablesDone)
+	}
+	if progress.Rows != 650 || progress.EstimatedRows != 950 || progress.Chunks != 7 || progress.ChunksDone != 4 {
+		t.Errorf("Unexpected progress %+v", progress)
+	}
+	if progress.Bytes != 32500 || progress.EstimatedBytes != 80000 {
+		t.Errorf("Unexpected bytes %d of %d", progress.Bytes, progress.EstimatedBytes)
+	}
+	if actor := progress.Tables[0]; !actor.Done || actor.Percent() != 100 || actor.FullName() != "sakila.actor" {
+		t.Errorf("Unexpected progress of actor %+v", actor)
+	}
+	if city := progress.Tables[1]; city.Done || city.Percent() != 50 {
+		t.Errorf("Unexpected progress of city %+v", city)
+	}
+}
+
+func TestProgressReporter(t *testing.T) {
+	task := newProgressTask("city", 600, 0, 6, 0, true)
+	tm := &TaskManager{tasksPool: []*Task{task}}
+	var reports []*Progress
+	reporter := NewProgressReporter(tm, time.Hour, func(progress *Progress) {
+		reports = append(reports, progress)
+	})
+	reporter.Start()
+
+	task.AddRows(100)
+	task.chunkDone(1000)
+	progress := reporter.progress(reporter.start.Add(time.Second))
+	if progress.RowsPerSecond != 100 || progress.BytesPerSecond != 1000 || progress.ETA != 5*time.Second {
+		t.Errorf("Unexpected throughput %f, %f and ETA %s", progress.RowsPerSecond, progress.BytesPerSecond, progress.ETA)
+	}
+	// The throughput is smoothed with the previous intervals.
+	task.AddRows(300)
+	task.chunkDone(3000)
+	progress = reporter.progress(reporter.start.Add(2 * time.Second))
+	if progress.RowsPerSecond != 200 || progress.ETA != time.Second {
+		t.Errorf("Unexpected throughput %f and ETA %s", progress.RowsPerSecond, progress.ETA)
+	}
+
+	reporter.Stop()
+	if len(reports) != 1 || !reports[0].Final || reports[0].Rows != 400 || reports[0].ETA != 0 {
+		t.Fatalf("Unexpected final report %+v", reports)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for bytes, expect := range map[uint64]string{
+		0:                "0 B",
+		1023:             "1023 B",
+		1536:             "1.5 KiB",
+		10 * 1024 * 1024: "10.0 MiB",
+		3 << 40:          "3.0 TiB",
+	} {
+		if got := FormatBytes(bytes); got != expect {
+			t.Errorf("FormatBytes(%d) = %s, expected %s", bytes, got, expect)
+		}
+	}
+}
+
+func TestProgressBar(t *testing.T) {
+	var out bytes.Buffer
+	bar := NewProgressBar(&out)
+	bar.Update(&Progress{Tables: make([]*TableProgress, 2), Rows: 500, EstimatedRows: 1000, Bytes: 2048,
+		RowsPerSecond: 100, ETA: 5 * time.Second})
+	expect := "\r[###############---------------]  50.0% 500/~1000 rows 2.0 KiB 100 rows/s ETA 5s"
+	if out.String() != expect {
+		t.Errorf("Unexpected bar %q", out.String())
+	}
+
+	out.Reset()
+	bar.Update(&Progress{Tables: make([]*TableProgress, 2), TablesDone: 2, Rows: 1000, EstimatedRows: 1000,
+		Bytes: 4096, Elapsed: 10 * time.Second, Final: true})
+	if line := out.String(); !strings.HasPrefix(line, "\r[##############################] 100.0% 1000 rows 4.0 KiB in 10s ") ||
+		!strings.HasSuffix(line, "\n") {
+		t.Errorf("Unexpected final bar %q", line)
+	}
+}
@@ -0,0 +1,206 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// FileReader reads a file written by a FileSink, decrypting it when the file
+// name has the ".enc" suffix and decompressing it when the file name has the
+// suffix of a compression algorithm, like ".gz" or ".zst".
+type FileReader struct {
+	reader         io.Reader
+	decompressor   io.ReadCloser
+	fileDescriptor *os.File
+}
+
+// Read reads the uncompressed content of the file.
+func (f *FileReader) Read(p []byte) (int, error) {
+	return f.reader.Read(p)
+}
+
+// Close closes the decompressor, if any, and the file.
+func (f *FileReader) Close() error {
+	if f.decompressor != nil {
+		if err := f.decompressor.Close(); err != nil {
+			f.fileDescriptor.Close()
+			return err
+		}
+	}
+	return f.fileDescriptor.Close()
+}
+
+// NewFileReader opens a dump file for reading. The encryption options are
+// only needed for the encrypted files, they can be nil.
+func NewFileReader(fileName string, encryption *EncryptionOptions) (*FileReader, error) {
+	fileDescriptor, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var reader io.Reader = fileDescriptor
+	if strings.HasSuffix(fileName, EncryptExtension) {
+		if encryption == nil {
+			fileDescriptor.Close()
+			return nil, errors.New("the file is encrypted and there is no key or passphrase")
+		}
+		decryptor, err := newDecryptReader(fileDescriptor, encryption)
+		if err != nil {
+			fileDescriptor.Close()
+			return nil, err
+		}
+		fileName = strings.TrimSuffix(fileName, EncryptExtension)
+		reader = decryptor
+	}
+
+	if algorithm := GetCompressAlgorithm(fileName); algorithm != "" {
+		decompressor, err := newDecompressReader(reader, algorithm)
+		if err != nil {
+			fileDescriptor.Close()
+			return nil, err
+		}
+		return &FileReader{reader: decompressor, decompressor: decompressor, fileDescriptor: fileDescriptor}, nil
+	}
+	return &FileReader{reader: reader, fileDescriptor: fileDescriptor}, nil
+}
+
+// StatementReader splits the content of a dump file in SQL statements.
+// It knows about quoted strings and comments, so a ';' inside them doesn't
+// end the statement, and about the "USE `schema`" lines that the workers
+// write without a delimiter.
+type StatementReader struct {
+	reader    *bufio.Reader
+	delimiter string // changed with the DELIMITER command of the mysql client
+}
+
+// NewStatementReader returns a StatementReader reading from r.
+func NewStatementReader(r io.Reader) *StatementReader {
+	return &StatementReader{reader: bufio.NewReaderSize(r, 1024*1024), delimiter: ";"}
+}
+
+// isUseStatement return true when the statement is a USE without delimiter.
+func isUseStatement(statement []byte) bool {
+	trimmed := bytes.TrimSpace(statement)
+	return len(trimmed) > 4 && strings.EqualFold(string(trimmed[:4]), "USE ")
+}
+
+// Next return the next statement without the delimiter. It returns io.EOF
+// when there are no more statements.
+func (sr *StatementReader) Next() (string, error) {
+	var statement []byte
+	var quote byte
+	inBlockComment := false
+
+	for {
+		b, err := sr.reader.ReadByte()
+		if err == io.EOF {
+			if s := strings.TrimSpace(string(statement)); len(s) > 0 {
+				return s, nil
+			}
+			return "", io.EOF
+		}
+		if err != nil {
+			return "", err
+		}
+
+		switch {
+		case quote != 0:
+			statement = append(statement, b)
+			if b == '\\' && quote != '`' {
+				next, err := sr.reader.ReadByte()
+				if err != nil {
+					return "", io.ErrUnexpectedEOF
+				}
+				statement = append(statement, next)
+			} else if b == quote {
+				quote = 0
+			}
+		case inBlockComment:
+			statement = append(statement, b)
+			if b == '*' {
+				if next, _ := sr.reader.Peek(1); len(next) == 1 && next[0] == '/' {
+					sr.reader.ReadByte()
+					statement = append(statement, '/')
+					inBlockComment = false
+				}
+			}
+		case b == '\'' || b == '"' || b == '`':
+			quote = b
+			statement = append(statement, b)
+		case b == '/':
+			statement = append(statement, b)
+			if next, _ := sr.reader.Peek(1); len(next) == 1 && next[0] == '*' {
+				sr.reader.ReadByte()
+				statement = append(statement, '*')
+				inBlockComment = true
+			}
+		case b == '#' || (b == '-' && sr.isLineComment()):
+			comment, err := sr.reader.ReadBytes('\n')
+			if err != nil && err != io.EOF {
+				return "", err
+			}
+			if isUseStatement(statement) {
+				return strings.TrimSpace(string(statement)), nil
+			}
+			// The comments in the body of the triggers and routines are kept.
+			if sr.delimiter != ";" && len(bytes.TrimSpace(statement)) > 0 {
+				statement = append(append(statement, b), comment...)
+			}
+		case (b == 'D' || b == 'd') && len(bytes.TrimSpace(statement)) == 0 && sr.isDelimiterCommand():
+			line, err := sr.reader.ReadString('\n')
+			if err != nil && err != io.EOF {
+				return "", err
+			}
+			delimiter := strings.TrimSpace(line[len("ELIMITER"):])
+			if delimiter == "" {
+				return "", errors.New("DELIMITER without a delimiter")
+			}
+			sr.delimiter = delimiter
+			statement = statement[:0]
+		case b == sr.delimiter[0] && sr.isDelimiter():
+			if s := strings.TrimSpace(string(statement)); len(s) > 0 {
+				return s, nil
+			}
+			statement = statement[:0]
+		case b == '\n' && isUseStatement(statement):
+			return strings.TrimSpace(string(statement)), nil
+		default:
+			statement = append(statement, b)
+		}
+	}
+}
+
+// isDelimiterCommand checks if the 'D' that was just read starts a
+// DELIMITER command.
+func (sr *StatementReader) isDelimiterCommand() bool {
+	next, _ := sr.reader.Peek(len("ELIMITER "))
+	return len(next) == len("ELIMITER ") && strings.EqualFold(string(next[:8]), "ELIMITER") &&
+		(next[8] == ' ' || next[8] == '\t')
+}
+
+// isDelimiter checks if the byte that was just read starts the delimiter,
+// and consumes the rest of the delimiter.
+func (sr *StatementReader) isDelimiter() bool {
+	if len(sr.delimiter) == 1 {
+		return true
+	}
+	next, _ := sr.reader.Peek(len(sr.delimiter) - 1)
+	if string(next) != sr.delimiter[1:] {
+		return false
+	}
+	sr.reader.Discard(len(next))
+	return true
+}
+
+// isLineComment checks if the '-' that was just read starts a "-- " comment.
+func (sr *StatementReader) isLineComment() bool {
+	next, _ := sr.reader.Peek(2)
+	if len(next) == 0 || next[0] != '-' {
+		return false
+	}
+	return len(next) == 1 || next[1] == ' ' || next[1] == '\t' || next[1] == '\n' || next[1] == '\r'
+}
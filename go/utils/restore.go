@@ -0,0 +1,465 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ChaosHour/go-dump/go/log"
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	RestoreFileDefinition = "definition"
+	RestoreFileData       = "data"
+	RestoreFileLoad       = "load"
+	RestoreFileRoutines   = "routines"
+	RestoreFileViews      = "views"
+	RestoreFileTriggers   = "triggers"
+	RestoreFileEvents     = "events"
+)
+
+// schemaObjectFileTypes are the types of the files with the objects of a
+// schema, named like "mydb-views.sql".
+var schemaObjectFileTypes = []string{RestoreFileRoutines, RestoreFileViews, RestoreFileTriggers, RestoreFileEvents}
+
+// RestoreOptions contains the options to load a dump back into a server.
+type RestoreOptions struct {
+	MySQLHost        *MySQLHost
+	MySQLCredentials *MySQLCredentials
+	Threads          int
+	SourceDir        string
+	Encryption       *EncryptionOptions // needed to restore an encrypted dump
+	Grants           bool               // load the accounts of grants.sql
+	AllowIncomplete  bool               // load a dump that was interrupted or doesn't have a manifest
+}
+
+// RestoreFile is one of the files of the dump that should be loaded.
+type RestoreFile struct {
+	Path   string
+	Schema string
+	Table  string
+	Type   string
+	Size   int64
+}
+
+var threadFileRegexp = regexp.MustCompile(`^(.+)-thread[0-9]+(?:-[0-9]+)?$`)
+
+// loadDataFileRegexp matches the file name of the LOAD DATA statements
+// written by writeLoadDataSQL.
+var loadDataFileRegexp = regexp.MustCompile(`(?is)^(\s*LOAD\s+DATA\s+LOCAL\s+INFILE\s+)'((?:[^'\\]|\\.)*)'`)
+
+// ParseDumpFileName return the schema, table and type of a file written by
+// the TaskManager. The table is empty for the files with the objects of a
+// schema, like mydb-views.sql. The last value is false for the files that
+// don't contain SQL to restore, like master-data.sql.
+func ParseDumpFileName(fileName string) (string, string, string, bool) {
+	name := TrimCompressExtension(strings.TrimSuffix(fileName, EncryptExtension))
+	if !strings.HasSuffix(name, ".sql") {
+		return "", "", "", false
+	}
+	name = strings.TrimSuffix(name, ".sql")
+
+	for _, fileType := range schemaObjectFileTypes {
+		schema := strings.TrimSuffix(name, "-"+fileType)
+		if schema != name && len(schema) > 0 && !strings.Contains(schema, ".") {
+			return schema, "", fileType, true
+		}
+	}
+
+	fileType := RestoreFileData
+	if strings.HasSuffix(name, "-definition") {
+		name = strings.TrimSuffix(name, "-definition")
+		fileType = RestoreFileDefinition
+	} else if strings.HasSuffix(name, "-load") {
+		name = strings.TrimSuffix(name, "-load")
+		fileType = RestoreFileLoad
+	} else if match := threadFileRegexp.FindStringSubmatch(name); match != nil {
+		name = match[1]
+	}
+
+	parts := strings.SplitN(name, ".", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return "", "", "", false
+	}
+	return parts[0], parts[1], fileType, true
+}
+
+// Restorer loads the files from a go-dump destination directory using
+// several connections in parallel.
+type Restorer struct {
+	options     *RestoreOptions
+	definitions []*RestoreFile
+	data        []*RestoreFile
+	objects     map[string][]*RestoreFile // files with the objects of the schemas by type
+	grants      *RestoreFile
+}
+
+// NewRestorer creates a Restorer for the options.
+func NewRestorer(options *RestoreOptions) *Restorer {
+	return &Restorer{options: options}
+}
+
+// GetFiles return the definition and the data files to restore.
+func (r *Restorer) GetFiles() ([]*RestoreFile, []*RestoreFile) {
+	return r.definitions, r.data
+}
+
+// GetGrantsFile return the file with the accounts, nil if the dump doesn't
+// have it.
+func (r *Restorer) GetGrantsFile() *RestoreFile {
+	return r.grants
+}
+
+// GetObjectFiles return the files with the views, the triggers, the routines
+// or the events of the schemas.
+func (r *Restorer) GetObjectFiles(fileType string) []*RestoreFile {
+	return r.objects[fileType]
+}
+
+// ScanFiles reads the dump directory and classify the files to restore.
+// The data files are sorted by size so the biggest files start first.
+func (r *Restorer) ScanFiles() error {
+	entries, err := os.ReadDir(r.options.SourceDir)
+	if err != nil {
+		return err
+	}
+
+	r.definitions = nil
+	r.data = nil
+	r.objects = make(map[string][]*RestoreFile)
+	r.grants = nil
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if IsGrantsFile(entry.Name()) {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			r.grants = &RestoreFile{Path: filepath.Join(r.options.SourceDir, entry.Name()), Type: DumpFileGrants,
+				Size: info.Size()}
+			continue
+		}
+		if format := unrestorableFormat(entry.Name()); format != "" {
+			return fmt.Errorf("the file %s is in the %s format, restore only loads the sql, csv and tsv formats",
+				entry.Name(), format)
+		}
+		schema, table, fileType, ok := ParseDumpFileName(entry.Name())
+		if !ok {
+			log.Debugf("Skipping file %s", entry.Name())
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		file := &RestoreFile{
+			Path:   filepath.Join(r.options.SourceDir, entry.Name()),
+			Schema: schema,
+			Table:  table,
+			Type:   fileType,
+			Size:   info.Size()}
+
+		switch fileType {
+		case RestoreFileDefinition:
+			r.definitions = append(r.definitions, file)
+		case RestoreFileData, RestoreFileLoad:
+			r.data = append(r.data, file)
+		default:
+			r.objects[fileType] = append(r.objects[fileType], file)
+		}
+	}
+
+	sort.SliceStable(r.data, func(i, j int) bool {
+		return r.data[i].Size > r.data[j].Size
+	})
+	return nil
+}
+
+// IsRestorableFormat return true if restore can load the data files of an
+// output format. The manifests of the older versions don't have the format,
+// their files are SQL.
+func IsRestorableFormat(format string) bool {
+	return format == "" || format == OutputFormatSQL || IsDelimitedFormat(format)
+}
+
+// unrestorableFormat return the format of a data file that restore can't
+// load, like "sakila.city-thread0.jsonl.gz", or an empty string.
+func unrestorableFormat(fileName string) string {
+	name := TrimCompressExtension(strings.TrimSuffix(fileName, EncryptExtension))
+	for _, format := range []string{OutputFormatJSONL, OutputFormatParquet} {
+		if strings.HasSuffix(name, "."+format) {
+			return format
+		}
+	}
+	return ""
+}
+
+// checkFormat return an error if the manifest has an output format that
+// restore can't load.
+func (r *Restorer) checkFormat() error {
+	if _, err := os.Stat(filepath.Join(r.options.SourceDir, ManifestFile)); os.IsNotExist(err) {
+		return nil
+	}
+	manifest, err := ReadManifest(r.options.SourceDir)
+	if err != nil {
+		return newError(ErrIO, "error reading the manifest of %s: %w", r.options.SourceDir, err)
+	}
+	if manifest.Options != nil && !IsRestorableFormat(manifest.Options.OutputFormat) {
+		return fmt.Errorf("the dump in %s is in the %s format, restore only loads the sql, csv and tsv formats",
+			r.options.SourceDir, manifest.Options.OutputFormat)
+	}
+	return nil
+}
+
+// CheckComplete return an error if the dump was interrupted, it still has
+// the checkpoint, or it doesn't have the manifest written at the end, unless
+// the AllowIncomplete option is set.
+func (r *Restorer) CheckComplete() error {
+	if r.options.AllowIncomplete {
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(r.options.SourceDir, CheckpointFile)); err == nil {
+		return fmt.Errorf("the dump in %s was interrupted and only has part of the data, finish it with --resume "+
+			"or use --allow-incomplete to load it", r.options.SourceDir)
+	}
+	if _, err := os.Stat(filepath.Join(r.options.SourceDir, ManifestFile)); os.IsNotExist(err) {
+		return fmt.Errorf("the dump in %s doesn't have %s, it didn't finish or it was written by an older "+
+			"version, use --allow-incomplete to load it", r.options.SourceDir, ManifestFile)
+	}
+	return nil
+}
+
+// Run restores the dump. All the definitions are loaded before the data.
+// The routines and the views are created after the tables, and the triggers
+// and the events after the data, so they don't run while it's loaded. The
+// accounts are only created if the Grants option is set, at the end.
+func (r *Restorer) Run() error {
+	if err := r.CheckComplete(); err != nil {
+		return err
+	}
+	if err := r.checkFormat(); err != nil {
+		return err
+	}
+	if err := r.ScanFiles(); err != nil {
+		return newError(ErrIO, "error reading the directory %s: %w", r.options.SourceDir, err)
+	}
+	log.Infof("Found %d table definitions, %d data files and %d files with views, triggers, routines or events in %s",
+		len(r.definitions), len(r.data), len(r.objects[RestoreFileRoutines])+len(r.objects[RestoreFileViews])+
+			len(r.objects[RestoreFileTriggers])+len(r.objects[RestoreFileEvents]), r.options.SourceDir)
+
+	threads := r.options.Threads
+	if threads < 1 {
+		threads = 1
+	}
+	var workers []*sql.DB
+	defer func() {
+		for _, db := range workers {
+			db.Close()
+		}
+	}()
+	for i := 0; i < threads; i++ {
+		db, err := GetMySQLConnection(r.options.MySQLHost, r.options.MySQLCredentials)
+		if err != nil {
+			return err
+		}
+		workers = append(workers, db)
+	}
+
+	startDefinitions := time.Now()
+	if err := r.loadFiles(workers, r.definitions); err != nil {
+		return err
+	}
+	log.Infof("Tables created in %s", time.Since(startDefinitions))
+	if err := r.loadObjectFiles(workers, RestoreFileRoutines, RestoreFileViews); err != nil {
+		return err
+	}
+
+	startData := time.Now()
+	if err := r.loadFiles(workers, r.data); err != nil {
+		return err
+	}
+	log.Infof("Data loaded in %s", time.Since(startData))
+	if err := r.loadObjectFiles(workers, RestoreFileTriggers, RestoreFileEvents); err != nil {
+		return err
+	}
+
+	if r.options.Grants {
+		if r.grants == nil {
+			log.Warningf("The dump doesn't have the file %s, no accounts are created", GrantsFile)
+			return nil
+		}
+		if err := r.loadFile(context.Background(), workers[0], r.grants); err != nil {
+			return err
+		}
+		log.Info("Accounts created")
+	} else if r.grants != nil {
+		log.Infof("Skipping the accounts of %s, use --grants to create them", GrantsFile)
+	}
+	return nil
+}
+
+// loadObjectFiles loads the files with the objects of the schemas, one type
+// after the other.
+func (r *Restorer) loadObjectFiles(workers []*sql.DB, fileTypes ...string) error {
+	for _, fileType := range fileTypes {
+		if len(r.objects[fileType]) == 0 {
+			continue
+		}
+		start := time.Now()
+		if err := r.loadFiles(workers, r.objects[fileType]); err != nil {
+			return err
+		}
+		log.Infof("The %s of %d schemas created in %s", fileType, len(r.objects[fileType]), time.Since(start))
+	}
+	return nil
+}
+
+// loadFiles loads the files using one goroutine per connection and return
+// the first error found.
+func (r *Restorer) loadFiles(workers []*sql.DB, files []*RestoreFile) error {
+	cFiles := make(chan *RestoreFile, len(files))
+	for _, file := range files {
+		cFiles <- file
+	}
+	close(cFiles)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+
+	for workerId, db := range workers {
+		wg.Add(1)
+		go func(workerId int, db *sql.DB) {
+			defer wg.Done()
+			for file := range cFiles {
+				if ctx.Err() != nil {
+					return
+				}
+				log.Debugf("Worker %d loading %s", workerId, file.Path)
+				if err := r.loadFile(ctx, db, file); err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					return
+				}
+			}
+		}(workerId, db)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// loadFile executes all the statements of a file in a single connection.
+func (r *Restorer) loadFile(ctx context.Context, db *sql.DB, file *RestoreFile) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return newError(ErrConnection, "error connecting to load the file %s: %w", file.Path, err)
+	}
+	defer conn.Close()
+
+	// The errors loading the data files are data errors, the others are
+	// errors creating the schema.
+	kind := ErrSchema
+	if file.Type == RestoreFileData || file.Type == RestoreFileLoad {
+		kind = ErrData
+	}
+
+	// The files that don't belong to a schema, like grants.sql, don't use
+	// any database.
+	if file.Schema != "" {
+		schema := fmt.Sprintf("`%s`", file.Schema)
+		if file.Type != RestoreFileData && file.Type != RestoreFileLoad {
+			if _, err := conn.ExecContext(ctx, fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", schema)); err != nil {
+				return newError(ErrSchema, "error creating the database %s: %w", schema, err)
+			}
+		}
+		if _, err := conn.ExecContext(ctx, GetUseDatabaseSQL(schema)); err != nil {
+			return newError(ErrSchema, "error using the database %s: %w", schema, err)
+		}
+	}
+
+	reader, err := NewFileReader(file.Path, r.options.Encryption)
+	if err != nil {
+		return newError(ErrIO, "error opening the file %s: %w", file.Path, err)
+	}
+	defer reader.Close()
+
+	statements := NewStatementReader(reader)
+	for {
+		statement, err := statements.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return newError(ErrIO, "error reading the file %s: %w", file.Path, err)
+		}
+		statement, ok := headerStatement(statement)
+		if !ok {
+			log.Debugf("Skipping the statement %s of %s", statement, file.Path)
+			continue
+		}
+		if file.Type == RestoreFileLoad {
+			err = r.loadData(ctx, conn, statement)
+		} else {
+			_, err = conn.ExecContext(ctx, statement)
+		}
+		if err != nil {
+			return newError(kind, "error loading the file %s: %w", file.Path, err)
+		}
+	}
+	return nil
+}
+
+// headerStatement return the statement to execute for a statement of the
+// header of the data files written by older versions, and false if it is
+// skipped. SET GLOBAL needs privileges and changes the server, and SET NAMES
+// utf8 can't load the 4 bytes characters.
+func headerStatement(statement string) (string, bool) {
+	switch upper := strings.ToUpper(statement); {
+	case strings.HasPrefix(upper, "SET GLOBAL "):
+		return statement, false
+	case upper == "SET NAMES UTF8":
+		return "SET NAMES utf8mb4", true
+	}
+	return statement, true
+}
+
+// loadData executes a statement of a load script. The data file of a LOAD
+// DATA LOCAL INFILE statement is read from the dump directory, decompressing
+// it if needed, and sent to the server using a reader handler of the driver.
+func (r *Restorer) loadData(ctx context.Context, conn *sql.Conn, statement string) error {
+	match := loadDataFileRegexp.FindStringSubmatch(statement)
+	if match == nil {
+		_, err := conn.ExecContext(ctx, statement)
+		return err
+	}
+
+	path := filepath.Join(r.options.SourceDir, filepath.Base(match[2]))
+	reader, err := NewFileReader(path, r.options.Encryption)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	mysql.RegisterReaderHandler(path, func() io.Reader { return reader })
+	defer mysql.DeregisterReaderHandler(path)
+
+	statement = match[1] + quoteSQLString("Reader::"+path) + statement[len(match[0]):]
+	_, err = conn.ExecContext(ctx, statement)
+	return err
+}
@@ -0,0 +1,189 @@
+package utils
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseDumpFileName(t *testing.T) {
+	tests := []struct {
+		fileName string
+		schema   string
+		table    string
+		fileType string
+		ok       bool
+	}{
+		{"sakila.city-definition.sql", "sakila", "city", RestoreFileDefinition, true},
+		{"sakila.city-thread3.sql", "sakila", "city", RestoreFileData, true},
+		{"sakila.city-thread0.sql.gz", "sakila", "city", RestoreFileData, true},
+		{"sakila.city-thread0-2.sql.gz", "sakila", "city", RestoreFileData, true},
+		{"sakila.city-thread1.sql.zst", "sakila", "city", RestoreFileData, true},
+		{"sakila.city-definition.sql.lz4", "sakila", "city", RestoreFileDefinition, true},
+		{"sakila.city-thread2.sql.gz.enc", "sakila", "city", RestoreFileData, true},
+		{"sakila.city-thread2.csv.enc", "", "", "", false},
+		{"sakila.store_no_pk.sql", "sakila", "store_no_pk", RestoreFileData, true},
+		{"sakila.city-load.sql", "sakila", "city", RestoreFileLoad, true},
+		{"sakila-views.sql", "sakila", "", RestoreFileViews, true},
+		{"sakila-triggers.sql.gz.enc", "sakila", "", RestoreFileTriggers, true},
+		{"sakila-routines.sql", "sakila", "", RestoreFileRoutines, true},
+		{"sakila-events.sql.zst", "sakila", "", RestoreFileEvents, true},
+		{"sakila.my-views.sql", "sakila", "my-views", RestoreFileData, true},
+		{"sakila.city-thread0.csv", "", "", "", false},
+		{"master-data.sql", "", "", "", false},
+		{"slave-data.sql.gz", "", "", "", false},
+		{"grants.sql.gz.enc", "", "", "", false},
+		{"notes.txt", "", "", "", false},
+	}
+
+	for _, tt := range tests {
+		schema, table, fileType, ok := ParseDumpFileName(tt.fileName)
+		if schema != tt.schema || table != tt.table || fileType != tt.fileType || ok != tt.ok {
+			t.Errorf("File %s: got (%s, %s, %s, %v) and we expect (%s, %s, %s, %v).",
+				tt.fileName, schema, table, fileType, ok, tt.schema, tt.table, tt.fileType, tt.ok)
+		}
+	}
+
+	if !IsGrantsFile("grants.sql.gz.enc") || !IsGrantsFile(GrantsFile) || IsGrantsFile("sakila.grants.sql") {
+		t.Error("Unexpected grants file")
+	}
+}
+
+func TestStatementReader(t *testing.T) {
+	input := "SET NAMES utf8;\n" +
+		"USE `sakila`\n" +
+		"-- Chunk 1 - from 1 to 10\n" +
+		"INSERT INTO `city` VALUES \n(1,'a;b\\'c','x'),\n(2,NULL,\"d;\");\n" +
+		"/*!40101 SET NAMES binary*/;\n" +
+		"# comment; with delimiter\n" +
+		"INSERT INTO `t` VALUES (3-1,'--');\n"
+
+	expect := []string{
+		"SET NAMES utf8",
+		"USE `sakila`",
+		"INSERT INTO `city` VALUES \n(1,'a;b\\'c','x'),\n(2,NULL,\"d;\")",
+		"/*!40101 SET NAMES binary*/",
+		"INSERT INTO `t` VALUES (3-1,'--')",
+	}
+
+	reader := NewStatementReader(strings.NewReader(input))
+	for _, e := range expect {
+		statement, err := reader.Next()
+		if err != nil {
+			t.Fatalf("Unexpected error %s", err.Error())
+		}
+		if statement != e {
+			t.Fatalf("Got \"%s\" and expected \"%s\"", statement, e)
+		}
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Fatalf("Expected io.EOF and got %v", err)
+	}
+}
+
+func TestStatementReaderDelimiter(t *testing.T) {
+	input := "SET SQL_MODE='';\n" +
+		"DELIMITER ;;\n" +
+		"CREATE TRIGGER `t_bi` BEFORE INSERT ON `t` FOR EACH ROW BEGIN\n" +
+		"  -- keep it; updated\n" +
+		"  SET NEW.a = ';;'; SET NEW.b = 1;\n" +
+		"END;;\n" +
+		"delimiter $$\n" +
+		"CREATE PROCEDURE `p`() SELECT 1; SELECT 2$$\n" +
+		"DELIMITER ;\n" +
+		"SET SQL_MODE=@OLD_SQL_MODE;\n"
+
+	expect := []string{
+		"SET SQL_MODE=''",
+		"CREATE TRIGGER `t_bi` BEFORE INSERT ON `t` FOR EACH ROW BEGIN\n" +
+			"  -- keep it; updated\n" +
+			"  SET NEW.a = ';;'; SET NEW.b = 1;\n" +
+			"END",
+		"CREATE PROCEDURE `p`() SELECT 1; SELECT 2",
+		"SET SQL_MODE=@OLD_SQL_MODE",
+	}
+
+	reader := NewStatementReader(strings.NewReader(input))
+	for _, e := range expect {
+		statement, err := reader.Next()
+		if err != nil {
+			t.Fatalf("Unexpected error %s", err.Error())
+		}
+		if statement != e {
+			t.Fatalf("Got \"%s\" and expected \"%s\"", statement, e)
+		}
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Fatalf("Expected io.EOF and got %v", err)
+	}
+}
+
+func TestHeaderStatement(t *testing.T) {
+	for _, test := range []struct {
+		statement string
+		expected  string
+		ok        bool
+	}{
+		{"SET NAMES utf8", "SET NAMES utf8mb4", true},
+		{"SET NAMES utf8mb4", "SET NAMES utf8mb4", true},
+		{"SET GLOBAL MAX_ALLOWED_PACKET=1073741824", "SET GLOBAL MAX_ALLOWED_PACKET=1073741824", false},
+		{"SET TIME_ZONE='+00:00'", "SET TIME_ZONE='+00:00'", true},
+	} {
+		statement, ok := headerStatement(test.statement)
+		if statement != test.expected || ok != test.ok {
+			t.Errorf("Unexpected statement %q, %v for %q", statement, ok, test.statement)
+		}
+	}
+}
+
+func TestRestoreCheckComplete(t *testing.T) {
+	dir := t.TempDir()
+	restorer := NewRestorer(&RestoreOptions{SourceDir: dir})
+	if err := restorer.CheckComplete(); err == nil || !strings.Contains(err.Error(), ManifestFile) {
+		t.Fatalf("Expected an error without the manifest, got %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, ManifestFile), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := restorer.CheckComplete(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	// An interrupted dump has the checkpoint.
+	if err := os.WriteFile(filepath.Join(dir, CheckpointFile), []byte("{}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := restorer.CheckComplete(); err == nil || !strings.Contains(err.Error(), "interrupted") {
+		t.Fatalf("Expected an error with the checkpoint, got %v", err)
+	}
+	restorer = NewRestorer(&RestoreOptions{SourceDir: dir, AllowIncomplete: true})
+	if err := restorer.CheckComplete(); err != nil {
+		t.Fatalf("Unexpected error with AllowIncomplete %v", err)
+	}
+}
+
+func TestRestoreFormat(t *testing.T) {
+	dir := t.TempDir()
+	restorer := NewRestorer(&RestoreOptions{SourceDir: dir})
+	for format, restorable := range map[string]bool{"": true, "sql": true, "csv": true, "tsv": true,
+		"jsonl": false, "parquet": false} {
+		manifest := `{"options": {"output_format": "` + format + `"}}`
+		if err := os.WriteFile(filepath.Join(dir, ManifestFile), []byte(manifest), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := restorer.checkFormat(); (err == nil) != restorable {
+			t.Errorf("Unexpected error %v with the format %q", err, format)
+		}
+	}
+
+	// The data files are checked too, for the dumps without manifest.
+	if err := os.WriteFile(filepath.Join(dir, "sakila.city-thread0.jsonl.gz"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := restorer.ScanFiles(); err == nil || !strings.Contains(err.Error(), "jsonl") {
+		t.Fatalf("Expected an error with a jsonl file, got %v", err)
+	}
+}
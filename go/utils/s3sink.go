@@ -0,0 +1,279 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ChaosHour/go-dump/go/log"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// S3Scheme is the prefix of the destinations in S3 compatible storages.
+	S3Scheme = "s3://"
+	// MinS3PartSize is the minimum size of a part of a multipart upload,
+	// except the last one.
+	MinS3PartSize = 5 * 1024 * 1024
+	// DefaultS3PartSize is the default size of the parts of the uploads.
+	DefaultS3PartSize = 16 * 1024 * 1024
+	// S3MaxOpenFiles is the number of data files each worker keeps open
+	// with an S3 destination, so the memory of the parts is bounded.
+	S3MaxOpenFiles = 4
+	// DefaultS3Region is used when there is no region in the options nor in
+	// the AWS configuration, most S3 compatible storages accept it.
+	DefaultS3Region = "us-east-1"
+)
+
+// S3Options are the options to connect to an S3 compatible storage.
+type S3Options struct {
+	Endpoint   string
+	Region     string
+	AccessKey  string
+	SecretKey  string
+	PathStyle  bool
+	PartSize   uint64
+	MaxRetries int
+}
+
+// IsS3Destination return true if the destination is an s3://bucket/prefix url.
+func IsS3Destination(destination string) bool {
+	return strings.HasPrefix(destination, S3Scheme)
+}
+
+// ParseS3Destination return the bucket and the prefix of an s3://bucket/prefix
+// url. The prefix can be empty.
+func ParseS3Destination(destination string) (string, string, error) {
+	if !IsS3Destination(destination) {
+		return "", "", fmt.Errorf("the destination %s doesn't start with %s", destination, S3Scheme)
+	}
+	path := strings.TrimPrefix(destination, S3Scheme)
+	bucket, prefix, _ := strings.Cut(path, "/")
+	if bucket == "" {
+		return "", "", fmt.Errorf("there is no bucket in the destination %s", destination)
+	}
+	return bucket, strings.Trim(prefix, "/"), nil
+}
+
+// S3Sink uploads the files of the dump to a bucket of an S3 compatible
+// storage. The files are sent with multipart uploads while they are written,
+// so the dump doesn't need local disk.
+type S3Sink struct {
+	Bucket   string
+	Prefix   string
+	client   *s3.Client
+	partSize int
+	ctx      context.Context
+}
+
+// NewS3Sink creates a sink for a destination like s3://bucket/prefix. The
+// credentials are taken from the options or, if they are empty, from the
+// usual AWS environment variables and configuration files.
+func NewS3Sink(ctx context.Context, destination string, options *S3Options) (*S3Sink, error) {
+	bucket, prefix, err := ParseS3Destination(destination)
+	if err != nil {
+		return nil, err
+	}
+
+	loadOptions := []func(*config.LoadOptions) error{
+		config.WithRetryMaxAttempts(options.MaxRetries + 1),
+	}
+	if options.Region != "" {
+		loadOptions = append(loadOptions, config.WithRegion(options.Region))
+	}
+	if options.AccessKey != "" {
+		loadOptions = append(loadOptions, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(options.AccessKey, options.SecretKey, "")))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, loadOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("error loading the S3 configuration: %v", err)
+	}
+	if cfg.Region == "" {
+		cfg.Region = DefaultS3Region
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if options.Endpoint != "" {
+			o.BaseEndpoint = aws.String(options.Endpoint)
+		}
+		o.UsePathStyle = options.PathStyle
+		// Some S3 compatible storages don't support the newer checksums.
+		o.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+		o.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+	})
+
+	partSize := int(options.PartSize)
+	if partSize <= 0 {
+		partSize = DefaultS3PartSize
+	}
+	return &S3Sink{Bucket: bucket, Prefix: prefix, client: client, partSize: partSize, ctx: ctx}, nil
+}
+
+// GetKey return the key of the object for a file of the dump.
+func (s *S3Sink) GetKey(name string) string {
+	if s.Prefix == "" {
+		return name
+	}
+	return s.Prefix + "/" + name
+}
+
+// Create return a writer that uploads the object. Nothing is sent until a
+// part is full or the writer is closed.
+func (s *S3Sink) Create(name string) (SinkWriter, error) {
+	return &s3Object{sink: s, key: s.GetKey(name)}, nil
+}
+
+// MaxOpenFiles return the number of data files each worker keeps open, as
+// each one keeps up to a part in memory.
+func (s *S3Sink) MaxOpenFiles() int {
+	return S3MaxOpenFiles
+}
+
+// Commit does nothing, each object is complete when its writer is closed.
+func (s *S3Sink) Commit() error {
+	return nil
+}
+
+// s3Object keeps the data until there is a full part to upload. Small objects
+// are uploaded with a single request when they are closed.
+type s3Object struct {
+	sink     *S3Sink
+	key      string
+	buffer   []byte
+	uploadID *string
+	parts    []types.CompletedPart
+	err      error
+}
+
+func (o *s3Object) Write(p []byte) (int, error) {
+	if o.err != nil {
+		return 0, o.err
+	}
+	written := len(p)
+	for len(p) > 0 {
+		n := min(o.sink.partSize-len(o.buffer), len(p))
+		o.grow(n)
+		o.buffer = append(o.buffer, p[:n]...)
+		p = p[n:]
+		if len(o.buffer) < o.sink.partSize {
+			break
+		}
+		if err := o.uploadPart(o.buffer); err != nil {
+			o.abort(err)
+			return 0, o.err
+		}
+		o.buffer = o.buffer[:0]
+	}
+	return written, nil
+}
+
+// grow makes room for n more bytes in the buffer, which never gets bigger
+// than a part.
+func (o *s3Object) grow(n int) {
+	if len(o.buffer)+n <= cap(o.buffer) {
+		return
+	}
+	size := min(max(2*cap(o.buffer), len(o.buffer)+n), o.sink.partSize)
+	buffer := make([]byte, len(o.buffer), size)
+	copy(buffer, o.buffer)
+	o.buffer = buffer
+}
+
+func (o *s3Object) uploadPart(data []byte) error {
+	if o.uploadID == nil {
+		output, err := o.sink.client.CreateMultipartUpload(o.sink.ctx, &s3.CreateMultipartUploadInput{
+			Bucket: aws.String(o.sink.Bucket),
+			Key:    aws.String(o.key),
+		})
+		if err != nil {
+			return err
+		}
+		o.uploadID = output.UploadId
+	}
+
+	partNumber := aws.Int32(int32(len(o.parts) + 1))
+	output, err := o.sink.client.UploadPart(o.sink.ctx, &s3.UploadPartInput{
+		Bucket:     aws.String(o.sink.Bucket),
+		Key:        aws.String(o.key),
+		UploadId:   o.uploadID,
+		PartNumber: partNumber,
+		Body:       bytes.NewReader(data),
+	})
+	if err != nil {
+		return err
+	}
+	log.Debugf("Uploaded part %d of %s", *partNumber, o.key)
+	o.parts = append(o.parts, types.CompletedPart{ETag: output.ETag, PartNumber: partNumber})
+	return nil
+}
+
+// abort cancels the multipart upload, so the storage doesn't keep the parts.
+func (o *s3Object) abort(err error) {
+	o.err = fmt.Errorf("error uploading s3://%s/%s: %v", o.sink.Bucket, o.key, err)
+	if o.uploadID == nil {
+		return
+	}
+	_, abortErr := o.sink.client.AbortMultipartUpload(o.sink.ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(o.sink.Bucket),
+		Key:      aws.String(o.key),
+		UploadId: o.uploadID,
+	})
+	if abortErr != nil {
+		log.Warningf("Error aborting the upload of s3://%s/%s: %s", o.sink.Bucket, o.key, abortErr.Error())
+	}
+}
+
+// Abort cancels the upload, so the incomplete object is never visible.
+func (o *s3Object) Abort() error {
+	if o.err == nil {
+		o.abort(errors.New("the upload was aborted"))
+	}
+	return nil
+}
+
+// Close uploads the pending data and completes the upload.
+func (o *s3Object) Close() error {
+	if o.err != nil {
+		return o.err
+	}
+
+	if o.uploadID == nil {
+		_, err := o.sink.client.PutObject(o.sink.ctx, &s3.PutObjectInput{
+			Bucket: aws.String(o.sink.Bucket),
+			Key:    aws.String(o.key),
+			Body:   bytes.NewReader(o.buffer),
+		})
+		if err != nil {
+			o.abort(err)
+			return o.err
+		}
+		o.buffer = nil
+		return nil
+	}
+
+	if len(o.buffer) > 0 {
+		if err := o.uploadPart(o.buffer); err != nil {
+			o.abort(err)
+			return o.err
+		}
+		o.buffer = nil
+	}
+	_, err := o.sink.client.CompleteMultipartUpload(o.sink.ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(o.sink.Bucket),
+		Key:             aws.String(o.key),
+		UploadId:        o.uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: o.parts},
+	})
+	if err != nil {
+		o.abort(err)
+		return o.err
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 is a minimal S3 compatible server with path style urls.
+type fakeS3 struct {
+	mu            sync.Mutex
+	objects       map[string][]byte
+	uploads       map[string]map[int][]byte
+	aborted       []string
+	failParts     int // number of UploadPart requests answered with an error
+	failCompletes bool
+	uploadIDs     int
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: map[string][]byte{}, uploads: map[string]map[int][]byte{}}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	query := r.URL.Query()
+	body, _ := io.ReadAll(r.Body)
+
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.uploadIDs++
+		id := fmt.Sprintf("upload%d", f.uploadIDs)
+		f.uploads[id] = map[int][]byte{}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", id)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		if f.failParts > 0 {
+			f.failParts--
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "<Error><Code>InternalError</Code></Error>")
+			return
+		}
+		part, _ := strconv.Atoi(query.Get("partNumber"))
+		f.uploads[query.Get("uploadId")][part] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"etag%d"`, part))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		if f.failCompletes {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, "<Error><Code>InvalidPart</Code></Error>")
+			return
+		}
+		parts := f.uploads[query.Get("uploadId")]
+		numbers := []int{}
+		for number := range parts {
+			numbers = append(numbers, number)
+		}
+		sort.Ints(numbers)
+		var data []byte
+		for _, number := range numbers {
+			data = append(data, parts[number]...)
+		}
+		f.objects[key] = data
+		delete(f.uploads, query.Get("uploadId"))
+		fmt.Fprint(w, `<CompleteMultipartUploadResult><ETag>"etag"</ETag></CompleteMultipartUploadResult>`)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		f.aborted = append(f.aborted, key)
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		f.objects[key] = body
+		w.Header().Set("ETag", `"etag"`)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func newTestS3Sink(t *testing.T, server *httptest.Server, partSize uint64) *S3Sink {
+	t.Setenv("AWS_CONFIG_FILE", "/dev/null")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/dev/null")
+	sink, err := NewS3Sink(context.Background(), "s3://backups/daily/", &S3Options{
+		Endpoint:   server.URL,
+		Region:     "us-east-1",
+		AccessKey:  "key",
+		SecretKey:  "secret",
+		PathStyle:  true,
+		PartSize:   partSize,
+		MaxRetries: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sink
+}
+
+func TestParseS3Destination(t *testing.T) {
+	tests := []struct {
+		destination, bucket, prefix string
+		fail                        bool
+	}{
+		{destination: "s3://backups", bucket: "backups"},
+		{destination: "s3://backups/", bucket: "backups"},
+		{destination: "s3://backups/daily/sakila/", bucket: "backups", prefix: "daily/sakila"},
+		{destination: "s3:///daily", fail: true},
+		{destination: "/tmp/dump", fail: true},
+	}
+	for _, test := range tests {
+		bucket, prefix, err := ParseS3Destination(test.destination)
+		if test.fail {
+			if err == nil {
+				t.Fatalf("Expected an error for %s", test.destination)
+			}
+			continue
+		}
+		if err != nil || bucket != test.bucket || prefix != test.prefix {
+			t.Fatalf("Unexpected result for %s: %s %s %v", test.destination, bucket, prefix, err)
+		}
+	}
+}
+
+func TestS3Sink(t *testing.T) {
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	sink := newTestS3Sink(t, server, 10)
+
+	// A small file is sent with a single request.
+	buffer, err := NewSinkBuffer(sink, "sakila.actor-definition.sql", "", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buffer.Write([]byte("CREATE"))
+	if err := buffer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A bigger file is sent in parts, the failed part is retried.
+	fake.failParts = 1
+	buffer, err = NewSinkBuffer(sink, "sakila.actor-thread0.sql", "", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := strings.Repeat("0123456789", 2) + "abcde"
+	buffer.Write([]byte(data))
+	if err := buffer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := string(fake.objects["backups/daily/sakila.actor-definition.sql"]); got != "CREATE" {
+		t.Fatalf("Unexpected definition object %q", got)
+	}
+	if got := string(fake.objects["backups/daily/sakila.actor-thread0.sql"]); got != data {
+		t.Fatalf("Unexpected data object %q", got)
+	}
+	if len(fake.uploads) != 0 {
+		t.Fatalf("Unexpected pending uploads %v", fake.uploads)
+	}
+}
+
+func TestS3SinkAbort(t *testing.T) {
+	fake := newFakeS3()
+	fake.failCompletes = true
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	sink := newTestS3Sink(t, server, 10)
+
+	writer, err := sink.Create("sakila.city-thread0.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Write([]byte(strings.Repeat("x", 15)))
+	if err := writer.Close(); err == nil {
+		t.Fatal("Expected an error completing the upload")
+	}
+	if len(fake.aborted) != 1 || len(fake.uploads) != 0 {
+		t.Fatalf("Expected the upload to be aborted: %v %v", fake.aborted, fake.uploads)
+	}
+	if _, ok := fake.objects["backups/daily/sakila.city-thread0.sql"]; ok {
+		t.Fatal("Unexpected object for the aborted upload")
+	}
+}
+
+func TestS3SinkPartBuffer(t *testing.T) {
+	fake := newFakeS3()
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	sink := newTestS3Sink(t, server, 10)
+
+	writer, err := sink.Create("sakila.city-thread0.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	object := writer.(*s3Object)
+	for _, data := range []string{"0123", "456789abcdefghij", "klmnopqrstuvwxyz01234"} {
+		if _, err := writer.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		if cap(object.buffer) > 10 {
+			t.Fatalf("The buffer has %d bytes, more than a part", cap(object.buffer))
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(fake.objects["backups/daily/sakila.city-thread0.sql"]); got != "0123456789abcdefghijklmnopqrstuvwxyz01234" {
+		t.Fatalf("Unexpected object %q", got)
+	}
+	if sink.MaxOpenFiles() != S3MaxOpenFiles {
+		t.Errorf("Unexpected open files %d", sink.MaxOpenFiles())
+	}
+}
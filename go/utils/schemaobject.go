@@ -0,0 +1,387 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/ChaosHour/go-dump/go/log"
+)
+
+// Types of the schema objects, as used in SHOW CREATE.
+const (
+	SchemaObjectView      = "VIEW"
+	SchemaObjectTrigger   = "TRIGGER"
+	SchemaObjectProcedure = "PROCEDURE"
+	SchemaObjectFunction  = "FUNCTION"
+	SchemaObjectEvent     = "EVENT"
+)
+
+// SchemaObject is a view, a trigger, a stored routine or an event with the
+// statement to create it and the session variables used to create it.
+type SchemaObject struct {
+	Type         string
+	Schema       string
+	Name         string
+	Table        string   // table of a trigger
+	Columns      []string // columns of a view
+	CreateSQL    string
+	SQLMode      string
+	TimeZone     string
+	CharacterSet string
+	Collation    string
+}
+
+// queryStrings runs a query and return the rows as maps by the lower case
+// name of the column. NULL values are empty strings.
+func queryStrings(conn *sql.Conn, query string, args ...interface{}) ([]map[string]string, error) {
+	rows, err := conn.QueryContext(context.Background(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var result []map[string]string
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]string, len(columns))
+		for i, column := range columns {
+			row[strings.ToLower(column)] = values[i].String
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// getSchemaObject reads the definition of an object with SHOW CREATE.
+func getSchemaObject(conn *sql.Conn, objectType string, schema string, name string) (*SchemaObject, error) {
+	rows, err := queryStrings(conn, fmt.Sprintf("SHOW CREATE %s `%s`.`%s`", objectType, schema, name))
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("the %s %s.%s doesn't exist", strings.ToLower(objectType), schema, name)
+	}
+	column := "create " + strings.ToLower(objectType)
+	if objectType == SchemaObjectTrigger {
+		column = "sql original statement"
+	}
+	object := &SchemaObject{
+		Type:         objectType,
+		Schema:       schema,
+		Name:         name,
+		CreateSQL:    rows[0][column],
+		SQLMode:      rows[0]["sql_mode"],
+		TimeZone:     rows[0]["time_zone"],
+		CharacterSet: rows[0]["character_set_client"],
+		Collation:    rows[0]["collation_connection"]}
+	if object.CreateSQL == "" {
+		return nil, fmt.Errorf("the definition of the %s %s.%s is empty, the user may not have enough privileges",
+			strings.ToLower(objectType), schema, name)
+	}
+	return object, nil
+}
+
+// getSchemaObjects reads the definition of the objects returned by the
+// query, which must return their names.
+func getSchemaObjects(conn *sql.Conn, objectType string, schema string, query string, args ...interface{}) ([]*SchemaObject, error) {
+	rows, err := queryStrings(conn, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	var objects []*SchemaObject
+	for _, row := range rows {
+		object, err := getSchemaObject(conn, objectType, schema, row["name"])
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, object)
+	}
+	return objects, nil
+}
+
+// schemaConn return a connection using the schema, to read the definitions
+// of its objects.
+func schemaConn(db *sql.DB, schema string) (*sql.Conn, error) {
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.ExecContext(context.Background(), GetUseDatabaseSQL(fmt.Sprintf("`%s`", schema))); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// GetViews return the views of a schema with their columns, sorted by name.
+func GetViews(db *sql.DB, schema string) ([]*SchemaObject, error) {
+	conn, err := schemaConn(db, schema)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	views, err := getSchemaObjects(conn, SchemaObjectView, schema, `SELECT TABLE_NAME AS name
+		FROM information_schema.TABLES WHERE TABLE_SCHEMA = ? AND TABLE_TYPE = 'VIEW' ORDER BY TABLE_NAME`, schema)
+	if err != nil {
+		return nil, err
+	}
+	for _, view := range views {
+		columns, err := queryStrings(conn, `SELECT COLUMN_NAME AS name FROM information_schema.COLUMNS
+			WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION`, schema, view.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, column := range columns {
+			view.Columns = append(view.Columns, column["name"])
+		}
+	}
+	return views, nil
+}
+
+// GetTriggers return the triggers of a schema in the order returned by the
+// server, which keeps the order of the triggers of the same table.
+func GetTriggers(db *sql.DB, schema string) ([]*SchemaObject, error) {
+	conn, err := schemaConn(db, schema)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	rows, err := queryStrings(conn, fmt.Sprintf("SHOW TRIGGERS FROM `%s`", schema))
+	if err != nil {
+		return nil, err
+	}
+	var triggers []*SchemaObject
+	for _, row := range rows {
+		trigger, err := getSchemaObject(conn, SchemaObjectTrigger, schema, row["trigger"])
+		if err != nil {
+			return nil, err
+		}
+		trigger.Table = row["table"]
+		triggers = append(triggers, trigger)
+	}
+	return triggers, nil
+}
+
+// GetRoutines return the stored procedures and functions of a schema.
+func GetRoutines(db *sql.DB, schema string) ([]*SchemaObject, error) {
+	conn, err := schemaConn(db, schema)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var routines []*SchemaObject
+	for _, routineType := range []string{SchemaObjectProcedure, SchemaObjectFunction} {
+		objects, err := getSchemaObjects(conn, routineType, schema, `SELECT ROUTINE_NAME AS name
+			FROM information_schema.ROUTINES WHERE ROUTINE_SCHEMA = ? AND ROUTINE_TYPE = ?
+			ORDER BY ROUTINE_NAME`, schema, routineType)
+		if err != nil {
+			return nil, err
+		}
+		routines = append(routines, objects...)
+	}
+	return routines, nil
+}
+
+// GetEvents return the events of a schema sorted by name.
+func GetEvents(db *sql.DB, schema string) ([]*SchemaObject, error) {
+	conn, err := schemaConn(db, schema)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return getSchemaObjects(conn, SchemaObjectEvent, schema, `SELECT EVENT_NAME AS name
+		FROM information_schema.EVENTS WHERE EVENT_SCHEMA = ? ORDER BY EVENT_NAME`, schema)
+}
+
+// AddSchema records a schema dumped completely, so its views, routines and
+// events are dumped too.
+func (tm *TaskManager) AddSchema(schema string) {
+	if tm.schemas == nil {
+		tm.schemas = make(map[string]bool)
+	}
+	tm.schemas[schema] = true
+}
+
+// getObjectSchemas return the schemas dumped completely and the schemas of
+// the tables dumped, sorted.
+func (tm *TaskManager) getObjectSchemas() []string {
+	found := make(map[string]bool)
+	for schema := range tm.schemas {
+		found[schema] = true
+	}
+	for _, task := range tm.tasksPool {
+		found[task.Table.GetUnescapedSchema()] = true
+	}
+	schemas := make([]string, 0, len(found))
+	for schema := range found {
+		schemas = append(schemas, schema)
+	}
+	sort.Strings(schemas)
+	return schemas
+}
+
+// WriteSchemaObjectsSQL writes the views, the triggers, the routines and the
+// events requested in the options, in one file per schema and type. The
+// triggers are the ones of the tables dumped, the other objects are only
+// dumped for the schemas dumped completely.
+func (tm *TaskManager) WriteSchemaObjectsSQL(addDropTable bool) error {
+	for _, schema := range tm.getObjectSchemas() {
+		if tm.schemas[schema] && tm.DumpOptions.Routines {
+			routines, err := GetRoutines(tm.DB, schema)
+			if err != nil {
+				return newError(ErrSchema, "error getting the routines of %s: %w", schema, err)
+			}
+			if err := tm.writeSchemaObjectsSQL(schema, RestoreFileRoutines, routines, addDropTable); err != nil {
+				return err
+			}
+		}
+		if tm.schemas[schema] && tm.DumpOptions.Views {
+			views, err := GetViews(tm.DB, schema)
+			if err != nil {
+				return newError(ErrSchema, "error getting the views of %s: %w", schema, err)
+			}
+			if err := tm.writeSchemaObjectsSQL(schema, RestoreFileViews, tm.filterViews(views), addDropTable); err != nil {
+				return err
+			}
+		}
+		if tm.DumpOptions.Triggers {
+			triggers, err := GetTriggers(tm.DB, schema)
+			if err != nil {
+				return newError(ErrSchema, "error getting the triggers of %s: %w", schema, err)
+			}
+			if err := tm.writeSchemaObjectsSQL(schema, RestoreFileTriggers, tm.filterDumpedTables(triggers),
+				addDropTable); err != nil {
+				return err
+			}
+		}
+		if tm.schemas[schema] && tm.DumpOptions.Events {
+			events, err := GetEvents(tm.DB, schema)
+			if err != nil {
+				return newError(ErrSchema, "error getting the events of %s: %w", schema, err)
+			}
+			if err := tm.writeSchemaObjectsSQL(schema, RestoreFileEvents, events, addDropTable); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// filterViews return the views that match the table filter.
+func (tm *TaskManager) filterViews(views []*SchemaObject) []*SchemaObject {
+	var filtered []*SchemaObject
+	for _, view := range views {
+		if tm.DumpOptions.TableFilter.Match(view.Schema, view.Name) {
+			filtered = append(filtered, view)
+		}
+	}
+	return filtered
+}
+
+// filterDumpedTables return the triggers of the tables that are dumped.
+func (tm *TaskManager) filterDumpedTables(triggers []*SchemaObject) []*SchemaObject {
+	tables := make(map[string]bool)
+	for _, task := range tm.tasksPool {
+		tables[task.Table.GetUnescapedFullName()] = true
+	}
+	var filtered []*SchemaObject
+	for _, trigger := range triggers {
+		if tables[trigger.Schema+"."+trigger.Table] {
+			filtered = append(filtered, trigger)
+		}
+	}
+	return filtered
+}
+
+func (tm *TaskManager) writeSchemaObjectsSQL(schema string, fileType string, objects []*SchemaObject,
+	addDropTable bool) error {
+	if len(objects) == 0 {
+		return nil
+	}
+	buffer, err := NewSchemaObjectsBuffer(tm, schema, fileType)
+	if err != nil {
+		return newError(ErrIO, "error creating the %s file of %s: %w", fileType, schema, err)
+	}
+
+	if !tm.SkipUseDatabase {
+		fmt.Fprintf(buffer, "%s;\n", GetUseDatabaseSQL(fmt.Sprintf("`%s`", schema)))
+	}
+	writeSchemaObjects(buffer, objects, addDropTable)
+	if err := buffer.Close(); err != nil {
+		return newError(ErrIO, "error writing the %s file of %s: %w", fileType, schema, err)
+	}
+	tm.addFile(fileType, buffer)
+	log.Debugf("Written %d %s of %s", len(objects), fileType, schema)
+	return nil
+}
+
+// writeSchemaObjects writes the statements to create the objects. The
+// session variables are restored at the end. The views are first created
+// with the same columns and constant values, so they can reference other
+// views in any order.
+func writeSchemaObjects(w io.Writer, objects []*SchemaObject, addDropTable bool) {
+	fmt.Fprintf(w, "SET @OLD_SQL_MODE=@@SQL_MODE, @OLD_TIME_ZONE=@@TIME_ZONE, "+
+		"@OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT, @OLD_COLLATION_CONNECTION=@@COLLATION_CONNECTION;\n")
+
+	for _, object := range objects {
+		if object.Type != SchemaObjectView {
+			continue
+		}
+		if addDropTable {
+			fmt.Fprintf(w, "DROP VIEW IF EXISTS `%s`;\n", object.Name)
+		}
+		columns := make([]string, 0, len(object.Columns))
+		for _, column := range object.Columns {
+			columns = append(columns, fmt.Sprintf("1 AS `%s`", column))
+		}
+		fmt.Fprintf(w, "CREATE VIEW `%s` AS SELECT %s;\n", object.Name, strings.Join(columns, ", "))
+	}
+
+	for _, object := range objects {
+		fmt.Fprintf(w, "\n-- %s%s %s\n", object.Type[:1], strings.ToLower(object.Type[1:]), object.Name)
+		if object.SQLMode != "" || object.Type != SchemaObjectView {
+			fmt.Fprintf(w, "SET SQL_MODE=%s;\n", quoteSQLString(object.SQLMode))
+		}
+		if object.TimeZone != "" {
+			fmt.Fprintf(w, "SET TIME_ZONE=%s;\n", quoteSQLString(object.TimeZone))
+		}
+		if object.CharacterSet != "" {
+			fmt.Fprintf(w, "SET CHARACTER_SET_CLIENT=%s;\n", object.CharacterSet)
+		}
+		if object.Collation != "" {
+			fmt.Fprintf(w, "SET COLLATION_CONNECTION=%s;\n", object.Collation)
+		}
+		if object.Type == SchemaObjectView {
+			fmt.Fprintf(w, "DROP VIEW IF EXISTS `%s`;\n", object.Name)
+			fmt.Fprintf(w, "%s;\n", object.CreateSQL)
+			continue
+		}
+		if addDropTable {
+			fmt.Fprintf(w, "DROP %s IF EXISTS `%s`;\n", object.Type, object.Name)
+		}
+		// The body of the triggers, routines and events can have semicolons.
+		fmt.Fprintf(w, "DELIMITER ;;\n%s;;\nDELIMITER ;\n", object.CreateSQL)
+	}
+
+	fmt.Fprintf(w, "\nSET SQL_MODE=@OLD_SQL_MODE, TIME_ZONE=@OLD_TIME_ZONE, "+
+		"CHARACTER_SET_CLIENT=@OLD_CHARACTER_SET_CLIENT, COLLATION_CONNECTION=@OLD_COLLATION_CONNECTION;\n")
+}
@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteSchemaObjects(t *testing.T) {
+	objects := []*SchemaObject{
+		{Type: SchemaObjectView, Name: "v_b", Columns: []string{"id"}, CreateSQL: "CREATE VIEW `v_b` AS SELECT id FROM v_a",
+			CharacterSet: "utf8mb4", Collation: "utf8mb4_0900_ai_ci"},
+		{Type: SchemaObjectView, Name: "v_a", Columns: []string{"id", "zip code"}, CreateSQL: "CREATE VIEW `v_a` AS SELECT 1"},
+		{Type: SchemaObjectTrigger, Name: "t_bi", Table: "t", SQLMode: "STRICT_TRANS_TABLES",
+			CreateSQL: "CREATE TRIGGER `t_bi` BEFORE INSERT ON `t` FOR EACH ROW BEGIN SET NEW.a = 1; END"},
+		{Type: SchemaObjectEvent, Name: "e", TimeZone: "SYSTEM", CreateSQL: "CREATE EVENT `e` ON SCHEDULE EVERY 1 DAY DO SELECT 1"},
+	}
+
+	var buffer bytes.Buffer
+	writeSchemaObjects(&buffer, objects, true)
+	content := buffer.String()
+	for _, expected := range []string{
+		"DROP VIEW IF EXISTS `v_a`;\nCREATE VIEW `v_a` AS SELECT 1 AS `id`, 1 AS `zip code`;\n",
+		"-- View v_b\nSET CHARACTER_SET_CLIENT=utf8mb4;\nSET COLLATION_CONNECTION=utf8mb4_0900_ai_ci;\n" +
+			"DROP VIEW IF EXISTS `v_b`;\nCREATE VIEW `v_b` AS SELECT id FROM v_a;\n",
+		"-- Trigger t_bi\nSET SQL_MODE='STRICT_TRANS_TABLES';\nDROP TRIGGER IF EXISTS `t_bi`;\nDELIMITER ;;\n" +
+			"CREATE TRIGGER `t_bi` BEFORE INSERT ON `t` FOR EACH ROW BEGIN SET NEW.a = 1; END;;\nDELIMITER ;\n",
+		"SET SQL_MODE='';\nSET TIME_ZONE='SYSTEM';\nDROP EVENT IF EXISTS `e`;\n",
+		"SET SQL_MODE=@OLD_SQL_MODE,",
+	} {
+		if !strings.Contains(content, expected) {
+			t.Fatalf("The file doesn't contain %q:\n%s", expected, content)
+		}
+	}
+	// All the placeholders are created before the first view.
+	if strings.Index(content, "AS SELECT 1 AS `id`;") > strings.Index(content, "-- View") {
+		t.Fatalf("The placeholders are not created first:\n%s", content)
+	}
+
+	// The file can be read by the restore.
+	reader := NewStatementReader(strings.NewReader(content))
+	var statements []string
+	for {
+		statement, err := reader.Next()
+		if err != nil {
+			break
+		}
+		statements = append(statements, statement)
+	}
+	if last := statements[len(statements)-1]; !strings.HasPrefix(last, "SET SQL_MODE=@OLD_SQL_MODE") {
+		t.Fatalf("Unexpected last statement %q", last)
+	}
+}
+
+func TestGetViews(t *testing.T) {
+	db, err := GetMySQLConnection(getMySQLHost(), getMySQLCredentials())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	views, err := GetViews(db, "sakila")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, view := range views {
+		if view.Name != "staff_list" {
+			continue
+		}
+		if len(view.Columns) == 0 || view.Columns[0] != "ID" || !strings.Contains(view.CreateSQL, "VIEW `staff_list`") {
+			t.Fatalf("Unexpected view %+v", view)
+		}
+		return
+	}
+	t.Fatalf("The view staff_list was not found in %v", views)
+}
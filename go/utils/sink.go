@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Sink is the destination of the files of a dump. The TaskManager creates
+// one object for each chunk, definition, master data and slave data file.
+// Library users can implement it to write the dump somewhere else than the
+// local disk.
+type Sink interface {
+	// Create opens a new object for writing. The name is relative to the
+	// destination, for example "sakila.city-thread0.sql.gz".
+	Create(name string) (SinkWriter, error)
+
+	// Commit is called once all the objects are written and closed, so the
+	// sink can make the dump visible.
+	Commit() error
+}
+
+// SinkWriter is an object of a Sink opened for writing. The object is
+// complete only when Close returns without error.
+type SinkWriter interface {
+	io.Writer
+	Close() error
+}
+
+// SinkAborter is implemented by the writers that can discard an incomplete
+// object instead of completing it, when a dump fails.
+type SinkAborter interface {
+	Abort() error
+}
+
+// SinkOpenFilesLimiter is implemented by the sinks that keep the data of the
+// open objects in memory. Each worker keeps at most MaxOpenFiles data files
+// open, it closes the one used the longest time ago to open another one.
+type SinkOpenFilesLimiter interface {
+	MaxOpenFiles() int
+}
+
+// PartialExtension is the suffix of the files left incomplete by a failed
+// dump.
+const PartialExtension = ".partial"
+
+// FileSink writes the objects as files in a local directory.
+type FileSink struct {
+	Dir string
+}
+
+// NewFileSink creates a sink for the directory.
+func NewFileSink(dir string) *FileSink {
+	return &FileSink{Dir: dir}
+}
+
+// Create creates the file, and the directory if it doesn't exist.
+func (s *FileSink) Create(name string) (SinkWriter, error) {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.Create(filepath.Join(s.Dir, name))
+	if err != nil {
+		return nil, err
+	}
+	return &fileWriter{File: file}, nil
+}
+
+// Commit does nothing, the files are ready when they are closed.
+func (s *FileSink) Commit() error {
+	return nil
+}
+
+// fileWriter is a file of a FileSink.
+type fileWriter struct {
+	*os.File
+}
+
+// Abort closes the file and renames it with the ".partial" suffix, so it's
+// not taken as a complete file of the dump.
+func (f *fileWriter) Abort() error {
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), f.Name()+PartialExtension)
+}
@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// memorySink keeps the objects in memory.
+type memorySink struct {
+	objects   map[string]*bytes.Buffer
+	closed    map[string]bool
+	committed bool
+}
+
+type memoryObject struct {
+	*bytes.Buffer
+	sink *memorySink
+	name string
+}
+
+func (o *memoryObject) Close() error {
+	o.sink.closed[o.name] = true
+	return nil
+}
+
+func (s *memorySink) Create(name string) (SinkWriter, error) {
+	s.objects[name] = new(bytes.Buffer)
+	return &memoryObject{Buffer: s.objects[name], sink: s, name: name}, nil
+}
+
+func (s *memorySink) Commit() error {
+	s.committed = true
+	return nil
+}
+
+func TestNewSinkBuffer(t *testing.T) {
+	sink := &memorySink{objects: map[string]*bytes.Buffer{}, closed: map[string]bool{}}
+
+	buffer, err := NewBuffer(&BufferOptions{Type: BufferTypeFile, Name: "sakila.city.sql", Sink: sink})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buffer.Write([]byte("plain"))
+	if err := buffer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	buffer, err = NewBuffer(&BufferOptions{Type: BufferTypeFile, Name: "sakila.actor.sql", Sink: sink,
+		Compress: true, CompressLevel: gzip.BestSpeed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buffer.FileName != "sakila.actor.sql.gz" || buffer.Type != BufferTypeGzipFile {
+		t.Fatalf("Unexpected buffer %s of type %s", buffer.FileName, buffer.Type)
+	}
+	buffer.Write([]byte("compressed"))
+	if err := buffer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	stored := sha256.Sum256(sink.objects["sakila.actor.sql.gz"].Bytes())
+	data := sha256.Sum256([]byte("compressed"))
+	if buffer.Checksum() != hex.EncodeToString(stored[:]) || buffer.DataChecksum() != hex.EncodeToString(data[:]) ||
+		buffer.Size() != uint64(sink.objects["sakila.actor.sql.gz"].Len()) {
+		t.Fatalf("Unexpected checksums %s %s", buffer.Checksum(), buffer.DataChecksum())
+	}
+
+	if got := sink.objects["sakila.city.sql"].String(); got != "plain" || !sink.closed["sakila.city.sql"] {
+		t.Fatalf("Unexpected object sakila.city.sql: %q", got)
+	}
+	reader, err := gzip.NewReader(sink.objects["sakila.actor.sql.gz"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "compressed" || !sink.closed["sakila.actor.sql.gz"] {
+		t.Fatalf("Unexpected object sakila.actor.sql.gz: %q", got)
+	}
+
+	if _, err := NewBuffer(&BufferOptions{Type: BufferTypeFile, Name: "sakila.city.sql"}); err == nil {
+		t.Fatal("Expected an error for a buffer without sink")
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "dump")
+	buffer, err := NewSinkBuffer(NewFileSink(dir), "master-data.sql", CompressAlgorithmGzip, gzip.DefaultCompression, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buffer.Write([]byte("File: mysql-bin.000001"))
+	if err := buffer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := NewFileReader(filepath.Join(dir, "master-data.sql.gz"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "File: mysql-bin.000001" {
+		t.Fatalf("Unexpected content %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "master-data.sql")); !os.IsNotExist(err) {
+		t.Fatal("Expected only the compressed file")
+	}
+}
+
+func TestAbortFileSink(t *testing.T) {
+	dir := t.TempDir()
+	buffer, err := NewSinkBuffer(NewFileSink(dir), "sakila.city-thread0.sql", CompressAlgorithmGzip,
+		gzip.DefaultCompression, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buffer.Write([]byte("INSERT INTO `city` VALUES (1"))
+	if err := buffer.Abort(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the partial file is kept, so it's removed when the dump is resumed.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "sakila.city-thread0.sql.gz"+PartialExtension {
+		t.Fatalf("Unexpected files %v", entries)
+	}
+	if !dataFileRegexp(&Table{schema: "sakila", name: "city"}, "sql").MatchString(entries[0].Name()) {
+		t.Fatal("The partial file should be a data file of the table")
+	}
+}
@@ -3,19 +3,35 @@ package utils
 import (
 	"database/sql"
 	"fmt"
-
-	"github.com/outbrain/golib/log"
+	"strings"
 )
 
 type ColumnsMap map[string]int
 
+// Column contains the name and type of a column of a table.
+type Column struct {
+	Name        string
+	DataType    string
+	ColumnType  string
+	Precision   int
+	Scale       int
+	IsNullable  bool
+	IsGenerated bool
+}
+
+// IsUnsigned return true for the numeric columns defined as UNSIGNED.
+func (c *Column) IsUnsigned() bool {
+	return strings.Contains(c.ColumnType, "unsigned")
+}
+
 // Table contains the name and type of a table.
 type Table struct {
 	name            string
 	schema          string
 	primaryKey      []string
 	uniqueKey       []string
-	keyForChunks    string
+	keyForChunks    []string
+	columns         []*Column
 	estNumberOfRows uint64
 	estDataSize     uint64
 	estIndexSize    uint64
@@ -26,15 +42,54 @@ type Table struct {
 	Collation      string
 }
 
-// getColumnsInformationSQL return the SQL statment to get the columns
-// information of a table
+// getKeysInformationSQL return the SQL statment to get the columns of the
+// primary and unique keys of a table, in the order that they are defined in
+// each key.
+func (t *Table) getKeysInformationSQL() string {
+	return fmt.Sprintf(`SELECT s.INDEX_NAME, s.COLUMN_NAME, c.DATA_TYPE, c.IS_NULLABLE,
+		s.SUB_PART IS NOT NULL
+		FROM INFORMATION_SCHEMA.STATISTICS s
+		JOIN INFORMATION_SCHEMA.COLUMNS c ON c.TABLE_SCHEMA = s.TABLE_SCHEMA
+			AND c.TABLE_NAME = s.TABLE_NAME AND c.COLUMN_NAME = s.COLUMN_NAME
+		WHERE s.TABLE_SCHEMA='%s' AND s.TABLE_NAME='%s' AND s.NON_UNIQUE = 0
+		ORDER BY s.INDEX_NAME = 'PRIMARY' DESC, s.INDEX_NAME, s.SEQ_IN_INDEX`,
+		t.GetUnescapedSchema(), t.GetUnescapedName())
+}
+
+// getColumnsInformationSQL return the SQL statement to get the columns of the
+// table in the same order as SELECT *.
 func (t *Table) getColumnsInformationSQL() string {
-	return fmt.Sprintf(`SELECT COLUMN_NAME,COLUMN_KEY
+	return fmt.Sprintf(`SELECT COLUMN_NAME, DATA_TYPE, COLUMN_TYPE,
+		IFNULL(NUMERIC_PRECISION, 0), IFNULL(NUMERIC_SCALE, 0), IS_NULLABLE, EXTRA
 		FROM INFORMATION_SCHEMA.COLUMNS
 		WHERE TABLE_SCHEMA='%s' AND TABLE_NAME='%s'
-		  AND COLUMN_KEY IN ('PRI','UNI','MUL')
-			AND DATA_TYPE IN ('tinyint','smallint','int','mediumint','bigint','timestamp')
-				`, t.GetUnescapedSchema(), t.GetUnescapedName())
+		ORDER BY ORDINAL_POSITION`,
+		t.GetUnescapedSchema(), t.GetUnescapedName())
+}
+
+// isGeneratedColumn return true if the EXTRA information of a column is
+// from a virtual or stored generated column. The DEFAULT_GENERATED value of
+// MySQL 8 is used for expression defaults, which are regular columns.
+func isGeneratedColumn(extra string) bool {
+	extra = strings.ToUpper(extra)
+	return strings.Contains(extra, "VIRTUAL GENERATED") ||
+		strings.Contains(extra, "STORED GENERATED") ||
+		strings.Contains(extra, "PERSISTENT GENERATED")
+}
+
+// isChunkableType return true if the data type can be used to split a table
+// in chunks. The values are compared by the server using the column type and
+// collation, so any type with a stable order can be used. Floating point,
+// ENUM and SET columns are excluded because the order or the comparison of
+// the values is not reliable.
+func isChunkableType(dataType string) bool {
+	switch strings.ToLower(dataType) {
+	case "tinyint", "smallint", "int", "mediumint", "bigint", "decimal",
+		"char", "varchar", "binary", "varbinary",
+		"date", "datetime", "timestamp", "time", "year":
+		return true
+	}
+	return false
 }
 
 /*
@@ -91,26 +146,72 @@ func (t *Table) GetUnescapedFullName() string {
 	return fmt.Sprintf("%s.%s", t.schema, t.name)
 }
 
-// GetPrimaryOrUniqueKey return a string with the name of the unique or primary
-// key filed that we will use to split the table.
-// Empty string means that the table doens't have any primary or unique key to use.
-func (t *Table) GetPrimaryOrUniqueKey() string {
+// GetEstimatedRows return the number of rows of the table in the statistics
+// of the server.
+func (t *Table) GetEstimatedRows() uint64 {
+	return t.estNumberOfRows
+}
+
+// GetEstimatedDataSize return the size in bytes of the data of the table in
+// the statistics of the server.
+func (t *Table) GetEstimatedDataSize() uint64 {
+	return t.estDataSize
+}
+
+// GetColumns return the columns of the table in the order of SELECT *.
+func (t *Table) GetColumns() []*Column {
+	return t.columns
+}
+
+// GetKeyForChunks return the columns of the primary or unique key that we
+// will use to split the table. A nil slice means that the table doesn't have
+// any primary or unique key to use.
+func (t *Table) GetKeyForChunks() []string {
 
 	if len(t.keyForChunks) > 0 {
 		return t.keyForChunks
 	}
 
-	if len(t.primaryKey) == 1 {
-		t.keyForChunks = t.primaryKey[0]
+	if len(t.primaryKey) > 0 {
+		t.keyForChunks = t.primaryKey
 		return t.keyForChunks
 	}
 
 	if len(t.uniqueKey) > 0 {
-		t.keyForChunks = t.uniqueKey[0]
+		t.keyForChunks = t.uniqueKey
 		return t.keyForChunks
 	}
 
-	return ""
+	return nil
+}
+
+// GetPrimaryOrUniqueKey return a string with the comma separated names of the
+// unique or primary key fields that we will use to split the table.
+// Empty string means that the table doens't have any primary or unique key to use.
+func (t *Table) GetPrimaryOrUniqueKey() string {
+	return strings.Join(t.GetKeyForChunks(), ",")
+}
+
+// GetEscapedKeyForChunks return the escaped key columns ready to use in a
+// SELECT or an ORDER BY.
+func (t *Table) GetEscapedKeyForChunks() string {
+	var columns []string
+	for _, column := range t.GetKeyForChunks() {
+		columns = append(columns, fmt.Sprintf("`%s`", column))
+	}
+	return strings.Join(columns, ",")
+}
+
+// GetKeyConditionSQL return a condition comparing the key used for chunks
+// with placeholders using the operator. Keys with more than one column use
+// a row constructor, for example "(`a`,`b`) >= (?,?)".
+func (t *Table) GetKeyConditionSQL(operator string) string {
+	key := t.GetKeyForChunks()
+	if len(key) == 1 {
+		return fmt.Sprintf("%s %s ?", t.GetEscapedKeyForChunks(), operator)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(key)), ",")
+	return fmt.Sprintf("(%s) %s (%s)", t.GetEscapedKeyForChunks(), operator, placeholders)
 }
 
 // getTableInformation collect and store the table information
@@ -119,7 +220,7 @@ func (t *Table) getTableInformation(db *sql.DB) error {
 	var tableName string
 	err := db.QueryRow(fmt.Sprintf("SHOW CREATE TABLE %s", t.GetFullName())).Scan(&tableName, &t.CreateTableSQL)
 	if err != nil {
-		log.Fatalf("Error getting show create table: %s", err.Error())
+		return fmt.Errorf("error getting show create table: %w", err)
 	}
 
 	query := fmt.Sprintf(`SELECT ENGINE, TABLE_COLLATION, DATA_LENGTH, INDEX_LENGTH,
@@ -131,41 +232,101 @@ func (t *Table) getTableInformation(db *sql.DB) error {
 	return err
 }
 
+// getColumnsInformation collect and store the columns of the table.
+func (t *Table) getColumnsInformation(db *sql.DB) error {
+	rows, err := db.Query(t.getColumnsInformationSQL())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	t.columns = nil
+	for rows.Next() {
+		var name, dataType, columnType, nullable, extra string
+		var precision, scale int
+		if err := rows.Scan(&name, &dataType, &columnType, &precision, &scale, &nullable, &extra); err != nil {
+			return err
+		}
+		t.columns = append(t.columns, &Column{
+			Name:        name,
+			DataType:    strings.ToLower(dataType),
+			ColumnType:  strings.ToLower(columnType),
+			Precision:   precision,
+			Scale:       scale,
+			IsNullable:  nullable == "YES",
+			IsGenerated: isGeneratedColumn(extra)})
+	}
+	return rows.Err()
+}
+
 // getData collect the table information
 func (t *Table) getData(db *sql.DB) error {
 
-	t.getTableInformation(db)
+	if err := t.getTableInformation(db); err != nil {
+		return newError(ErrSchema, "error getting the information of the table %s: %w", t.GetFullName(), err)
+	}
 
-	rows, err := db.Query(t.getColumnsInformationSQL())
+	if err := t.getColumnsInformation(db); err != nil {
+		return newError(ErrSchema, "error getting the columns for table %s: %w", t.GetFullName(), err)
+	}
+
+	rows, err := db.Query(t.getKeysInformationSQL())
 
 	if err != nil {
-		log.Fatal("Error getting column details for table ", t.GetFullName(), " : ", err.Error())
+		return newError(ErrSchema, "error getting column details for table %s: %w", t.GetFullName(), err)
 	}
+	defer rows.Close()
 
-	var cName, cKey string
+	// A key can be used only if all the columns have a type that we can
+	// chunk and the values can not be NULL.
+	var (
+		keys                       = make(map[string][]string)
+		validKeys                  = make(map[string]bool)
+		keyNames                   []string
+		kName, cName, cType, cNull string
+		isPrefix                   bool
+	)
 
 	for rows.Next() {
-		rows.Scan(&cName, &cKey)
-		switch cKey {
-		case "PRI":
-			t.primaryKey = append(t.primaryKey, cName)
-		case "UNI":
-			t.uniqueKey = append(t.uniqueKey, cName)
-		default:
+		if err := rows.Scan(&kName, &cName, &cType, &cNull, &isPrefix); err != nil {
+			return newError(ErrSchema, "error getting column details for table %s: %w", t.GetFullName(), err)
+		}
+		if _, ok := keys[kName]; !ok {
+			keyNames = append(keyNames, kName)
+			validKeys[kName] = true
+		}
+		keys[kName] = append(keys[kName], cName)
+		if !isChunkableType(cType) || cNull == "YES" || isPrefix {
+			validKeys[kName] = false
+		}
+	}
 
+	for _, kName := range keyNames {
+		if !validKeys[kName] {
+			continue
 		}
+		if kName == "PRIMARY" {
+			t.primaryKey = keys[kName]
+		} else if len(t.uniqueKey) == 0 {
+			t.uniqueKey = keys[kName]
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return newError(ErrSchema, "error getting column details for table %s: %w", t.GetFullName(), err)
 	}
 	return nil
 }
 
-// NewTable create a new Table object.
-func NewTable(schema string, name string, db *sql.DB) *Table {
+// NewTable create a new Table object with the information of the server.
+func NewTable(schema string, name string, db *sql.DB) (*Table, error) {
 	table := &Table{
 		name:     name,
 		schema:   schema,
 		IsLocked: false,
 	}
 
-	table.getData(db)
-	return table
+	if err := table.getData(db); err != nil {
+		return nil, err
+	}
+	return table, nil
 }
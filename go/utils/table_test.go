@@ -1,6 +1,9 @@
 package utils
 
-import "testing"
+import (
+	"errors"
+	"testing"
+)
 
 var table1 = &Table{
 	name:       "table1",
@@ -20,6 +23,13 @@ var table3 = &Table{
 	IsLocked:  false,
 	uniqueKey: []string{"uk"},
 }
+var table4 = &Table{
+	name:       "table4",
+	schema:     "schema4",
+	IsLocked:   false,
+	primaryKey: []string{"pk1", "pk2"},
+	uniqueKey:  []string{"uk"},
+}
 
 func TestTable(t *testing.T) {
 	tables := []struct {
@@ -33,6 +43,7 @@ func TestTable(t *testing.T) {
 		{table1, "`table1`", "`schema1`", "`schema1`.`table1`", "schema1.table1", "pk"},
 		{table2, "`table2`", "`schema2`", "`schema2`.`table2`", "schema2.table2", "pk"},
 		{table3, "`table3`", "`schema3`", "`schema3`.`table3`", "schema3.table3", "uk"},
+		{table4, "`table4`", "`schema4`", "`schema4`.`table4`", "schema4.table4", "pk1,pk2"},
 	}
 
 	for _, tt := range tables {
@@ -57,3 +68,28 @@ func TestTable(t *testing.T) {
 	}
 
 }
+
+func TestGetKeyConditionSQL(t *testing.T) {
+	tests := []struct {
+		table    *Table
+		operator string
+		expect   string
+	}{
+		{table1, ">=", "`pk` >= ?"},
+		{table3, "<", "`uk` < ?"},
+		{table4, ">=", "(`pk1`,`pk2`) >= (?,?)"},
+		{table4, "<", "(`pk1`,`pk2`) < (?,?)"},
+	}
+
+	for _, tt := range tests {
+		if condition := tt.table.GetKeyConditionSQL(tt.operator); condition != tt.expect {
+			t.Errorf("Got \"%s\" and expected \"%s\"", condition, tt.expect)
+		}
+	}
+}
+
+func TestNewTableMissing(t *testing.T) {
+	if _, err := NewTable("sakila", "missing_table", tmdb); !errors.Is(err, ErrSchema) {
+		t.Fatalf("Expected a schema error and got %v", err)
+	}
+}
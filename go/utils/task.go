@@ -1,10 +1,14 @@
 package utils
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
 
-	"github.com/outbrain/golib/log"
+	"github.com/ChaosHour/go-dump/go/log"
 )
 
 type Task struct {
@@ -15,91 +19,273 @@ type Task struct {
 	Tx              *sql.Tx
 	Id              int64
 	TotalChunks     uint64
-	chunkMin        int64
-	chunkMax        int64
+	chunkMin        []interface{}
+	chunkMax        []interface{}
+	dataFiles       []string
+	files           []*DumpFile
+	filesMutex      sync.Mutex
+	rows            uint64
+	bytes           uint64 // bytes of data, before the compression and the encryption
+	chunksDone      uint64
+	chunksPlanned   atomic.Bool // all the chunks are created
+	rowChecksum     uint64
+	chunkRanges     []*ChunkRange
+	filePart        int
+	resumed         bool          // the chunks come from the checkpoint
+	resumeChunks    []*ChunkRange // chunks to dump when resuming
 }
 
-func (t *Task) AddChunk(chunk DataChunk) {
-	t.TaskManager.AddChunk(chunk)
-	t.TotalChunks = t.TotalChunks + 1
+// AddDataFile records the name of a file with data of the table. The workers
+// call it when they create a new file.
+func (t *Task) AddDataFile(fileName string) {
+	t.filesMutex.Lock()
+	defer t.filesMutex.Unlock()
+	t.dataFiles = append(t.dataFiles, fileName)
+}
+
+// GetDataFiles return the sorted names of the files with data of the table.
+func (t *Task) GetDataFiles() []string {
+	t.filesMutex.Lock()
+	defer t.filesMutex.Unlock()
+	files := append([]string(nil), t.dataFiles...)
+	sort.Strings(files)
+	return files
+}
+
+// AddFile records a file of the table once the buffer is closed, with the
+// size written to the sink.
+func (t *Task) AddFile(fileType string, buffer *Buffer) {
+	t.filesMutex.Lock()
+	defer t.filesMutex.Unlock()
+	t.files = append(t.files, newDumpFile(fileType, buffer))
+}
+
+// GetFiles return the files of the table sorted by name.
+func (t *Task) GetFiles() []*DumpFile {
+	t.filesMutex.Lock()
+	defer t.filesMutex.Unlock()
+	files := append([]*DumpFile{}, t.files...)
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files
+}
+
+// AddRows adds the rows dumped by a chunk.
+func (t *Task) AddRows(rows uint64) {
+	atomic.AddUint64(&t.rows, rows)
+}
+
+// GetRows return the number of rows dumped from the table.
+func (t *Task) GetRows() uint64 {
+	return atomic.LoadUint64(&t.rows)
+}
+
+// AddBytes adds the bytes of data written for the table.
+func (t *Task) AddBytes(bytes uint64) {
+	atomic.AddUint64(&t.bytes, bytes)
+}
+
+// GetBytes return the bytes of data written for the table, before the
+// compression and the encryption.
+func (t *Task) GetBytes() uint64 {
+	return atomic.LoadUint64(&t.bytes)
+}
+
+// chunkDone records a chunk dumped with the bytes of data written for it.
+func (t *Task) chunkDone(bytes uint64) {
+	atomic.AddUint64(&t.chunksDone, 1)
+	t.AddBytes(bytes)
+}
+
+// AddRowChecksum adds the checksum of the rows of a chunk. The checksum of
+// the table is the sum of the checksums of its rows, so it doesn't depend
+// on the order of the chunks or the rows.
+func (t *Task) AddRowChecksum(checksum uint64) {
+	atomic.AddUint64(&t.rowChecksum, checksum)
+}
+
+// GetRowChecksum return the checksum of the rows dumped from the table.
+func (t *Task) GetRowChecksum() uint64 {
+	return atomic.LoadUint64(&t.rowChecksum)
+}
+
+// NextFilePart return the number of the next data file of the table for a
+// worker that already has a file, or for any worker when resuming.
+func (t *Task) NextFilePart() int {
+	t.filesMutex.Lock()
+	defer t.filesMutex.Unlock()
+	t.filePart++
+	return t.filePart
+}
+
+// AddChunkRange records a chunk once it's dumped.
+func (t *Task) AddChunkRange(chunkRange *ChunkRange) {
+	t.filesMutex.Lock()
+	defer t.filesMutex.Unlock()
+	t.chunkRanges = append(t.chunkRanges, chunkRange)
+}
+
+// GetChunkRanges return the chunks dumped sorted by sequence.
+func (t *Task) GetChunkRanges() []*ChunkRange {
+	t.filesMutex.Lock()
+	defer t.filesMutex.Unlock()
+	ranges := append([]*ChunkRange{}, t.chunkRanges...)
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Sequence < ranges[j].Sequence })
+	return ranges
+}
+
+// AddChunk queues a chunk to dump. It return false if the dump failed and
+// the chunk was not queued.
+func (t *Task) AddChunk(chunk DataChunk) bool {
+	t.TaskManager.checkpoint.Write(&CheckpointEvent{Event: CheckpointChunkPlanned,
+		Schema: t.Table.GetUnescapedSchema(), Table: t.Table.GetUnescapedName(), Chunk: chunk.GetChunkRange(0, 0)})
+	if !t.TaskManager.AddChunk(chunk) {
+		return false
+	}
+	atomic.AddUint64(&t.TotalChunks, 1)
 	t.TaskManager.TotalChunks = t.TaskManager.TotalChunks + 1
 	t.TaskManager.Queue = t.TaskManager.Queue + 1
-	t.chunkMin = t.chunkMax + 1
+	t.chunkMin = t.chunkMax
 	log.Debugf("Queue +1: %d ", t.TaskManager.Queue)
+	return true
 }
 
 func (t *Task) GetSingleChunkTestQuery() string {
 	return fmt.Sprintf("SELECT 1 FROM %s LIMIT 1 ", t.Table.GetFullName())
 }
 
+// GetFirstChunkSqlQuery return the query to get the lowest key of the table,
+// where the first chunk starts.
+func (t *Task) GetFirstChunkSqlQuery() string {
+	keyForChunks := t.Table.GetEscapedKeyForChunks()
+
+	return fmt.Sprintf("SELECT %s FROM %s ORDER BY %s LIMIT 1",
+		keyForChunks, t.Table.GetFullName(), keyForChunks)
+}
+
+// GetChunkSqlQuery return the query to get the key where the next chunk
+// starts. The current chunk starts on the values bound to the placeholders.
 func (t *Task) GetChunkSqlQuery() string {
-	keyForChunks := t.Table.GetPrimaryOrUniqueKey()
+	keyForChunks := t.Table.GetEscapedKeyForChunks()
 
-	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s >= %d LIMIT 1 OFFSET %d", keyForChunks, t.Table.GetFullName(), keyForChunks, t.chunkMax, t.ChunkSize)
+	return fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY %s LIMIT 1 OFFSET %d",
+		keyForChunks, t.Table.GetFullName(), t.Table.GetKeyConditionSQL(">="),
+		keyForChunks, t.ChunkSize)
+}
 
-	return query
+// scanKey reads a row with the key values. The values are kept as returned
+// by the driver ([]byte, int64, ...) so they can be bound again as query
+// parameters whatever the type of the key is.
+func (t *Task) scanKey(row *sql.Row) ([]interface{}, error) {
+	values := make([]interface{}, len(t.Table.GetKeyForChunks()))
+	dest := make([]interface{}, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	return values, row.Scan(dest...)
 }
 
-func (t *Task) GetLastChunkSqlQuery() string {
-	keyForChunks := t.Table.GetPrimaryOrUniqueKey()
+// planned records that all the chunks of the table are created.
+func (t *Task) planned() {
+	t.chunksPlanned.Store(true)
+	t.TaskManager.checkpoint.Write(&CheckpointEvent{Event: CheckpointTablePlanned,
+		Schema: t.Table.GetUnescapedSchema(), Table: t.Table.GetUnescapedName(), Chunks: t.TotalChunks})
+}
 
-	return fmt.Sprintf("SELECT %s FROM %s WHERE %s >= %d LIMIT 1",
-		keyForChunks, t.Table.GetFullName(), keyForChunks, t.chunkMin)
+// ResumeChunks queues the chunks of the checkpoint that were not dumped.
+func (t *Task) ResumeChunks(ctx context.Context) {
+	defer t.TaskManager.CreateChunksWaitGroup.Done()
+	_, stop := t.TaskManager.bindContext(ctx)
+	defer stop()
+	for _, chunkRange := range t.resumeChunks {
+		if !t.TaskManager.AddChunk(NewDataChunkFromRange(t, chunkRange)) {
+			return
+		}
+		t.TaskManager.TotalChunks = t.TaskManager.TotalChunks + 1
+		t.TaskManager.Queue = t.TaskManager.Queue + 1
+	}
+	t.chunksPlanned.Store(true)
 }
 
-func (t *Task) CreateChunks(db *sql.DB) {
-	t.TotalChunks = 0
-	t.chunkMax = 0
-	t.chunkMin = 0
+// CreateChunks splits the table in chunks and queues them until ctx is
+// done. An error fails the dump in the TaskManager.
+func (t *Task) CreateChunks(ctx context.Context, db *sql.DB) {
+	atomic.StoreUint64(&t.TotalChunks, 0)
+	t.chunkMax = nil
+	t.chunkMin = nil
 
 	var (
 		tx       = db
 		chunkMax = int64(0)
-		chunkMin = int64(0)
-		stopLoop = false
 	)
 
 	defer func() {
 		t.TaskManager.CreateChunksWaitGroup.Done()
 	}()
+	ctx, stop := t.TaskManager.bindContext(ctx)
+	defer stop()
 
-	if len(t.Table.GetPrimaryOrUniqueKey()) == 0 {
+	if len(t.Table.GetKeyForChunks()) == 0 {
 		switch t.TaskManager.TablesWithoutPKOption {
 		case "single-chunk":
 			log.Debugf(`Table %s doesn't have any primary or unique key, we will make it in a single chunk.`, t.Table.GetFullName())
-			err := tx.QueryRow(t.GetSingleChunkTestQuery()).Scan(&chunkMax)
+			err := tx.QueryRowContext(ctx, t.GetSingleChunkTestQuery()).Scan(&chunkMax)
 			switch err {
 			case nil:
-				t.AddChunk(NewSingleDataChunk(t))
+				if t.AddChunk(NewSingleDataChunk(t)) {
+					t.planned()
+				}
 			case sql.ErrNoRows:
+				t.planned()
 				return
 			default:
-				log.Errorf("Error getting rows for table '%s'", t.Table.GetUnescapedFullName())
+				t.TaskManager.Fail(newError(ErrData, "error getting rows for table %s: %w",
+					t.Table.GetFullName(), err))
 			}
 			return
 		case "error":
-			log.Fatalf(`The table %s doesn't have any primary or unique key and the --tables-without-uniquekey is "error"`, t.Table.GetFullName())
+			t.TaskManager.Fail(newError(ErrSchema, `the table %s doesn't have any primary or unique key and the `+
+				`--tables-without-uniquekey is "error"`, t.Table.GetFullName()))
+			return
 		}
 	}
 
-	for !stopLoop {
-
-		err := tx.QueryRow(t.GetChunkSqlQuery()).Scan(&chunkMax)
-		if err != nil && err == sql.ErrNoRows {
+	chunkMin, err := t.scanKey(tx.QueryRowContext(ctx, t.GetFirstChunkSqlQuery()))
+	switch err {
+	case nil:
+		t.chunkMin = chunkMin
+	case sql.ErrNoRows:
+		log.Debugf("Table %s is empty", t.Table.GetFullName())
+		t.planned()
+		return
+	default:
+		t.TaskManager.Fail(newError(ErrData, "error getting the first chunk of the table %s: %w",
+			t.Table.GetFullName(), err))
+		return
+	}
 
-			err := tx.QueryRow(t.GetLastChunkSqlQuery()).Scan(&chunkMin)
-			if err == nil {
-				t.AddChunk(NewDataLastChunk(t))
+	query := t.GetChunkSqlQuery()
+	for {
+		chunkMax, err := t.scanKey(tx.QueryRowContext(ctx, query, t.chunkMin...))
+		if err == sql.ErrNoRows {
+			if !t.AddChunk(NewDataLastChunk(t)) {
+				return
 			}
-			stopLoop = true
-		} else {
-			t.chunkMax = chunkMax
-			t.AddChunk(NewDataChunk(t))
+			break
+		}
+		if err != nil {
+			t.TaskManager.Fail(newError(ErrData, "error getting the chunks of the table %s: %w",
+				t.Table.GetFullName(), err))
+			return
+		}
+		t.chunkMax = chunkMax
+		if !t.AddChunk(NewDataChunk(t)) {
+			return
 		}
 	}
+	t.planned()
 
-	log.Debugf("Table processed %s - %d chunks created",
-		t.Table.GetFullName(), t.TotalChunks)
+	log.With("table", t.Table.GetUnescapedFullName(), "chunks", t.TotalChunks).Debugf(
+		"Table processed %s - %d chunks created", t.Table.GetFullName(), t.TotalChunks)
 
 }
 
@@ -113,15 +299,21 @@ func (t *Task) PrintInfo() {
 	log.Infof("Table: %s Engine: %s Estimated Chunks: %v", t.Table.GetUnescapedFullName(), t.Table.Engine, estimatedChunks)
 }
 
+// NewTask creates the task to dump a table, with the information of the
+// table from the server.
 func NewTask(schema string,
 	table string,
 	chunkSize uint64,
 	outputChunkSize uint64,
-	tm *TaskManager) Task {
+	tm *TaskManager) (*Task, error) {
 
-	return Task{
-		Table:           NewTable(schema, table, tm.DB),
+	t, err := NewTable(schema, table, tm.DB)
+	if err != nil {
+		return nil, err
+	}
+	return &Task{
+		Table:           t,
 		ChunkSize:       chunkSize,
 		OutputChunkSize: outputChunkSize,
-		TaskManager:     tm}
+		TaskManager:     tm}, nil
 }
@@ -4,14 +4,23 @@ import (
 	"testing"
 )
 
-var task1 = NewTask("sakila", "city", 1000, 1000, &taskManager)
-var task2 = NewTask("sakila", "country", 1000, 1000, &taskManager)
-var task3 = NewTask("sakila", "store_no_pk", 1000, 1000, &taskManager)
+func newTestTask(schema string, table string) *Task {
+	task, err := NewTask(schema, table, 1000, 1000, &taskManager)
+	if err != nil {
+		panic(err)
+	}
+	return task
+}
+
+var task1 = newTestTask("sakila", "city")
+var task2 = newTestTask("sakila", "country")
+var task3 = newTestTask("sakila", "store_no_pk")
+var task4 = newTestTask("sakila", "film_actor")
 
 func TestAddTask(t *testing.T) {
-	taskManager.AddTask(&task1)
-	taskManager.AddTask(&task2)
-	taskManager.AddTask(&task3)
+	taskManager.AddTask(task1)
+	taskManager.AddTask(task2)
+	taskManager.AddTask(task3)
 	tasksPool := taskManager.GetTasksPool()
 	if len(tasksPool) != 3 {
 		t.Fatalf("TaskPool is not 3")
@@ -22,7 +31,6 @@ func TestAddTask(t *testing.T) {
 type TaskTest struct {
 	table                      *Table
 	chunkSize, outputChunkSize uint64
-	chunkMax, chunkMin         int64
 	expect                     string
 }
 
@@ -31,23 +39,21 @@ func TestTaskGetChunkSqlQuery(t *testing.T) {
 	var tablesChunk = []TaskTest{
 		{table: task1.Table,
 			chunkSize: 100,
-			chunkMax:  1570,
-			expect:    "SELECT city_id FROM `sakila`.`city` WHERE city_id >= 1570 LIMIT 1 OFFSET 100"},
+			expect:    "SELECT `city_id` FROM `sakila`.`city` WHERE `city_id` >= ? ORDER BY `city_id` LIMIT 1 OFFSET 100"},
 		{table: task2.Table,
 			chunkSize: 1500,
-			chunkMax:  0,
-			expect:    "SELECT country_id FROM `sakila`.`country` WHERE country_id >= 0 LIMIT 1 OFFSET 1500"},
+			expect:    "SELECT `country_id` FROM `sakila`.`country` WHERE `country_id` >= ? ORDER BY `country_id` LIMIT 1 OFFSET 1500"},
 		{table: task3.Table,
 			chunkSize: 500,
-			chunkMax:  1000,
-			expect:    "SELECT manager_staff_id FROM `sakila`.`store_no_pk` WHERE manager_staff_id >= 1000 LIMIT 1 OFFSET 500"},
+			expect:    "SELECT `manager_staff_id` FROM `sakila`.`store_no_pk` WHERE `manager_staff_id` >= ? ORDER BY `manager_staff_id` LIMIT 1 OFFSET 500"},
+		{table: table4,
+			chunkSize: 200,
+			expect:    "SELECT `pk1`,`pk2` FROM `schema4`.`table4` WHERE (`pk1`,`pk2`) >= (?,?) ORDER BY `pk1`,`pk2` LIMIT 1 OFFSET 200"},
 	}
 	for _, tt := range tablesChunk {
 		task := Task{
 			Table:           tt.table,
 			ChunkSize:       tt.chunkSize,
-			chunkMax:        tt.chunkMax,
-			chunkMin:        tt.chunkMin,
 			OutputChunkSize: tt.outputChunkSize,
 			TaskManager:     &taskManager}
 		query := task.GetChunkSqlQuery()
@@ -56,29 +62,26 @@ func TestTaskGetChunkSqlQuery(t *testing.T) {
 		}
 	}
 }
-func TestTaskGetLastChunkSqlQuery(t *testing.T) {
+func TestTaskGetFirstChunkSqlQuery(t *testing.T) {
 
-	var tablesLastChunk = []TaskTest{
+	var tablesFirstChunk = []TaskTest{
 
 		{table: table1,
-			chunkMin: 1570,
-			expect:   "SELECT pk FROM `schema1`.`table1` WHERE pk >= 1570 LIMIT 1"},
+			expect: "SELECT `pk` FROM `schema1`.`table1` ORDER BY `pk` LIMIT 1"},
 		{table: table2,
-			chunkMin: 100,
-			expect:   "SELECT pk FROM `schema2`.`table2` WHERE pk >= 100 LIMIT 1"},
+			expect: "SELECT `pk` FROM `schema2`.`table2` ORDER BY `pk` LIMIT 1"},
 		{table: table3,
-			chunkMin: 500,
-			expect:   "SELECT uk FROM `schema3`.`table3` WHERE uk >= 500 LIMIT 1"},
+			expect: "SELECT `uk` FROM `schema3`.`table3` ORDER BY `uk` LIMIT 1"},
+		{table: table4,
+			expect: "SELECT `pk1`,`pk2` FROM `schema4`.`table4` ORDER BY `pk1`,`pk2` LIMIT 1"},
 	}
-	for _, tt := range tablesLastChunk {
+	for _, tt := range tablesFirstChunk {
 		task := Task{
 			Table:           tt.table,
 			ChunkSize:       tt.chunkSize,
-			chunkMax:        tt.chunkMax,
-			chunkMin:        tt.chunkMin,
 			OutputChunkSize: tt.outputChunkSize,
 			TaskManager:     &taskManager}
-		query := task.GetLastChunkSqlQuery()
+		query := task.GetFirstChunkSqlQuery()
 		if query != tt.expect {
 			t.Errorf("Error: got \n\"%s\" instead of \n\"%s\"", query, tt.expect)
 		}
@@ -8,8 +8,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/ChaosHour/go-dump/go/log"
 	_ "github.com/go-sql-driver/mysql"
-	"github.com/outbrain/golib/log"
 )
 
 func NewTaskManager(
@@ -19,6 +19,11 @@ func NewTaskManager(
 	db *sql.DB,
 	dumpOptions *DumpOptions) TaskManager {
 
+	sink := dumpOptions.Sink
+	if sink == nil {
+		sink = NewFileSink(dumpOptions.DestinationDir)
+	}
+
 	tm := TaskManager{
 		CreateChunksWaitGroup:  wgC,
 		ProcessChunksWaitGroup: wgP,
@@ -32,11 +37,15 @@ func NewTaskManager(
 		GetMasterStatus:        dumpOptions.GetMasterStatus,
 		GetSlaveStatus:         dumpOptions.GetSlaveStatus,
 		Compress:               dumpOptions.Compress,
+		CompressAlgorithm:      dumpOptions.CompressAlgorithm,
+		EncryptionKey:          dumpOptions.EncryptionKey,
 		CompressLevel:          dumpOptions.CompressLevel,
 		IsolationLevel:         dumpOptions.IsolationLevel,
 		mySQLHost:              dumpOptions.MySQLHost,
 		mySQLCredentials:       dumpOptions.MySQLCredentials,
-		DumpOptions:            dumpOptions}
+		Sink:                   sink,
+		DumpOptions:            dumpOptions,
+		failure:                newDumpFailure()}
 	return tm
 }
 
@@ -58,11 +67,81 @@ type TaskManager struct {
 	GetMasterStatus        bool
 	GetSlaveStatus         bool
 	Compress               bool
+	CompressAlgorithm      string
+	EncryptionKey          *EncryptionKey
 	CompressLevel          int
 	IsolationLevel         sql.IsolationLevel
 	mySQLHost              *MySQLHost
 	mySQLCredentials       *MySQLCredentials
+	Sink                   Sink
 	DumpOptions            *DumpOptions
+	masterStatus           *MasterStatus
+	slaveStatus            []*SlaveStatus
+	files                  []*DumpFile
+	checkpoint             *Checkpoint
+	schemas                map[string]bool // schemas dumped completely
+	interruptedRuns        []*DumpRun
+	failure                *dumpFailure
+}
+
+// dumpFailure keeps the first error of a dump. The context of the queries is
+// canceled with the error, so the remaining work stops.
+type dumpFailure struct {
+	mutex  sync.Mutex
+	err    error
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newDumpFailure() *dumpFailure {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &dumpFailure{ctx: ctx, cancel: cancel}
+}
+
+// Context return the context of the queries of the dump. It's done when the
+// dump fails.
+func (tm *TaskManager) Context() context.Context {
+	if tm.failure == nil {
+		return context.Background()
+	}
+	return tm.failure.ctx
+}
+
+// Fail records the error that stops the dump and cancels the remaining work:
+// no more chunks are created or dumped. Only the first error is kept, the
+// next ones are usually caused by the cancellation.
+func (tm *TaskManager) Fail(err error) {
+	if tm.failure == nil {
+		tm.failure = newDumpFailure()
+	}
+	tm.failure.mutex.Lock()
+	defer tm.failure.mutex.Unlock()
+	if tm.failure.err != nil {
+		log.Debugf("Error after the dump failed: %s", err.Error())
+		return
+	}
+	tm.failure.err = err
+	tm.failure.cancel()
+	tm.metrics().failed(err)
+}
+
+// bindContext return the context of the dump for the work started with ctx.
+// If ctx is done before stop is called, the dump fails with ErrInterrupted.
+func (tm *TaskManager) bindContext(ctx context.Context) (context.Context, func() bool) {
+	stop := context.AfterFunc(ctx, func() {
+		tm.Fail(newError(ErrInterrupted, "the dump was interrupted: %w", context.Cause(ctx)))
+	})
+	return tm.Context(), stop
+}
+
+// Err return the first error of the dump, or nil if it didn't fail.
+func (tm *TaskManager) Err() error {
+	if tm.failure == nil {
+		return nil
+	}
+	tm.failure.mutex.Lock()
+	defer tm.failure.mutex.Unlock()
+	return tm.failure.err
 }
 
 func (tm *TaskManager) addDatabaseEngine(t *Table) {
@@ -90,17 +169,27 @@ func (tm *TaskManager) GetTasksPool() []*Task {
 	return tm.tasksPool
 }
 
-func (tm *TaskManager) AddWorkersDB() {
+// addFile records a file of the dump that doesn't belong to a table.
+func (tm *TaskManager) addFile(fileType string, buffer *Buffer) {
+	tm.files = append(tm.files, newDumpFile(fileType, buffer))
+}
+
+// GetFiles return the files of the dump that don't belong to a table.
+func (tm *TaskManager) GetFiles() []*DumpFile {
+	return append([]*DumpFile{}, tm.files...)
+}
+
+// AddWorkersDB opens a connection for each thread.
+func (tm *TaskManager) AddWorkersDB() error {
 	for i := 0; i < tm.ThreadsCount; i++ {
 
 		conn, err := GetMySQLConnection(tm.mySQLHost, tm.mySQLCredentials)
 		if err != nil {
-			log.Critical("Error whith the database connection. %s", err.Error())
+			return err
 		}
-		conn.Ping()
 		tm.AddWorkerDB(conn)
 	}
-
+	return nil
 }
 
 func (tm *TaskManager) AddWorkerDB(db *sql.DB) {
@@ -108,35 +197,53 @@ func (tm *TaskManager) AddWorkerDB(db *sql.DB) {
 	tm.workersTx = append(tm.workersTx, nil)
 }
 
-func (tm *TaskManager) lockTables() {
+// CloseWorkersDB closes the connections of the workers.
+func (tm *TaskManager) CloseWorkersDB() {
+	for _, db := range tm.workersDB {
+		db.Close()
+	}
+	tm.workersDB = nil
+	tm.workersTx = nil
+}
+
+func (tm *TaskManager) lockTables(ctx context.Context) error {
 	query := GetLockTablesSQL(tm.tasksPool, "READ")
 
-	if _, err := tm.DB.Exec(query); err != nil {
-		log.Criticalf("Error unlocking the tables: %s", err.Error())
+	if _, err := tm.DB.ExecContext(ctx, query); err != nil {
+		return newError(ErrData, "error locking the tables: %w", err)
 	}
+	return nil
 }
 
-func (tm *TaskManager) unlockTables() {
+func (tm *TaskManager) unlockTables() error {
 	log.Debugf("Unlocking tables")
 	if _, err := tm.DB.Exec("UNLOCK TABLES"); err != nil {
-		log.Criticalf("Error unlocking the tables: %s", err.Error())
+		return newError(ErrData, "error unlocking the tables: %w", err)
 	}
+	return nil
 }
 
-func (tm *TaskManager) lockAllTables() {
+func (tm *TaskManager) lockAllTables(ctx context.Context) error {
 	query := GetLockAllTablesSQL()
-	if _, err := tm.DB.Exec(query); err != nil {
-		log.Fatalf("Error locking table: %s", err.Error())
+	if _, err := tm.DB.ExecContext(ctx, query); err != nil {
+		return newError(ErrData, "error locking the tables: %w", err)
 	}
+	return nil
 }
 
-func (tm *TaskManager) createWorkers() error {
+func (tm *TaskManager) createWorkers(ctx context.Context) error {
 	for i, dbW := range tm.workersDB {
-		txW, err := dbW.BeginTx(context.Background(), &sql.TxOptions{
+		txW, err := dbW.BeginTx(ctx, &sql.TxOptions{
 			Isolation: tm.IsolationLevel,
 			ReadOnly:  true})
 		if err != nil {
-			return fmt.Errorf("failed to begin transaction: %v", err)
+			return newError(ErrConnection, "failed to begin transaction: %w", err)
+		}
+		// The TIMESTAMP columns are dumped in UTC, like the data files
+		// restore them.
+		if _, err := txW.ExecContext(ctx, "SET time_zone='+00:00'"); err != nil {
+			txW.Rollback()
+			return newError(ErrConnection, "failed to set the time zone: %w", err)
 		}
 		tm.workersTx[i] = txW
 	}
@@ -153,7 +260,7 @@ func (tm *TaskManager) isMultiMaster() (bool, error) {
 
 // getSlaveData collects the slave data from the node that you are taking the backup.
 // It detect if the slave has multi master replication and collect and store the information for all the channels.
-func (tm *TaskManager) getSlaveData() {
+func (tm *TaskManager) getSlaveData() error {
 	log.Info("Getting Slave Status")
 	isMultiMaster, _ := tm.isMultiMaster()
 	var query string
@@ -166,8 +273,9 @@ func (tm *TaskManager) getSlaveData() {
 	slaveData, err := tm.DB.Query(query)
 
 	if err != nil {
-		log.Fatalf("Error getting slave information: %s", err.Error())
+		return newError(ErrData, "error getting slave information: %w", err)
 	}
+	defer slaveData.Close()
 
 	var connectionName, relayMasterLogFile, masterHost, executedGtidSet, gtidSlavePos string
 	var execMasterLogPos, masterPort uint64
@@ -198,12 +306,16 @@ func (tm *TaskManager) getSlaveData() {
 			out = append(out, new(interface{}))
 		}
 	}
-	buffer, _ := NewSlaveDataBuffer(tm)
+	buffer, err := NewSlaveDataBuffer(tm)
+	if err != nil {
+		return newError(ErrIO, "error creating the slave data file: %w", err)
+	}
 
 	for slaveData.Next() {
 		iterations++
 		if err := slaveData.Scan(out...); err != nil {
-			log.Fatalf(err.Error())
+			buffer.Abort()
+			return newError(ErrData, "error reading slave information: %w", err)
 		}
 
 		fmt.Fprintln(buffer, "Connection Name: ", connectionName)
@@ -217,30 +329,43 @@ func (tm *TaskManager) getSlaveData() {
 		if haveGtidSlavePos {
 			fmt.Fprintln(buffer, "  GTID Slave Pos: ", gtidSlavePos)
 		}
+		tm.slaveStatus = append(tm.slaveStatus, &SlaveStatus{
+			ConnectionName:     connectionName,
+			MasterHost:         masterHost,
+			MasterPort:         masterPort,
+			RelayMasterLogFile: relayMasterLogFile,
+			ExecMasterLogPos:   execMasterLogPos,
+			ExecutedGTIDSet:    executedGtidSet,
+			GTIDSlavePos:       gtidSlavePos,
+		})
 	}
-	buffer.Flush()
-	buffer.Close()
+	if err := buffer.Close(); err != nil {
+		return newError(ErrIO, "error writing the slave data file: %w", err)
+	}
+	tm.addFile(DumpFileSlaveData, buffer)
 
 	if iterations == 0 {
-		log.Fatalf("There is no slave information. Make sure that the server is acting as a slave server.")
+		return newError(ErrData, "there is no slave information. Make sure that the server is acting as a slave server")
 	}
+	return nil
 }
 
-func (tm *TaskManager) getMasterData() {
+func (tm *TaskManager) getMasterData() error {
 
 	log.Info("Getting Master Status")
 
 	var masterFile, binlogDoDb, binlogIgnoreDB, executedGTIDSet string
-	var masterPosition int
+	var masterPosition uint64
 
 	masterRows, err := tm.DB.Query(GetMasterStatusSQL())
 	if err != nil {
-		log.Fatalf("%s", err.Error())
+		return newError(ErrData, "error getting the master data information: %w", err)
 	}
+	defer masterRows.Close()
 	cols, _ := masterRows.Columns()
 
 	if len(cols) < 1 {
-		log.Fatal("Error getting the master data information. Make sure that the logs are enabled. If you want to skip the collection of the master information please use the option --master-data=false. Use --help for more information.")
+		return newError(ErrData, "error getting the master data information. Make sure that the logs are enabled. If you want to skip the collection of the master information please use the option --master-data=false. Use --help for more information")
 	}
 	var out []interface{}
 	supportGTID := false
@@ -266,10 +391,13 @@ func (tm *TaskManager) getMasterData() {
 	masterRows.Next()
 	err = masterRows.Scan(out...)
 	if err != nil {
-		log.Fatalf("Error reading Master data information: %s", err.Error())
+		return newError(ErrData, "error reading Master data information: %w", err)
 	}
 	masterRows.Close()
-	buffer, _ := NewMasterDataBuffer(tm)
+	buffer, err := NewMasterDataBuffer(tm)
+	if err != nil {
+		return newError(ErrIO, "error creating the master data file: %w", err)
+	}
 
 	fmt.Fprintln(buffer, "Master File:", masterFile)
 	fmt.Fprintln(buffer, "Master Position: ", masterPosition)
@@ -278,73 +406,213 @@ func (tm *TaskManager) getMasterData() {
 	if supportGTID {
 		fmt.Fprintln(buffer, "Executed Gtid Set: ", executedGTIDSet)
 	}
-	buffer.Flush()
-	buffer.Close()
+	if err := buffer.Close(); err != nil {
+		return newError(ErrIO, "error writing the master data file: %w", err)
+	}
+	tm.addFile(DumpFileMasterData, buffer)
+	tm.masterStatus = &MasterStatus{
+		File:            masterFile,
+		Position:        masterPosition,
+		BinlogDoDB:      binlogDoDb,
+		BinlogIgnoreDB:  binlogIgnoreDB,
+		ExecutedGTIDSet: executedGTIDSet,
+	}
+	return nil
 }
 
-func (tm *TaskManager) WriteTablesSQL(addDropTable bool) {
+// WriteTablesSQL writes the definition of each table, unless NoCreateInfo
+// is set, and the LOAD DATA script of the tables dumped in a delimited
+// format, unless NoData is set.
+func (tm *TaskManager) WriteTablesSQL(addDropTable bool) error {
 	for _, task := range tm.tasksPool {
-		buffer, _ := NewTableDefinitionBuffer(task)
-
-		if !tm.SkipUseDatabase {
-			fmt.Fprintf(buffer, GetUseDatabaseSQL(task.Table.GetSchema())+";\n")
+		if !tm.DumpOptions.NoCreateInfo {
+			if err := tm.writeTableDefinitionSQL(task, addDropTable); err != nil {
+				return err
+			}
+		}
+		if IsDelimitedFormat(tm.DumpOptions.OutputFormat) && !tm.DumpOptions.NoData {
+			if err := tm.writeLoadDataSQL(task); err != nil {
+				return err
+			}
 		}
+	}
+	return nil
+}
 
-		fmt.Fprintf(buffer, "/*!40101 SET NAMES binary*/;\n")
-		fmt.Fprintf(buffer, "/*!40014 SET FOREIGN_KEY_CHECKS=0*/;\n")
+// writeTableDefinitionSQL writes the CREATE TABLE statement of the table.
+func (tm *TaskManager) writeTableDefinitionSQL(task *Task, addDropTable bool) error {
+	buffer, err := NewTableDefinitionBuffer(task)
+	if err != nil {
+		return newError(ErrIO, "error creating the definition of %s: %w", task.Table.GetFullName(), err)
+	}
 
-		if addDropTable {
-			fmt.Fprintf(buffer, GetDropTableIfExistSQL(task.Table.GetName())+";\n")
-		}
+	if !tm.SkipUseDatabase {
+		fmt.Fprintf(buffer, GetUseDatabaseSQL(task.Table.GetSchema())+";\n")
+	}
 
-		fmt.Fprintf(buffer, task.Table.CreateTableSQL+";\n")
-		buffer.Flush()
+	fmt.Fprintf(buffer, "/*!40101 SET NAMES binary*/;\n")
+	fmt.Fprintf(buffer, "/*!40014 SET FOREIGN_KEY_CHECKS=0*/;\n")
+
+	if addDropTable {
+		fmt.Fprintf(buffer, GetDropTableIfExistSQL(task.Table.GetName())+";\n")
+	}
+
+	fmt.Fprintf(buffer, task.Table.CreateTableSQL+";\n")
+	if err := buffer.Close(); err != nil {
+		return newError(ErrIO, "error writing the definition of %s: %w", task.Table.GetFullName(), err)
+	}
+	task.AddFile(RestoreFileDefinition, buffer)
+	return nil
+}
+
+// writeLoadDataSQL writes the script with one LOAD DATA statement per data
+// file of the table. The file names are relative to the destination
+// directory.
+func (tm *TaskManager) writeLoadDataSQL(task *Task) error {
+	buffer, err := NewLoadDataBuffer(task)
+	if err != nil {
+		return newError(ErrIO, "error creating the load script for %s: %w", task.Table.GetFullName(), err)
+	}
+
+	if !tm.SkipUseDatabase {
+		fmt.Fprintf(buffer, "%s;\n", GetUseDatabaseSQL(task.Table.GetSchema()))
+	}
+	fmt.Fprintf(buffer, "/*!40014 SET FOREIGN_KEY_CHECKS=0*/;\n")
+	fmt.Fprintf(buffer, "SET UNIQUE_CHECKS=0;\n")
+	fmt.Fprintf(buffer, "SET SQL_MODE='NO_AUTO_VALUE_ON_ZERO';\n")
+	fmt.Fprintf(buffer, "SET TIME_ZONE='+00:00';\n")
+
+	options := tm.DumpOptions.GetCSVOptions()
+	for _, fileName := range task.GetDataFiles() {
+		fmt.Fprintf(buffer, "%s;\n", GetLoadDataSQL(task.Table, fileName, options))
+	}
+	if err := buffer.Close(); err != nil {
+		return newError(ErrIO, "error writing the load script for %s: %w", task.Table.GetFullName(), err)
+	}
+	task.AddFile(RestoreFileLoad, buffer)
+	return nil
+}
+
+// StartCheckpoint creates the checkpoint in the destination directory, or
+// appends to the checkpoint of the interrupted dump when resuming.
+func (tm *TaskManager) StartCheckpoint(resume bool) error {
+	checkpoint, err := NewCheckpoint(tm.DestinationDir, resume)
+	if err != nil {
+		return err
 	}
+	tm.checkpoint = checkpoint
+	event := &CheckpointEvent{Event: CheckpointRun, Options: newManifestOptions(tm.DumpOptions)}
+	if tm.EncryptionKey != nil {
+		event.Encryption = tm.EncryptionKey.GetInfo()
+	}
+	tm.checkpoint.Write(event)
+	return nil
+}
+
+// StopCheckpoint records the error that stopped the dump in the checkpoint
+// and closes it. The checkpoint is kept to resume the dump.
+func (tm *TaskManager) StopCheckpoint(err error) error {
+	tm.checkpoint.Write(&CheckpointEvent{Event: CheckpointStopped, Error: err.Error()})
+	return tm.checkpoint.Close()
+}
+
+// RemoveCheckpoint removes the checkpoint once the dump is complete.
+func (tm *TaskManager) RemoveCheckpoint() error {
+	return tm.checkpoint.Remove()
+}
+
+// IsConsistent return true if the data of the dump comes from a single
+// point in time. A resumed dump is only consistent if the binary log
+// didn't change since the interrupted runs.
+func (tm *TaskManager) IsConsistent() bool {
+	if !tm.DumpOptions.Consistent {
+		return false
+	}
+	for _, run := range tm.interruptedRuns {
+		if run.MasterStatus == nil || tm.masterStatus == nil || *run.MasterStatus != *tm.masterStatus {
+			return false
+		}
+	}
+	return true
 }
 
-func (tm *TaskManager) GetTransactions(lockTables bool, allDatabases bool) {
+// GetTransactions starts the transactions of the workers, with the tables
+// locked if lockTables is set, and collects the master and slave status.
+// The transactions are rolled back if ctx is done before the workers finish.
+func (tm *TaskManager) GetTransactions(ctx context.Context, lockTables bool, allDatabases bool) error {
 	var startLocking time.Time
+	ctx, stop := tm.bindContext(ctx)
+	defer stop()
 
 	if lockTables {
 		log.Infof("Locking tables to get a consistent backup.")
 		startLocking = time.Now()
+		var err error
 		if allDatabases {
-			tm.lockAllTables()
+			err = tm.lockAllTables(ctx)
 		} else {
-			tm.lockTables()
+			err = tm.lockTables(ctx)
+		}
+		if err != nil {
+			return err
 		}
 	}
 
+	if err := tm.startTransactions(ctx); err != nil {
+		if lockTables {
+			tm.unlockTables()
+		}
+		return err
+	}
+
+	if lockTables {
+		if err := tm.unlockTables(); err != nil {
+			return err
+		}
+		lockedTime := time.Since(startLocking)
+		tm.metrics().setLockHold(lockedTime)
+		log.With("duration", lockedTime).Infof("Unlocking the tables. Tables were locked for %s", lockedTime)
+	}
+	return nil
+}
+
+// startTransactions creates the transactions of the workers and collects
+// the status of the server while the tables are locked.
+func (tm *TaskManager) startTransactions(ctx context.Context) error {
 	log.Debug("Starting workers")
-	if err := tm.createWorkers(); err != nil {
-		tm.unlockTables() // Cleanup if needed
-		log.Fatalf("Error creating workers: %v", err)
-		return
+	if err := tm.createWorkers(ctx); err != nil {
+		return err
 	}
 
 	// GET MASTER DATA
 	if tm.GetMasterStatus {
-		tm.getMasterData()
+		if err := tm.getMasterData(); err != nil {
+			return err
+		}
 	}
 	if tm.GetSlaveStatus {
-		tm.getSlaveData()
+		if err := tm.getSlaveData(); err != nil {
+			return err
+		}
+	}
+	tm.checkpoint.Write(&CheckpointEvent{Event: CheckpointTransactions, MasterStatus: tm.masterStatus})
+	if len(tm.interruptedRuns) > 0 && !tm.IsConsistent() {
+		log.Warningf("The dump is resumed and the binary log position changed since the interrupted dump, or it " +
+			"is unknown. The data of the dump is not consistent and the manifest records it.")
 	}
 
 	log.Debugf("Added %d transactions", len(tm.workersDB))
-
-	if lockTables {
-		tm.unlockTables()
-		lockedTime := time.Since(startLocking)
-		log.Infof("Unlocking the tables. Tables were locked for %s", lockedTime)
-	}
+	return nil
 }
 
-func (tm *TaskManager) StartWorkers() error {
+// StartWorkers starts a worker for each transaction. The workers stop
+// dumping chunks when ctx is done.
+func (tm *TaskManager) StartWorkers(ctx context.Context) error {
 	log.Infof("Starting %d workers", len(tm.workersTx))
 	// Simplify: remove unused range variable
 	for i := range tm.workersTx {
 		tm.ProcessChunksWaitGroup.Add(1)
-		go tm.StartWorker(i)
+		go tm.StartWorker(ctx, i)
 	}
 	log.Debugf("All workers are running")
 	return nil
@@ -357,15 +625,6 @@ func (tm *TaskManager) DisplaySummary() error {
 	return nil
 }
 
-func (tm *TaskManager) PrintStatus() {
-	time.Sleep(2 * time.Second)
-	log.Infof("Status. Queue: %d of %d", tm.Queue, tm.TotalChunks)
-	for tm.Queue > 0 {
-		log.Infof("Queue: %d of %d", tm.Queue, tm.TotalChunks)
-		time.Sleep(1 * time.Second)
-	}
-}
-
 func (tm *TaskManager) CleanChunkChannel() {
 	for {
 		_, ok := <-tm.ChunksChannel
@@ -376,9 +635,45 @@ func (tm *TaskManager) CleanChunkChannel() {
 	}
 }
 
-func (tm *TaskManager) StartWorker(workerId int) {
+// closeChunkBuffer closes a data file and records it.
+func (tm *TaskManager) closeChunkBuffer(task *Task, buffer *Buffer) {
+	if err := buffer.Close(); err != nil {
+		tm.Fail(newError(ErrIO, "error closing the file %s: %w", buffer.FileName, err))
+		return
+	}
+	dataBytes := buffer.newDataBytes()
+	task.AddBytes(dataBytes)
+	tm.metrics().fileClosed(task.Table, dataBytes, buffer.Size())
+	task.AddFile(RestoreFileData, buffer)
+	log.With("table", task.Table.GetUnescapedFullName(), "file", buffer.FileName, "bytes", buffer.Size()).
+		Debugf("File %s closed", buffer.FileName)
+	tm.checkpoint.Write(&CheckpointEvent{Event: CheckpointFileClosed, Schema: task.Table.GetUnescapedSchema(),
+		Table: task.Table.GetUnescapedName(), File: newDumpFile(RestoreFileData, buffer)})
+}
+
+// abortChunkBuffer closes a data file with a chunk that failed. The file is
+// not recorded, and the files of a FileSink get the ".partial" suffix.
+func (tm *TaskManager) abortChunkBuffer(buffer *Buffer) {
+	if err := buffer.Abort(); err != nil {
+		log.Warningf("Error closing the incomplete file %s: %s", buffer.FileName, err.Error())
+	}
+}
+
+// StartWorker dumps the chunks of the channel with the transaction of the
+// worker. After an error, or when ctx is done, the chunks are only drained,
+// the files with complete chunks are closed and the file of the chunk that
+// was being dumped is aborted.
+func (tm *TaskManager) StartWorker(ctx context.Context, workerId int) {
+	ctx, stop := tm.bindContext(ctx)
+	defer stop()
 	bufferChunk := make(map[string]*Buffer)
+	bufferTask := make(map[string]*Task)
+	bufferChunks := make(map[string]uint64)
+	bufferUsed := make(map[string]uint64) // order of the last chunk written in the file
+	fileParts := make(map[string]bool)
+	maxOpenFiles := tm.maxOpenFiles()
 
+	var chunksWritten uint64
 	var query string
 	var stmt *sql.Stmt
 	var err error
@@ -391,60 +686,146 @@ func (tm *TaskManager) StartWorker(workerId int) {
 			log.Debugf("Channel %d is closed.", workerId)
 			break
 		}
-
-		if chunk.IsSingleChunk && workerId != 0 {
+		tm.metrics().addToQueue(-1)
+		if ctx.Err() != nil {
 			continue
 		}
+		start := time.Now()
 
-		if query != chunk.GetPrepareSQL() {
-			query = chunk.GetPrepareSQL()
-			if stmt != nil {
-				stmt.Close()
-			}
-			stmt, err = tm.workersTx[workerId].Prepare(query)
-		} else {
-			stmt, err = tm.workersTx[workerId].Prepare(query)
-		}
-
+		query = chunk.GetPrepareSQL()
+		stmt, err = tm.workersTx[workerId].PrepareContext(ctx, query)
 		if err != nil {
-			log.Fatalf("Error starting the worker. Query: %s, Error: %s.", query, err.Error())
+			tm.Fail(newError(ErrData, "error preparing the query of %s. Query: %s, Error: %w",
+				chunk.Task.Table.GetFullName(), query, err))
+			continue
 		}
 
 		tablename := chunk.Task.Table.GetUnescapedFullName()
 
 		if _, ok := bufferChunk[tablename]; !ok {
-			bufferChunk[tablename], _ = NewChunkBuffer(&chunk, workerId)
+			if maxOpenFiles > 0 && len(bufferChunk) >= maxOpenFiles {
+				oldest := leastRecentlyUsed(bufferUsed)
+				tm.closeChunkBuffer(bufferTask[oldest], bufferChunk[oldest])
+				delete(bufferChunk, oldest)
+				delete(bufferUsed, oldest)
+				bufferChunks[oldest] = 0
+			}
+			part := 0
+			if fileParts[tablename] || chunk.Task.resumed {
+				part = chunk.Task.NextFilePart()
+			}
+			buffer, err := NewChunkBuffer(&chunk, workerId, part)
+			if err != nil {
+				stmt.Close()
+				tm.Fail(newError(ErrIO, "error creating the data file of %s: %w", chunk.Task.Table.GetFullName(), err))
+				continue
+			}
+			bufferChunk[tablename] = buffer
+			bufferTask[tablename] = chunk.Task
+			fileParts[tablename] = true
 		}
 
 		buffer := bufferChunk[tablename]
+		chunksWritten++
+		bufferUsed[tablename] = chunksWritten
 
-		if !chunk.Task.TaskManager.SkipUseDatabase {
+		if !chunk.Task.TaskManager.SkipUseDatabase && tm.DumpOptions.OutputFormat == OutputFormatSQL {
 			fmt.Fprintf(buffer, "USE %s\n", chunk.Task.Table.GetSchema())
 		}
 
 		buffer.Flush()
 
-		chunk.Parse(stmt, buffer)
-
+		chunkRange, err := chunk.Parse(ctx, stmt, buffer)
 		stmt.Close()
+		duration := time.Since(start)
+		event := log.With("table", tablename, "chunk", chunk.Sequence, "worker", workerId, "duration", duration)
+		if err != nil {
+			// The chunks canceled by the failure are not logged.
+			if ctx.Err() == nil {
+				event.With("error", err).Errorf("Error dumping the chunk %d of %s: %s", chunk.Sequence,
+					tablename, err.Error())
+			}
+			tm.Fail(newError(ErrData, "error dumping the chunk %d of %s: %w", chunk.Sequence,
+				chunk.Task.Table.GetFullName(), err))
+			tm.abortChunkBuffer(buffer)
+			delete(bufferChunk, tablename)
+			delete(bufferUsed, tablename)
+			continue
+		}
+		tm.checkpoint.Write(&CheckpointEvent{Event: CheckpointChunkDone,
+			Schema: chunk.Task.Table.GetUnescapedSchema(), Table: chunk.Task.Table.GetUnescapedName(),
+			Chunk: chunkRange, FileName: buffer.FileName})
+		buffer.Flush()
+		dataBytes := buffer.newDataBytes()
+		chunk.Task.chunkDone(dataBytes)
+		tm.metrics().chunkDone(chunk.Task.Table, workerId, chunkRange.Rows, dataBytes, duration)
+		event.With("rows", chunkRange.Rows, "bytes", dataBytes).Eventf("Chunk %d of %s dumped by the worker %d in %s",
+			chunk.Sequence, tablename, workerId, duration)
+
+		// Closing the file after --chunks-per-file chunks, so the chunks are
+		// kept if the dump is resumed.
+		bufferChunks[tablename]++
+		if tm.DumpOptions.ChunksPerFile > 0 && bufferChunks[tablename] >= tm.DumpOptions.ChunksPerFile {
+			tm.closeChunkBuffer(chunk.Task, buffer)
+			delete(bufferChunk, tablename)
+			delete(bufferUsed, tablename)
+			bufferChunks[tablename] = 0
+		}
 	}
-	for _, buffer := range bufferChunk {
-		buffer.Close()
+	for tablename, buffer := range bufferChunk {
+		tm.closeChunkBuffer(bufferTask[tablename], buffer)
 	}
 	tm.workersTx[workerId].Commit()
 	tm.ProcessChunksWaitGroup.Done()
 }
 
-func (tm *TaskManager) AddChunk(chunk DataChunk) {
-	tm.ChunksChannel <- chunk
+// maxOpenFiles return the number of data files a worker keeps open, 0 for no
+// limit.
+func (tm *TaskManager) maxOpenFiles() int {
+	if limiter, ok := tm.Sink.(SinkOpenFilesLimiter); ok {
+		return limiter.MaxOpenFiles()
+	}
+	return 0
 }
 
-func (tm *TaskManager) CreateChunks(db *sql.DB) {
+// leastRecentlyUsed return the table of the file used the longest time ago.
+func leastRecentlyUsed(used map[string]uint64) string {
+	oldest := ""
+	for tablename, order := range used {
+		if oldest == "" || order < used[oldest] {
+			oldest = tablename
+		}
+	}
+	return oldest
+}
+
+// AddChunk queues a chunk for the workers. It return false without queuing
+// the chunk if the dump failed.
+func (tm *TaskManager) AddChunk(chunk DataChunk) bool {
+	// The chunk is counted in the queue before a worker can take it.
+	tm.metrics().addToQueue(1)
+	select {
+	case tm.ChunksChannel <- chunk:
+		tm.metrics().chunkQueued(chunk.Task.Table)
+		return true
+	case <-tm.Context().Done():
+		tm.metrics().addToQueue(-1)
+		return false
+	}
+}
+
+// CreateChunks creates the chunks of all the tables, one goroutine per
+// table. The chunks are not created anymore when ctx is done.
+func (tm *TaskManager) CreateChunks(ctx context.Context, db *sql.DB) {
 	log.Debugf("tasksPool  %v", tm.tasksPool)
 	for _, t := range tm.tasksPool {
 		tm.CreateChunksWaitGroup.Add(1)
 		log.Debugf("CreateChunksWaitGroup TaskManager Add %v", tm.CreateChunksWaitGroup)
-		go t.CreateChunks(db)
+		if t.resumed {
+			go t.ResumeChunks(ctx)
+		} else {
+			go t.CreateChunks(ctx, db)
+		}
 	}
 	tm.CreateChunksWaitGroup.Done()
 	log.Debugf("CreateChunksWaitGroup TaskManager Done %v", tm.CreateChunksWaitGroup)
@@ -455,8 +836,11 @@ func (tm *TaskManager) GetBufferOptions() *BufferOptions {
 	bufferOptions := new(BufferOptions)
 	if tm.Compress {
 		bufferOptions.Compress = true
+		bufferOptions.CompressAlgorithm = tm.CompressAlgorithm
 		bufferOptions.CompressLevel = tm.CompressLevel
 	}
 	bufferOptions.Type = BufferTypeFile
+	bufferOptions.Sink = tm.Sink
+	bufferOptions.EncryptionKey = tm.EncryptionKey
 	return bufferOptions
 }
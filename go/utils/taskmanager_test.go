@@ -1,8 +1,12 @@
 package utils
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 )
@@ -39,6 +43,9 @@ func getDumpOptions() *DumpOptions {
 		SkipUseDatabase:       false,
 		Compress:              false,
 		CompressLevel:         0,
+		OutputFormat:          OutputFormatSQL,
+		CSVQuote:              "\"",
+		CSVNull:               DefaultCSVNull,
 		IsolationLevel:        sql.LevelRepeatableRead,
 		Consistent:            true,
 		WhereConditions:       make(map[string]string),
@@ -70,22 +77,117 @@ func TestCreateTaskManager(t *testing.T) {
 	if _, err := os.Stat(taskManager.DestinationDir); os.IsNotExist(err) {
 		os.MkdirAll(taskManager.DestinationDir, 0755)
 	}
-	taskManager.AddWorkersDB()
-	taskManager.GetTransactions(true, false)
+	if err := taskManager.AddWorkersDB(); err != nil {
+		t.Fatal(err)
+	}
+	if err := taskManager.GetTransactions(context.Background(), true, false); err != nil {
+		t.Fatal(err)
+	}
 }
 
-func TestLoadIniFile(t *testing.T) {
-	testOptions := getDumpOptions()
+func TestTablesFromDatabase(t *testing.T) {
+	fromDatabase, err := TablesFromDatabase("sakila", tmdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromAllDatabases, err := TablesFromAllDatabases(tmdb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, tables := range map[string]map[string]bool{
+		"database":      fromDatabase,
+		"all databases": fromAllDatabases,
+	} {
+		if !tables["sakila.city"] {
+			t.Fatalf("The table sakila.city is missing with %s: %v", name, tables)
+		}
+		// The views are dumped with --views, not as tables.
+		if tables["sakila.staff_list"] {
+			t.Fatalf("The view sakila.staff_list is a table with %s", name)
+		}
+	}
+}
 
-	skipUser := map[string]bool{"mysql-user": true}
+func TestWriteTablesSQL(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		noData       bool
+		noCreateInfo bool
+		files        []string
+	}{
+		{"all", false, false, []string{RestoreFileDefinition, RestoreFileLoad}},
+		{"no data", true, false, []string{RestoreFileDefinition}},
+		{"no create info", false, true, []string{RestoreFileLoad}},
+	} {
+		dir := t.TempDir()
+		options := getDumpOptions()
+		options.OutputFormat = OutputFormatCSV
+		options.NoData = tt.noData
+		options.NoCreateInfo = tt.noCreateInfo
+		tm := &TaskManager{DumpOptions: options, DestinationDir: dir, Sink: NewFileSink(dir)}
+		task := &Task{Table: &Table{schema: "sakila", name: "city", CreateTableSQL: "CREATE TABLE `city` (`city_id` int)"},
+			TaskManager: tm}
+		tm.AddTask(task)
+		if err := tm.WriteTablesSQL(false); err != nil {
+			t.Fatal(err)
+		}
+
+		var files []string
+		for _, file := range task.GetFiles() {
+			files = append(files, file.Type)
+			if _, err := os.Stat(filepath.Join(dir, file.Name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if strings.Join(files, ",") != strings.Join(tt.files, ",") {
+			t.Fatalf("Unexpected files with %s: %v", tt.name, files)
+		}
+	}
+}
 
-	ParseIniFile("../../test/test.ini", testOptions, skipUser)
+func TestTaskManagerFail(t *testing.T) {
+	tm := NewTaskManager(&sync.WaitGroup{}, &sync.WaitGroup{}, make(chan DataChunk), nil, getDumpOptions())
+	if tm.Err() != nil || tm.Context().Err() != nil {
+		t.Fatal("The dump should not be failed")
+	}
+
+	first := newError(ErrData, "error dumping the chunk 1 of `sakila`.`city`")
+	tm.Fail(first)
+	tm.Fail(errors.New("context canceled"))
+	if tm.Err() != first || ErrorKind(tm.Err()) != ErrData {
+		t.Fatalf("Unexpected error %v", tm.Err())
+	}
+	if tm.Context().Err() == nil {
+		t.Fatal("The context should be canceled")
+	}
+
+	// The channel doesn't have any worker, but the chunk is not queued.
+	task := &Task{Table: &Table{schema: "sakila", name: "city"}, TaskManager: &tm}
+	if tm.AddChunk(NewSingleDataChunk(task)) {
+		t.Fatal("The chunk should not be queued after the failure")
+	}
+}
 
-	if testOptions.Threads != 3 {
-		t.Errorf("Threads should be 3")
+func TestTaskManagerInterrupted(t *testing.T) {
+	tm := NewTaskManager(&sync.WaitGroup{}, &sync.WaitGroup{}, make(chan DataChunk), nil, getDumpOptions())
+	ctx, cancel := context.WithCancelCause(context.Background())
+	dumpCtx, stop := tm.bindContext(ctx)
+	cancel(errors.New("got the signal interrupt"))
+	<-dumpCtx.Done()
+	stop()
+
+	err := tm.Err()
+	if ErrorKind(err) != ErrInterrupted || err.Error() != "the dump was interrupted: got the signal interrupt" {
+		t.Fatalf("Unexpected error %v", err)
 	}
 
-	if testOptions.MySQLCredentials.User != dumpOptions.MySQLCredentials.User {
-		t.Errorf("MySQL user shouldn't change.")
+	// The work that finished before the cancellation doesn't fail the dump.
+	tm = NewTaskManager(&sync.WaitGroup{}, &sync.WaitGroup{}, make(chan DataChunk), nil, getDumpOptions())
+	ctx, cancel = context.WithCancelCause(context.Background())
+	_, stop = tm.bindContext(ctx)
+	stop()
+	cancel(nil)
+	if tm.Err() != nil {
+		t.Fatalf("Unexpected error %v", tm.Err())
 	}
 }
@@ -0,0 +1,267 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/ChaosHour/go-dump/go/log"
+)
+
+// VerifyOptions contains the options to compare a dump with a server.
+type VerifyOptions struct {
+	MySQLHost        *MySQLHost
+	MySQLCredentials *MySQLCredentials
+	Threads          int
+	SourceDir        string
+}
+
+// VerifyChunkResult is the result of a chunk that doesn't match the dump.
+type VerifyChunkResult struct {
+	Range       *ChunkRange
+	Rows        uint64
+	RowChecksum string
+	Err         error
+}
+
+// VerifyResult is the result of the verification of a table.
+type VerifyResult struct {
+	Schema       string
+	Table        string
+	Chunks       int
+	Rows         uint64 // rows in the dump
+	ServerRows   uint64 // rows in the server
+	FailedChunks []*VerifyChunkResult
+}
+
+// OK return true if all the chunks of the table match the dump.
+func (r *VerifyResult) OK() bool {
+	return len(r.FailedChunks) == 0
+}
+
+// Verifier reads again the chunks of a dump from a server, the source or a
+// restored copy, and compares the rows and the row checksums with the
+// values of the manifest.
+type Verifier struct {
+	options  *VerifyOptions
+	manifest *Manifest
+}
+
+// verifyChunk is a chunk to read from the server.
+type verifyChunk struct {
+	chunk    *DataChunk
+	expected *ChunkRange
+	result   *VerifyResult
+	rows     uint64
+	checksum uint64
+	err      error
+}
+
+// NewVerifier creates a Verifier for the options.
+func NewVerifier(options *VerifyOptions) *Verifier {
+	return &Verifier{options: options}
+}
+
+// getChunks return the chunks of the manifest with the queries of the dump.
+// A table without chunks was empty, so it's read in a single chunk that
+// should not return any row. The first chunk of a table is read without
+// its lower bound, so the rows added below the first key are counted too.
+func (v *Verifier) getChunks() ([]*VerifyResult, []*verifyChunk) {
+	tm := &TaskManager{DumpOptions: &DumpOptions{
+		WhereConditions:      v.manifest.Options.WhereConditions,
+		GlobalWhereCondition: v.manifest.Options.Where}}
+
+	var results []*VerifyResult
+	var chunks []*verifyChunk
+	for _, table := range v.manifest.Tables {
+		task := &Task{Table: &Table{schema: table.Schema, name: table.Name, keyForChunks: table.Key},
+			TaskManager: tm}
+		result := &VerifyResult{Schema: table.Schema, Table: table.Name, Chunks: len(table.ChunkRanges)}
+		results = append(results, result)
+
+		ranges := table.ChunkRanges
+		if len(ranges) == 0 {
+			ranges = []*ChunkRange{{Single: true}}
+		}
+		for i, expected := range ranges {
+			result.Rows += expected.Rows
+			chunk := NewDataChunkFromRange(task, expected)
+			chunk.IsFirstChunk = i == 0 && !expected.Single
+			chunks = append(chunks, &verifyChunk{chunk: &chunk, expected: expected, result: result})
+		}
+	}
+	return results, chunks
+}
+
+// Run reads the manifest and verifies all the chunks. It only returns an
+// error when the verification can't run or ctx is done, the differences
+// are in the results.
+func (v *Verifier) Run(ctx context.Context) ([]*VerifyResult, error) {
+	manifest, err := ReadManifest(v.options.SourceDir)
+	if err != nil {
+		return nil, newError(ErrIO, "error reading the manifest of %s: %w", v.options.SourceDir, err)
+	}
+	if manifest.Options == nil {
+		return nil, fmt.Errorf("the manifest of %s doesn't have the options of the dump", v.options.SourceDir)
+	}
+	if manifest.Options.NoData {
+		return nil, fmt.Errorf("the dump of %s was taken with --no-data, there is no data to verify", v.options.SourceDir)
+	}
+	v.manifest = manifest
+	results, chunks := v.getChunks()
+	log.Infof("Verifying %d chunks of %d tables", len(chunks), len(results))
+
+	threads := v.options.Threads
+	if threads < 1 {
+		threads = 1
+	}
+	var workers []*sql.DB
+	defer func() {
+		for _, db := range workers {
+			db.Close()
+		}
+	}()
+	for i := 0; i < threads; i++ {
+		db, err := GetMySQLConnection(v.options.MySQLHost, v.options.MySQLCredentials)
+		if err != nil {
+			return nil, err
+		}
+		workers = append(workers, db)
+	}
+
+	cChunks := make(chan *verifyChunk, len(chunks))
+	for _, chunk := range chunks {
+		cChunks <- chunk
+	}
+	close(cChunks)
+
+	var wg sync.WaitGroup
+	for i, db := range workers {
+		wg.Add(1)
+		go func(workerId int, db *sql.DB) {
+			defer wg.Done()
+			for chunk := range cChunks {
+				if ctx.Err() != nil {
+					return
+				}
+				log.Debugf("Worker %d verifying chunk %d of %s", workerId, chunk.chunk.Sequence,
+					chunk.chunk.Task.Table.GetFullName())
+				chunk.rows, chunk.checksum, chunk.err = readChunk(ctx, db, chunk.chunk)
+			}
+		}(i, db)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil, newError(ErrInterrupted, "the verification was interrupted: %w", context.Cause(ctx))
+	}
+
+	for _, chunk := range chunks {
+		result := chunk.result
+		result.ServerRows += chunk.rows
+		failed := &VerifyChunkResult{Range: chunk.expected, Rows: chunk.rows, Err: chunk.err}
+		if chunk.expected.RowChecksum != "" {
+			failed.RowChecksum = FormatRowChecksum(chunk.checksum)
+		}
+		if chunk.err != nil || chunk.rows != chunk.expected.Rows || failed.RowChecksum != chunk.expected.RowChecksum {
+			result.FailedChunks = append(result.FailedChunks, failed)
+		}
+	}
+	return results, nil
+}
+
+// readChunk reads the rows of a chunk with a prepared statement and the
+// TIMESTAMP columns in UTC, like the dump, so the values are the same for
+// the row checksum.
+func readChunk(ctx context.Context, db *sql.DB, chunk *DataChunk) (uint64, uint64, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SET time_zone='+00:00'"); err != nil {
+		return 0, 0, err
+	}
+	stmt, err := conn.PrepareContext(ctx, chunk.GetPrepareSQL())
+	if err != nil {
+		return 0, 0, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, chunk.GetQueryArgs()...)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, 0, err
+	}
+	buff := make([]interface{}, len(columns))
+	data := make([]interface{}, len(columns))
+	for i := range buff {
+		buff[i] = &data[i]
+	}
+
+	var count, checksum uint64
+	for rows.Next() {
+		if err := rows.Scan(buff...); err != nil {
+			return count, checksum, err
+		}
+		checksum += rowChecksum(data)
+		count++
+	}
+	return count, checksum, rows.Err()
+}
+
+// WriteVerifyReport writes a line per table with the result and a line per
+// chunk that doesn't match the dump.
+func WriteVerifyReport(w io.Writer, results []*VerifyResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TABLE\tCHUNKS\tROWS\tSERVER ROWS\tRESULT")
+	for _, result := range results {
+		status := "OK"
+		if !result.OK() {
+			status = fmt.Sprintf("FAILED (%d chunks)", len(result.FailedChunks))
+		}
+		fmt.Fprintf(tw, "%s.%s\t%d\t%d\t%d\t%s\n", result.Schema, result.Table, result.Chunks,
+			result.Rows, result.ServerRows, status)
+	}
+	tw.Flush()
+
+	for _, result := range results {
+		for _, failed := range result.FailedChunks {
+			fmt.Fprintf(w, "%s.%s %s: %s\n", result.Schema, result.Table,
+				describeChunkRange(failed.Range), describeChunkFailure(failed))
+		}
+	}
+}
+
+func describeChunkRange(r *ChunkRange) string {
+	switch {
+	case r.Single:
+		return "single chunk"
+	case r.Last:
+		return fmt.Sprintf("chunk %d from %s to the end", r.Sequence, formatKey(r.Min))
+	}
+	return fmt.Sprintf("chunk %d from %s to %s", r.Sequence, formatKey(r.Min), formatKey(r.Max))
+}
+
+func describeChunkFailure(failed *VerifyChunkResult) string {
+	if failed.Err != nil {
+		return "error " + failed.Err.Error()
+	}
+	var problems []string
+	if failed.Rows != failed.Range.Rows {
+		problems = append(problems, fmt.Sprintf("%d rows, expected %d", failed.Rows, failed.Range.Rows))
+	}
+	if failed.RowChecksum != failed.Range.RowChecksum {
+		problems = append(problems, fmt.Sprintf("row checksum %s, expected %s", failed.RowChecksum,
+			failed.Range.RowChecksum))
+	}
+	return strings.Join(problems, ", ")
+}
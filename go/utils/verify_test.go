@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestChunkKeyJSON(t *testing.T) {
+	key := ChunkKey{int64(71), []byte("PENELOPE"), []byte{0xff, 0x00}, nil}
+	content, err := json.Marshal(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != `[71,"PENELOPE",{"hex":"ff00"},null]` {
+		t.Fatalf("Unexpected JSON %s", content)
+	}
+
+	var got ChunkKey
+	if err := json.Unmarshal(content, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got[0] != "71" || string(got[1].([]byte)) != "PENELOPE" || !bytes.Equal(got[2].([]byte), []byte{0xff, 0x00}) ||
+		got[3] != nil {
+		t.Fatalf("Unexpected key %#v", got)
+	}
+}
+
+func writeTestManifest(t *testing.T, manifest *Manifest) string {
+	dir := t.TempDir()
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ManifestFile), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestVerifier(t *testing.T) {
+	dir := writeTestManifest(t, &Manifest{
+		Options: &ManifestOptions{WhereConditions: map[string]string{"`sakila`.`city`": "country_id = 44"}},
+		Tables: []*ManifestTable{
+			{Schema: "sakila", Name: "actor", Key: []string{"actor_id"}, ChunkRanges: []*ChunkRange{
+				// The first chunk is read from the start, so the rows below 51 are counted.
+				{Sequence: 0, Min: ChunkKey{[]byte("51")}, Max: ChunkKey{int64(101)}, Rows: 100},
+				{Sequence: 1, Min: ChunkKey{int64(101)}, Last: true, Rows: 99},
+			}},
+			{Schema: "sakila", Name: "city", Key: []string{"city_id"}, ChunkRanges: []*ChunkRange{
+				{Sequence: 0, Min: ChunkKey{int64(1)}, Last: true, Rows: 60, RowChecksum: "0000000000000000"},
+			}},
+			{Schema: "sakila", Name: "missing_table"},
+		},
+	})
+
+	verifier := NewVerifier(&VerifyOptions{MySQLHost: getMySQLHost(), MySQLCredentials: getMySQLCredentials(),
+		Threads: 2, SourceDir: dir})
+	results, err := verifier.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("Unexpected results %v", results)
+	}
+
+	actor := results[0]
+	if actor.ServerRows != 200 || len(actor.FailedChunks) != 1 || actor.FailedChunks[0].Range.Sequence != 1 ||
+		actor.FailedChunks[0].Rows != 100 {
+		t.Fatalf("Unexpected result for actor %+v", actor)
+	}
+	// The condition of the dump is used, so only the cities of India are read.
+	city := results[1]
+	if city.ServerRows != 60 || len(city.FailedChunks) != 1 || city.FailedChunks[0].RowChecksum == "" {
+		t.Fatalf("Unexpected result for city %+v", city)
+	}
+	if missing := results[2]; missing.OK() || missing.FailedChunks[0].Err == nil {
+		t.Fatalf("Expected an error for a missing table %+v", missing)
+	}
+
+	var report bytes.Buffer
+	WriteVerifyReport(&report, results)
+	for _, line := range []string{
+		"sakila.actor chunk 1 from (101) to the end: 100 rows, expected 99",
+		"sakila.city chunk 0 from (1) to the end: row checksum ",
+		"sakila.missing_table single chunk: error ",
+	} {
+		if !strings.Contains(report.String(), line) {
+			t.Fatalf("The report doesn't contain %q:\n%s", line, report.String())
+		}
+	}
+}
+
+func TestVerifierCanceled(t *testing.T) {
+	dir := writeTestManifest(t, &Manifest{
+		Options: &ManifestOptions{},
+		Tables:  []*ManifestTable{{Schema: "sakila", Name: "actor", Key: []string{"actor_id"}}},
+	})
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(errors.New("got the signal interrupt"))
+	verifier := NewVerifier(&VerifyOptions{MySQLHost: getMySQLHost(), MySQLCredentials: getMySQLCredentials(),
+		Threads: 1, SourceDir: dir})
+	_, err := verifier.Run(ctx)
+	if ErrorKind(err) != ErrInterrupted {
+		t.Fatalf("Expected an interrupted verification and got %v", err)
+	}
+}